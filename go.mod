@@ -2,5 +2,14 @@ module github.com/progpjs/modules/v2
 
 go 1.21
 
-require github.com/progpjs/progpAPI/v2 v2.0.6
-require github.com/progpjs/httpServer/v2 v2.0.6
\ No newline at end of file
+require (
+	github.com/andybalholm/brotli v1.1.0
+	github.com/progpjs/httpServer/v2 v2.0.6
+	github.com/progpjs/progpAPI/v2 v2.0.6
+	github.com/valyala/fasthttp v1.52.0
+)
+
+require (
+	github.com/klauspost/compress v1.17.6 // indirect
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+)
@@ -0,0 +1,303 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"github.com/andybalholm/brotli"
+	"github.com/progpjs/httpServer/v2"
+	"github.com/progpjs/progpAPI/v2"
+	"github.com/valyala/fasthttp"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultCompressionMinSize is the min size, in bytes, of a response body to compress it.
+// Below, the compression gain doesn't worth his cost.
+const DefaultCompressionMinSize = 1024
+
+// DefaultCompressionContentTypes are the content types compressed by default.
+// A trailing "*" matches all the types having this prefix.
+var DefaultCompressionContentTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/manifest+json",
+	"image/svg+xml",
+}
+
+// DefaultCompressionEncodings are the supported encodings, by order of preference.
+var DefaultCompressionEncodings = []string{"br", "gzip", "deflate"}
+
+type JsCompressionOptions struct {
+	// Disabled avoids compressing the responses of the handlers.
+	Disabled bool `json:"disabled"`
+
+	// MinSize is the min size, in bytes, of a response body to compress it.
+	MinSize int `json:"minSize"`
+
+	ContentTypes []string `json:"contentTypes"`
+
+	// Encodings are the encodings which can be used, by order of preference.
+	Encodings []string `json:"encodings"`
+
+	GzipLevel   int `json:"gzipLevel"`
+	BrotliLevel int `json:"brotliLevel"`
+}
+
+// withDefaults returns a copy of the options where the missing values are set.
+func (m JsCompressionOptions) withDefaults() *JsCompressionOptions {
+	if m.MinSize <= 0 {
+		m.MinSize = DefaultCompressionMinSize
+	}
+
+	if m.ContentTypes == nil {
+		m.ContentTypes = DefaultCompressionContentTypes
+	}
+
+	if m.Encodings == nil {
+		m.Encodings = DefaultCompressionEncodings
+	}
+
+	if m.GzipLevel <= 0 {
+		m.GzipLevel = fasthttp.CompressDefaultCompression
+	}
+
+	if m.BrotliLevel <= 0 {
+		m.BrotliLevel = fasthttp.CompressBrotliDefaultCompression
+	}
+
+	return &m
+}
+
+func (m *JsCompressionOptions) isCompressibleContentType(contentType string) bool {
+	contentType, _, _ = strings.Cut(contentType, ";")
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+
+	for _, allowed := range m.ContentTypes {
+		if prefix, isPrefix := strings.CutSuffix(allowed, "*"); isPrefix {
+			if strings.HasPrefix(contentType, prefix) {
+				return true
+			}
+		} else if contentType == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// negotiateContentEncoding returns the encoding, among the supported ones,
+// preferred by the client according to his Accept-Encoding header. Returns "" if none.
+func negotiateContentEncoding(acceptEncoding string, supported []string) string {
+	qualities := make(map[string]float64)
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		if name == "" {
+			continue
+		}
+
+		quality := 1.0
+
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(param, "=")
+
+			if strings.TrimSpace(key) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		qualities[name] = quality
+	}
+
+	best := ""
+	bestQuality := 0.0
+
+	for _, encoding := range supported {
+		quality, ok := qualities[encoding]
+
+		if !ok {
+			if quality, ok = qualities["*"]; !ok {
+				continue
+			}
+		}
+
+		// On equality, the order of the supported encodings wins.
+		if quality > bestQuality {
+			best = encoding
+			bestQuality = quality
+		}
+	}
+
+	return best
+}
+
+// addVaryHeader adds a header name to the Vary header of the response, if not already here.
+func addVaryHeader(resp *fasthttp.Response, name string) {
+	vary := string(resp.Header.Peek("Vary"))
+
+	if vary == "" {
+		resp.Header.Set("Vary", name)
+		return
+	}
+
+	for _, v := range strings.Split(vary, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), name) {
+			return
+		}
+	}
+
+	resp.Header.Set("Vary", vary+", "+name)
+}
+
+// compressResponse compresses the response body, if the client accepts it
+// and if the body matches the options.
+func compressResponse(ctx *fasthttp.RequestCtx, options *JsCompressionOptions) {
+	resp := &ctx.Response
+
+	if options.Disabled || resp.IsBodyStream() || (len(resp.Header.ContentEncoding()) != 0) {
+		return
+	}
+
+	switch resp.StatusCode() {
+	case 204, 206, 304:
+		return
+	}
+
+	body := resp.Body()
+
+	if (len(body) < options.MinSize) || !options.isCompressibleContentType(string(resp.Header.ContentType())) {
+		return
+	}
+
+	// The caches must know that the response depends on the Accept-Encoding header.
+	addVaryHeader(resp, "Accept-Encoding")
+
+	encoding := negotiateContentEncoding(string(ctx.Request.Header.Peek("Accept-Encoding")), options.Encodings)
+
+	var compressed []byte
+
+	switch encoding {
+	case "br":
+		compressed = fasthttp.AppendBrotliBytesLevel(nil, body, options.BrotliLevel)
+	case "gzip":
+		compressed = fasthttp.AppendGzipBytesLevel(nil, body, options.GzipLevel)
+	case "deflate":
+		compressed = fasthttp.AppendDeflateBytesLevel(nil, body, options.GzipLevel)
+	default:
+		return
+	}
+
+	if len(compressed) >= len(body) {
+		return
+	}
+
+	resp.SetBodyRaw(compressed)
+	resp.Header.SetContentEncoding(encoding)
+
+	// The compressed body is no more byte-for-byte identical to the original one.
+	if etag := resp.Header.Peek("ETag"); bytes.HasPrefix(etag, []byte(`"`)) {
+		resp.Header.Set("ETag", "W/"+string(etag))
+	}
+}
+
+// newDecodingReader returns a reader uncompressing the content read from reader.
+func newDecodingReader(reader io.Reader, contentEncoding string) (io.Reader, error) {
+	switch strings.ToLower(contentEncoding) {
+	case "", "identity":
+		return reader, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(reader)
+	case "deflate":
+		return zlib.NewReader(reader)
+	case "br":
+		return brotli.NewReader(reader), nil
+	}
+
+	return nil, fasthttp.ErrContentEncodingUnsupported
+}
+
+// decodeRequestBody replaces a compressed request body by his uncompressed version,
+// which makes the compression transparent for the handlers.
+// Returns false if the body can't be decoded, in which case an error response has been sent.
+func decodeRequestBody(call httpServer.HttpRequest) bool {
+	ctx, err := getFastHttpCtx(call)
+	if err != nil {
+		return true
+	}
+
+	contentEncoding := string(ctx.Request.Header.ContentEncoding())
+
+	if (contentEncoding == "") || (contentEncoding == "identity") {
+		return true
+	}
+
+	reader, err := newDecodingReader(bytes.NewReader(ctx.Request.Body()), contentEncoding)
+
+	if errors.Is(err, fasthttp.ErrContentEncodingUnsupported) {
+		call.SetContentType("text/plain")
+		call.ReturnString(415, "Unsupported Media Type")
+		return false
+	}
+
+	if err == nil {
+		// Reading one byte more than the limit allows detecting too large bodies
+		// without uncompressing them fully, which protects against compression bombs.
+		if maxSize := getHostSettings(call.GetHost()).maxRequestBodySize.Load(); maxSize > 0 {
+			reader = io.LimitReader(reader, maxSize+1)
+		}
+
+		var body []byte
+
+		if body, err = io.ReadAll(reader); err == nil {
+			if isRequestBodyTooLarge(call, len(body)) {
+				returnRequestBodyTooLarge(call)
+				return false
+			}
+
+			ctx.Request.SetBodyRaw(body)
+			ctx.Request.Header.Del("Content-Encoding")
+			ctx.Request.Header.SetContentLength(len(body))
+			return true
+		}
+	}
+
+	call.SetContentType("text/plain")
+	call.ReturnString(400, "Bad Request")
+	return false
+}
+
+// JsHostSetCompression set how the responses of the handlers of this host are compressed.
+func JsHostSetCompression(resHost *progpAPI.SharedResource, options JsCompressionOptions) error {
+	host, ok := resHost.Value.(*httpServer.HttpHost)
+	if !ok {
+		return errors.New("invalid resource")
+	}
+
+	getHostSettings(host).compression.Store(options.withDefaults())
+	return nil
+}
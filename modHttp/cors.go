@@ -0,0 +1,334 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"errors"
+	"github.com/progpjs/httpServer/v2"
+	"github.com/progpjs/progpAPI/v2"
+	"github.com/valyala/fasthttp"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultCorsAllowedMethods are the methods allowed for the cross-origin requests.
+var DefaultCorsAllowedMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+type JsCorsOptions struct {
+	// Disabled removes the CORS headers under this path, which allows excluding a path from the policy of his parent.
+	Disabled bool `json:"disabled"`
+
+	// AllowedOrigins are the origins allowed, as "https://example.com".
+	// "*" allows all the origins, and a "*" inside an origin matches any characters, as "https://*.example.com".
+	AllowedOrigins []string `json:"allowedOrigins"`
+
+	// AllowedOriginPatterns are regular expressions matching the allowed origins.
+	// They must match the whole origin, as if surrounded by "^" and "$".
+	AllowedOriginPatterns []string `json:"allowedOriginPatterns"`
+
+	AllowedMethods []string `json:"allowedMethods"`
+
+	// AllowedHeaders are the request headers allowed. If not set, all the headers asked by the browser are allowed.
+	AllowedHeaders []string `json:"allowedHeaders"`
+
+	// ExposedHeaders are the response headers which can be read by the browser scripts.
+	ExposedHeaders []string `json:"exposedHeaders"`
+
+	AllowCredentials bool `json:"allowCredentials"`
+
+	// MaxAge is the time, in seconds, the browser can keep the response of a preflight request.
+	MaxAge int `json:"maxAge"`
+}
+
+// jsCorsPolicy is the compiled version of the options, applied to the paths starting with pathPrefix.
+type jsCorsPolicy struct {
+	pathPrefix string
+	options    JsCorsOptions
+
+	allowAllOrigins bool
+	origins         map[string]bool
+	originPatterns  []*regexp.Regexp
+
+	methods        map[string]bool
+	allowedMethods string
+	headers        map[string]bool
+	allowedHeaders string
+	exposedHeaders string
+}
+
+func newCorsPolicy(pathPrefix string, options JsCorsOptions) (*jsCorsPolicy, error) {
+	m := &jsCorsPolicy{
+		pathPrefix: strings.TrimSuffix(pathPrefix, "/"),
+		options:    options,
+		origins:    make(map[string]bool),
+		methods:    make(map[string]bool),
+		headers:    make(map[string]bool),
+	}
+
+	for _, origin := range options.AllowedOrigins {
+		origin = strings.ToLower(strings.TrimSpace(origin))
+
+		if origin == "*" {
+			m.allowAllOrigins = true
+		} else if strings.Contains(origin, "*") {
+			pattern := "^" + strings.ReplaceAll(regexp.QuoteMeta(origin), `\*`, ".*") + "$"
+			m.originPatterns = append(m.originPatterns, regexp.MustCompile(pattern))
+		} else {
+			m.origins[origin] = true
+		}
+	}
+
+	for _, pattern := range options.AllowedOriginPatterns {
+		// Anchoring avoids "https://example\.com" allowing "https://example.com.evil.net".
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, err
+		}
+
+		m.originPatterns = append(m.originPatterns, re)
+	}
+
+	// Browsers refuse the credentials when all the origins are allowed,
+	// and sending back the origin instead would allow any site to use them.
+	if m.allowAllOrigins && options.AllowCredentials {
+		return nil, errors.New("the credentials can't be allowed for all the origins")
+	}
+
+	allowed := options.AllowedMethods
+	if allowed == nil {
+		allowed = DefaultCorsAllowedMethods
+	}
+
+	var methods []string
+
+	for _, method := range allowed {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		methods = append(methods, method)
+		m.methods[method] = true
+	}
+
+	m.allowedMethods = strings.Join(methods, ", ")
+
+	for _, header := range options.AllowedHeaders {
+		m.headers[strings.ToLower(strings.TrimSpace(header))] = true
+	}
+
+	m.allowedHeaders = strings.Join(options.AllowedHeaders, ", ")
+	m.exposedHeaders = strings.Join(options.ExposedHeaders, ", ")
+
+	return m, nil
+}
+
+func (m *jsCorsPolicy) isMatchingPath(path string) bool {
+	return (m.pathPrefix == "") || (path == m.pathPrefix) || strings.HasPrefix(path, m.pathPrefix+"/")
+}
+
+func (m *jsCorsPolicy) isOriginAllowed(origin string) bool {
+	if m.allowAllOrigins {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+
+	if m.origins[origin] {
+		return true
+	}
+
+	for _, re := range m.originPatterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// areHeadersAllowed checks the headers listed by the Access-Control-Request-Headers of a preflight request.
+func (m *jsCorsPolicy) areHeadersAllowed(requestHeaders string) bool {
+	if m.options.AllowedHeaders == nil {
+		return true
+	}
+
+	for _, header := range strings.Split(requestHeaders, ",") {
+		header = strings.ToLower(strings.TrimSpace(header))
+
+		if (header != "") && !m.headers[header] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// setAllowOrigin adds the headers telling the browser that this origin is allowed.
+func (m *jsCorsPolicy) setAllowOrigin(resp *fasthttp.Response, origin string) {
+	if m.allowAllOrigins {
+		resp.Header.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+
+	resp.Header.Set("Access-Control-Allow-Origin", origin)
+
+	if m.options.AllowCredentials {
+		resp.Header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// answerPreflight answers a preflight request. If the request isn't allowed,
+// no CORS header is sent, which makes the browser refuse the real request.
+func (m *jsCorsPolicy) answerPreflight(ctx *fasthttp.RequestCtx, origin string) {
+	resp := &ctx.Response
+
+	if !m.allowAllOrigins {
+		addVaryHeader(resp, "Origin")
+	}
+
+	ctx.SetStatusCode(204)
+
+	method := strings.ToUpper(string(ctx.Request.Header.Peek("Access-Control-Request-Method")))
+	requestHeaders := string(ctx.Request.Header.Peek("Access-Control-Request-Headers"))
+
+	if !m.isOriginAllowed(origin) || !m.methods[method] || !m.areHeadersAllowed(requestHeaders) {
+		return
+	}
+
+	m.setAllowOrigin(resp, origin)
+	resp.Header.Set("Access-Control-Allow-Methods", m.allowedMethods)
+
+	if m.options.AllowedHeaders == nil {
+		if requestHeaders != "" {
+			resp.Header.Set("Access-Control-Allow-Headers", requestHeaders)
+			addVaryHeader(resp, "Access-Control-Request-Headers")
+		}
+	} else if m.allowedHeaders != "" {
+		resp.Header.Set("Access-Control-Allow-Headers", m.allowedHeaders)
+	}
+
+	if m.options.MaxAge > 0 {
+		resp.Header.Set("Access-Control-Max-Age", strconv.Itoa(m.options.MaxAge))
+	}
+}
+
+// setResponseHeaders adds the CORS headers to the response of a cross-origin request.
+func (m *jsCorsPolicy) setResponseHeaders(ctx *fasthttp.RequestCtx, origin string) {
+	resp := &ctx.Response
+
+	if !m.allowAllOrigins {
+		addVaryHeader(resp, "Origin")
+	}
+
+	if !m.isOriginAllowed(origin) {
+		return
+	}
+
+	m.setAllowOrigin(resp, origin)
+
+	if m.exposedHeaders != "" {
+		resp.Header.Set("Access-Control-Expose-Headers", m.exposedHeaders)
+	}
+}
+
+// findCorsPolicy returns the policy of the longest prefix matching the path, or nil if none.
+func (m *jsHostSettings) findCorsPolicy(path string) *jsCorsPolicy {
+	m.corsMutex.RLock()
+	defer m.corsMutex.RUnlock()
+
+	// They are sorted from the longest prefix to the shortest.
+	for _, policy := range m.corsPolicies {
+		if policy.isMatchingPath(path) {
+			if policy.options.Disabled {
+				return nil
+			}
+
+			return policy
+		}
+	}
+
+	return nil
+}
+
+func (m *jsHostSettings) setCorsPolicy(policy *jsCorsPolicy) {
+	m.corsMutex.Lock()
+	defer m.corsMutex.Unlock()
+
+	policies := []*jsCorsPolicy{policy}
+
+	for _, p := range m.corsPolicies {
+		if p.pathPrefix != policy.pathPrefix {
+			policies = append(policies, p)
+		}
+	}
+
+	sort.SliceStable(policies, func(i, j int) bool {
+		return len(policies[i].pathPrefix) > len(policies[j].pathPrefix)
+	})
+
+	m.corsPolicies = policies
+}
+
+// withCors returns a handler applying the CORS policy of the host before calling the handler.
+// The preflight requests are answered without calling it.
+func withCors(settings *jsHostSettings, handler httpServer.HttpMiddleware) httpServer.HttpMiddleware {
+	return func(call httpServer.HttpRequest) error {
+		policy := settings.findCorsPolicy(call.Path())
+		if policy == nil {
+			return handler(call)
+		}
+
+		ctx, err := getFastHttpCtx(call)
+		if err != nil {
+			return handler(call)
+		}
+
+		origin := string(ctx.Request.Header.Peek("Origin"))
+
+		if origin == "" {
+			return handler(call)
+		}
+
+		if ctx.IsOptions() && (len(ctx.Request.Header.Peek("Access-Control-Request-Method")) != 0) {
+			policy.answerPreflight(ctx, origin)
+			return nil
+		}
+
+		err = handler(call)
+
+		// The headers are sent once the handler returns, even for a streamed response.
+		policy.setResponseHeaders(ctx, origin)
+
+		return err
+	}
+}
+
+// JsHostSetCors set the CORS policy for the paths of this host starting with pathPrefix.
+// When more than one policy matches a path, the longest prefix wins.
+func JsHostSetCors(resHost *progpAPI.SharedResource, pathPrefix string, options JsCorsOptions) error {
+	host, ok := resHost.Value.(*httpServer.HttpHost)
+	if !ok {
+		return errors.New("invalid resource")
+	}
+
+	policy, err := newCorsPolicy(pathPrefix, options)
+	if err != nil {
+		return err
+	}
+
+	getHostSettings(host).setCorsPolicy(policy)
+	return nil
+}
@@ -0,0 +1,185 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"github.com/valyala/fasthttp"
+	"testing"
+)
+
+func mustCorsPolicy(t *testing.T, options JsCorsOptions) *jsCorsPolicy {
+	t.Helper()
+
+	policy, err := newCorsPolicy("", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return policy
+}
+
+func TestCorsIsOriginAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  JsCorsOptions
+		origin   string
+		expected bool
+	}{
+		{"all", JsCorsOptions{AllowedOrigins: []string{"*"}}, "https://any.net", true},
+		{"exact", JsCorsOptions{AllowedOrigins: []string{"https://example.com"}}, "https://example.com", true},
+		{"exact ignores case", JsCorsOptions{AllowedOrigins: []string{"https://Example.com"}}, "https://EXAMPLE.com", true},
+		{"other origin", JsCorsOptions{AllowedOrigins: []string{"https://example.com"}}, "https://example.net", false},
+		{"other scheme", JsCorsOptions{AllowedOrigins: []string{"https://example.com"}}, "http://example.com", false},
+		{"wildcard", JsCorsOptions{AllowedOrigins: []string{"https://*.example.com"}}, "https://api.example.com", true},
+		{"wildcard needs the suffix", JsCorsOptions{AllowedOrigins: []string{"https://*.example.com"}}, "https://api.example.com.evil.net", false},
+		{"wildcard dot is literal", JsCorsOptions{AllowedOrigins: []string{"https://*.example.com"}}, "https://apiXexample.com", false},
+		{"pattern", JsCorsOptions{AllowedOriginPatterns: []string{`https://[a-z]+\.example\.com`}}, "https://api.example.com", true},
+		{"pattern is anchored at the end", JsCorsOptions{AllowedOriginPatterns: []string{`https://[a-z]+\.example\.com`}}, "https://api.example.com.evil.net", false},
+		{"pattern is anchored at the start", JsCorsOptions{AllowedOriginPatterns: []string{`example\.com`}}, "https://evil-example.com", false},
+		{"pattern with its own anchors", JsCorsOptions{AllowedOriginPatterns: []string{`^https://example\.com$`}}, "https://example.com", true},
+		{"pattern alternatives are all anchored", JsCorsOptions{AllowedOriginPatterns: []string{`https://a\.com|https://b\.com`}}, "https://b.com.evil.net", false},
+		{"nothing allowed", JsCorsOptions{}, "https://example.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mustCorsPolicy(t, tt.options).isOriginAllowed(tt.origin); got != tt.expected {
+				t.Errorf("got %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCorsInvalidOptions(t *testing.T) {
+	if _, err := newCorsPolicy("", JsCorsOptions{AllowedOriginPatterns: []string{"("}}); err == nil {
+		t.Error("an invalid pattern must be an error")
+	}
+
+	if _, err := newCorsPolicy("", JsCorsOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true}); err == nil {
+		t.Error("the credentials must not be allowed for all the origins")
+	}
+}
+
+func TestCorsAreHeadersAllowed(t *testing.T) {
+	tests := []struct {
+		name           string
+		allowedHeaders []string
+		requestHeaders string
+		expected       bool
+	}{
+		{"not set allows all", nil, "X-Custom, Authorization", true},
+		{"empty list allows none", []string{}, "X-Custom", false},
+		{"empty list and no header", []string{}, "", true},
+		{"allowed", []string{"Content-Type", "X-Custom"}, "x-custom, content-type", true},
+		{"spaces and case", []string{" X-Custom "}, "  X-CUSTOM  ", true},
+		{"one refused", []string{"Content-Type"}, "Content-Type, X-Custom", false},
+		{"empty entries ignored", []string{"X-Custom"}, "X-Custom,,", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := mustCorsPolicy(t, JsCorsOptions{AllowedOrigins: []string{"*"}, AllowedHeaders: tt.allowedHeaders})
+
+			if got := policy.areHeadersAllowed(tt.requestHeaders); got != tt.expected {
+				t.Errorf("got %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCorsAnswerPreflight(t *testing.T) {
+	options := JsCorsOptions{
+		AllowedOrigins:   []string{"https://example.com"},
+		AllowedMethods:   []string{"get", "POST"},
+		AllowedHeaders:   []string{"Content-Type", "X-Custom"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	}
+
+	tests := []struct {
+		name           string
+		options        JsCorsOptions
+		origin         string
+		method         string
+		requestHeaders string
+		expected       map[string]string
+	}{
+		{
+			name: "allowed", options: options,
+			origin: "https://example.com", method: "POST", requestHeaders: "x-custom",
+			expected: map[string]string{
+				"Access-Control-Allow-Origin":      "https://example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Allow-Methods":     "GET, POST",
+				"Access-Control-Allow-Headers":     "Content-Type, X-Custom",
+				"Access-Control-Max-Age":           "600",
+				"Vary":                             "Origin",
+			},
+		},
+		{
+			name: "origin refused", options: options,
+			origin: "https://evil.net", method: "POST",
+			expected: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Methods": "", "Vary": "Origin"},
+		},
+		{
+			name: "method refused", options: options,
+			origin: "https://example.com", method: "DELETE",
+			expected: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Methods": ""},
+		},
+		{
+			name: "header refused", options: options,
+			origin: "https://example.com", method: "GET", requestHeaders: "Authorization",
+			expected: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Headers": ""},
+		},
+		{
+			name: "requested headers echoed when not restricted", options: JsCorsOptions{AllowedOrigins: []string{"*"}},
+			origin: "https://any.net", method: "PUT", requestHeaders: "X-One, X-Two",
+			expected: map[string]string{
+				"Access-Control-Allow-Origin":      "*",
+				"Access-Control-Allow-Credentials": "",
+				"Access-Control-Allow-Headers":     "X-One, X-Two",
+				"Access-Control-Max-Age":           "",
+				"Vary":                             "Access-Control-Request-Headers",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.Header.SetMethod("OPTIONS")
+			ctx.Request.Header.Set("Origin", tt.origin)
+			ctx.Request.Header.Set("Access-Control-Request-Method", tt.method)
+
+			if tt.requestHeaders != "" {
+				ctx.Request.Header.Set("Access-Control-Request-Headers", tt.requestHeaders)
+			}
+
+			mustCorsPolicy(t, tt.options).answerPreflight(ctx, tt.origin)
+
+			if ctx.Response.StatusCode() != 204 {
+				t.Errorf("got status %d, expected 204", ctx.Response.StatusCode())
+			}
+
+			for header, expected := range tt.expected {
+				if got := string(ctx.Response.Header.Peek(header)); got != expected {
+					t.Errorf("%s: got %q, expected %q", header, got, expected)
+				}
+			}
+		})
+	}
+}
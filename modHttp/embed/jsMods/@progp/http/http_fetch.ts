@@ -0,0 +1,690 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// noinspection JSUnusedGlobalSymbols
+
+// Implements the web standard fetch API: fetch, Request, Response, Headers and AbortController.
+// Loading this module installs them as globals, which is done by "@progp/http".
+
+import {SharedResource} from "@progp/core"
+
+//region Go plugin
+
+interface ModHttpFetch {
+    fetchResponse(url: string, options: any, body: ArrayBuffer, callback: Function): void
+    fetchResponseWithAbort(url: string, options: any, body: ArrayBuffer, abortRes: SharedResource, callback: Function): void
+    fetchAbort_Create(): SharedResource
+    fetchAbort(abortRes: SharedResource): void
+    fetchResponse_Info(resId: SharedResource): string
+    fetchResponse_ReadAll(resId: SharedResource, callback: Function): void
+    fetchResponse_ReadChunk(resId: SharedResource, callback: Function): void
+}
+
+const modHttp = progpGetModule<ModHttpFetch>("progpjsModHttp")!;
+
+//endregion
+
+//region Abort
+
+function createError(name: string, message: string): Error {
+    let err = new Error(message);
+    err.name = name;
+    return err;
+}
+
+export class AbortSignal {
+    private _aborted = false;
+    private _reason: any = undefined;
+    private readonly listeners: ((ev: any) => void)[] = [];
+
+    onabort: ((ev: any) => void)|null = null;
+
+    get aborted(): boolean {
+        return this._aborted;
+    }
+
+    get reason(): any {
+        return this._reason;
+    }
+
+    addEventListener(type: string, listener: (ev: any) => void) {
+        if (type==="abort") this.listeners.push(listener);
+    }
+
+    removeEventListener(type: string, listener: (ev: any) => void) {
+        if (type!=="abort") return;
+
+        let idx = this.listeners.indexOf(listener);
+        if (idx!==-1) this.listeners.splice(idx, 1);
+    }
+
+    throwIfAborted() {
+        if (this._aborted) throw this._reason;
+    }
+
+    /**
+     * @internal
+     */
+    _abort(reason: any) {
+        if (this._aborted) return;
+
+        this._aborted = true;
+        this._reason = (reason===undefined) ? createError("AbortError", "This operation was aborted") : reason;
+
+        let ev = {type: "abort", target: this};
+        if (this.onabort) this.onabort(ev);
+        for (let listener of this.listeners.slice()) listener(ev);
+    }
+
+    static abort(reason?: any): AbortSignal {
+        let signal = new AbortSignal();
+        signal._abort(reason);
+        return signal;
+    }
+
+    /**
+     * Returns a signal which is aborted after this time, in milliseconds.
+     */
+    static timeout(timeInMs: number): AbortSignal {
+        let signal = new AbortSignal();
+        setTimeout(() => signal._abort(createError("TimeoutError", "The operation timed out")), timeInMs);
+        return signal;
+    }
+}
+
+export class AbortController {
+    readonly signal = new AbortSignal();
+
+    abort(reason?: any) {
+        this.signal._abort(reason);
+    }
+}
+
+//endregion
+
+//region Headers
+
+export type HeadersInit = Headers | [string, string][] | {[key:string]:string};
+
+export class Headers {
+    // Names are stored in lower case, since they are case-insensitive.
+    private readonly list: [string, string][] = [];
+
+    constructor(init?: HeadersInit) {
+        if (!init) return;
+
+        if (init instanceof Headers) {
+            for (let [key, value] of init.list) this.list.push([key, value]);
+        } else if (Array.isArray(init)) {
+            for (let [key, value] of init) this.append(key, value);
+        } else {
+            for (let key in init) this.append(key, init[key]);
+        }
+    }
+
+    append(name: string, value: string) {
+        this.list.push([name.toLowerCase(), String(value).trim()]);
+    }
+
+    delete(name: string) {
+        name = name.toLowerCase();
+
+        for (let i = this.list.length - 1; i >= 0; i--) {
+            if (this.list[i][0]===name) this.list.splice(i, 1);
+        }
+    }
+
+    get(name: string): string|null {
+        name = name.toLowerCase();
+
+        let values = this.list.filter(e => e[0]===name).map(e => e[1]);
+        if (!values.length) return null;
+
+        return values.join(", ");
+    }
+
+    getSetCookie(): string[] {
+        return this.list.filter(e => e[0]==="set-cookie").map(e => e[1]);
+    }
+
+    has(name: string): boolean {
+        name = name.toLowerCase();
+        return this.list.some(e => e[0]===name);
+    }
+
+    set(name: string, value: string) {
+        this.delete(name);
+        this.append(name, value);
+    }
+
+    forEach(callback: (value: string, name: string, headers: Headers) => void) {
+        for (let [name, value] of this.entries()) callback(value, name, this);
+    }
+
+    /**
+     * Returns the headers sorted by name. The values of a header set more
+     * than once are combined, except for "set-cookie".
+     */
+    *entries(): IterableIterator<[string, string]> {
+        let names = Array.from(new Set(this.list.map(e => e[0]))).sort();
+
+        for (let name of names) {
+            if (name==="set-cookie") {
+                for (let value of this.getSetCookie()) yield [name, value];
+            } else {
+                yield [name, this.get(name)!];
+            }
+        }
+    }
+
+    *keys(): IterableIterator<string> {
+        for (let [name] of this.entries()) yield name;
+    }
+
+    *values(): IterableIterator<string> {
+        for (let [, value] of this.entries()) yield value;
+    }
+
+    [Symbol.iterator](): IterableIterator<[string, string]> {
+        return this.entries();
+    }
+}
+
+//endregion
+
+//region Body
+
+/**
+ * A minimal implementation of ReadableStream, for reading a body chunk by chunk.
+ * It can also be consumed with "for await".
+ */
+export class BodyStream {
+    private readonly pull: () => Promise<Uint8Array|undefined>;
+    private readonly onCancel: () => void;
+    private isDone = false;
+    private _locked = false;
+
+    constructor(pull: () => Promise<Uint8Array|undefined>, onCancel?: () => void) {
+        this.pull = pull;
+        this.onCancel = onCancel || (() => {});
+    }
+
+    get locked(): boolean {
+        return this._locked;
+    }
+
+    getReader() {
+        if (this._locked) throw new TypeError("stream is locked");
+        this._locked = true;
+
+        return {
+            read: async (): Promise<{done: boolean, value?: Uint8Array}> => {
+                if (this.isDone) return {done: true};
+
+                let value = await this.pull();
+                if (value) return {done: false, value};
+
+                this.isDone = true;
+                return {done: true};
+            },
+
+            cancel: async () => {
+                this.cancel();
+            },
+
+            releaseLock: () => {
+                this._locked = false;
+            }
+        };
+    }
+
+    cancel() {
+        if (this.isDone) return;
+        this.isDone = true;
+        this.onCancel();
+    }
+
+    async *[Symbol.asyncIterator](): AsyncIterableIterator<Uint8Array> {
+        let reader = this.getReader();
+
+        try {
+            while (true) {
+                let res = await reader.read();
+                if (res.done) return;
+                yield res.value!;
+            }
+        } finally {
+            reader.releaseLock();
+        }
+    }
+}
+
+export type BodyInit = string | ArrayBuffer | ArrayBufferView | BodyStream;
+
+function concatChunks(chunks: Uint8Array[]): ArrayBuffer {
+    let size = 0;
+    for (let chunk of chunks) size += chunk.byteLength;
+
+    let res = new Uint8Array(size);
+    let offset = 0;
+
+    for (let chunk of chunks) {
+        res.set(chunk, offset);
+        offset += chunk.byteLength;
+    }
+
+    return res.buffer;
+}
+
+// Returns the content type deduced from the body, if any.
+function getBodyContentType(body: BodyInit|null|undefined): string|null {
+    if (typeof(body)==="string") return "text/plain;charset=UTF-8";
+    return null;
+}
+
+// Dispose the fetch responses which are garbage collected without having been consumed.
+const gResponseRegistry = new FinalizationRegistry((resId: SharedResource) => progpDispose(resId));
+
+abstract class BodyHolder {
+    protected bodySource: BodyInit|null = null;
+
+    // Is set for the responses returned by fetch, whose body is still on the Go side.
+    protected resId: SharedResource|undefined;
+    protected abortSignal: AbortSignal|undefined;
+
+    private _bodyUsed = false;
+    private _bodyStream: BodyStream|null|undefined;
+
+    get bodyUsed(): boolean {
+        return this._bodyUsed;
+    }
+
+    get body(): BodyStream|null {
+        if (this._bodyStream!==undefined) return this._bodyStream;
+
+        if (this.resId!==undefined) {
+            let resId = this.resId;
+
+            this._bodyStream = new BodyStream(
+                () => {
+                    this._bodyUsed = true;
+                    return this.readChunk(resId);
+                },
+                () => this.disposeResource()
+            );
+        } else if (this.bodySource===null) {
+            this._bodyStream = null;
+        } else if (this.bodySource instanceof BodyStream) {
+            this._bodyStream = this.bodySource;
+        } else {
+            let buffer: ArrayBuffer|null = this.sourceToArrayBuffer(this.bodySource);
+
+            this._bodyStream = new BodyStream(async () => {
+                this._bodyUsed = true;
+                if (!buffer) return undefined;
+
+                let chunk = new Uint8Array(buffer);
+                buffer = null;
+                return chunk;
+            });
+        }
+
+        return this._bodyStream;
+    }
+
+    async arrayBuffer(): Promise<ArrayBuffer> {
+        if (this._bodyUsed) throw new TypeError("body already used");
+        this._bodyUsed = true;
+
+        if (this._bodyStream) return this.readStream(this._bodyStream);
+
+        if (this.resId!==undefined) return this.readAll(this.resId);
+        if (this.bodySource===null) return new ArrayBuffer(0);
+        if (this.bodySource instanceof BodyStream) return this.readStream(this.bodySource);
+
+        return this.sourceToArrayBuffer(this.bodySource);
+    }
+
+    /**
+     * @internal
+     */
+    _hasBody(): boolean {
+        return (this.bodySource!==null) || (this.resId!==undefined);
+    }
+
+    async text(): Promise<string> {
+        return progpBufferToString(await this.arrayBuffer());
+    }
+
+    async json(): Promise<any> {
+        return JSON.parse(await this.text());
+    }
+
+    private sourceToArrayBuffer(source: string|ArrayBuffer|ArrayBufferView): ArrayBuffer {
+        if (typeof(source)==="string") return progpStringToBuffer(source);
+        if (source instanceof ArrayBuffer) return source;
+
+        return source.buffer.slice(source.byteOffset, source.byteOffset + source.byteLength) as ArrayBuffer;
+    }
+
+    private async readStream(stream: BodyStream): Promise<ArrayBuffer> {
+        let chunks: Uint8Array[] = [];
+        for await (let chunk of stream) chunks.push(chunk);
+        return concatChunks(chunks);
+    }
+
+    private readAll(resId: SharedResource): Promise<ArrayBuffer> {
+        return this.abortable((resolve, reject) => {
+            modHttp.fetchResponse_ReadAll(resId, (err: string, buffer: ArrayBuffer) => {
+                this.disposeResource();
+
+                if (err) reject(new TypeError(err));
+                else resolve(buffer);
+            });
+        });
+    }
+
+    private readChunk(resId: SharedResource): Promise<Uint8Array|undefined> {
+        return this.abortable((resolve, reject) => {
+            modHttp.fetchResponse_ReadChunk(resId, (err: string, buffer: ArrayBuffer|undefined) => {
+                if (err) {
+                    this.disposeResource();
+                    reject(new TypeError(err));
+                } else if (buffer===undefined) {
+                    this.disposeResource();
+                    resolve(undefined);
+                } else {
+                    resolve(new Uint8Array(buffer));
+                }
+            });
+        });
+    }
+
+    // Allows the signal given to fetch to abort the body reading.
+    private abortable<T>(f: (resolve: (v: T) => void, reject: (err: any) => void) => void): Promise<T> {
+        let signal = this.abortSignal;
+
+        return new Promise<T>((resolve, reject) => {
+            if (signal && signal.aborted) {
+                this.disposeResource();
+                reject(signal.reason);
+                return;
+            }
+
+            let isDone = false;
+
+            const onAbort = () => {
+                if (isDone) return;
+                isDone = true;
+
+                this.disposeResource();
+                reject(signal!.reason);
+            };
+
+            if (signal) signal.addEventListener("abort", onAbort);
+
+            f(
+                (v) => {
+                    if (signal) signal.removeEventListener("abort", onAbort);
+                    if (!isDone) { isDone = true; resolve(v); }
+                },
+                (err) => {
+                    if (signal) signal.removeEventListener("abort", onAbort);
+                    if (!isDone) { isDone = true; reject(err); }
+                }
+            );
+        });
+    }
+
+    protected disposeResource() {
+        if (this.resId===undefined) return;
+
+        let resId = this.resId;
+        this.resId = undefined;
+
+        gResponseRegistry.unregister(this);
+        progpDispose(resId);
+    }
+}
+
+//endregion
+
+//region Request
+
+export interface RequestInit {
+    method?: string
+    headers?: HeadersInit
+    body?: BodyInit|null
+    redirect?: "follow"|"manual"|"error"
+    signal?: AbortSignal|null
+
+    /**
+     * Not standard. The max time, in milliseconds, for the whole request.
+     */
+    timeout?: number
+
+    /**
+     * Not standard. Allows using a custom certificate authority,
+     * or disabling the certificate verification for development.
+     */
+    tls?: {caFile?: string, caCert?: string, insecureSkipVerify?: boolean}
+}
+
+export class Request extends BodyHolder {
+    readonly url: string;
+    readonly method: string;
+    readonly headers: Headers;
+    readonly redirect: "follow"|"manual"|"error";
+    readonly timeout: number|undefined;
+    readonly tls: RequestInit["tls"];
+
+    constructor(input: string|Request, init?: RequestInit) {
+        super();
+        if (!init) init = {};
+
+        let base = (input instanceof Request) ? input : undefined;
+
+        this.url = base ? base.url : String(input);
+        this.method = (init.method || (base ? base.method : "GET")).toUpperCase();
+        this.headers = new Headers(init.headers || (base ? base.headers : undefined));
+        this.redirect = init.redirect || (base ? base.redirect : "follow");
+        this.abortSignal = init.signal || (base ? base.signal : undefined) || undefined;
+        this.timeout = (init.timeout!==undefined) ? init.timeout : base?.timeout;
+        this.tls = init.tls || base?.tls;
+
+        let body = (init.body!==undefined) ? init.body : (base ? base.bodySource : null);
+
+        if ((body!==null) && ((this.method==="GET") || (this.method==="HEAD"))) {
+            throw new TypeError("Request with GET/HEAD method cannot have body");
+        }
+
+        this.bodySource = body;
+
+        let contentType = getBodyContentType(body);
+        if (contentType && !this.headers.has("content-type")) this.headers.set("content-type", contentType);
+    }
+
+    get signal(): AbortSignal|undefined {
+        return this.abortSignal;
+    }
+
+    clone(): Request {
+        if (this.bodyUsed) throw new TypeError("body already used");
+        return new Request(this);
+    }
+}
+
+//endregion
+
+//region Response
+
+export interface ResponseInit {
+    status?: number
+    statusText?: string
+    headers?: HeadersInit
+}
+
+export class Response extends BodyHolder {
+    readonly status: number;
+    readonly statusText: string;
+    readonly headers: Headers;
+    readonly url: string = "";
+    readonly redirected: boolean = false;
+    readonly type: string = "default";
+
+    constructor(body?: BodyInit|null, init?: ResponseInit) {
+        super();
+        if (!init) init = {};
+
+        this.status = (init.status===undefined) ? 200 : init.status;
+        this.statusText = init.statusText || "";
+        this.headers = new Headers(init.headers);
+        this.bodySource = (body===undefined) ? null : body;
+
+        let contentType = getBodyContentType(body);
+        if (contentType && !this.headers.has("content-type")) this.headers.set("content-type", contentType);
+    }
+
+    get ok(): boolean {
+        return (this.status >= 200) && (this.status < 300);
+    }
+
+    /**
+     * Returns a copy of this response.
+     * Not supported for the responses returned by fetch, whose body is streamed.
+     */
+    clone(): Response {
+        if (this.bodyUsed) throw new TypeError("body already used");
+        if (this.resId!==undefined) throw new TypeError("clone isn't supported for a streamed body");
+
+        return new Response(this.bodySource, {status: this.status, statusText: this.statusText, headers: this.headers});
+    }
+
+    static json(data: any, init?: ResponseInit): Response {
+        let res = new Response(JSON.stringify(data), init);
+        res.headers.set("content-type", "application/json");
+        return res;
+    }
+
+    static redirect(url: string, status?: number): Response {
+        return new Response(null, {status: status || 302, headers: {location: url}});
+    }
+
+    static error(): Response {
+        let res = new Response(null, {status: 0});
+        (res as any).type = "error";
+        return res;
+    }
+
+    /**
+     * @internal
+     */
+    static _fromResource(resId: SharedResource, signal: AbortSignal|undefined): Response {
+        let info = JSON.parse(modHttp.fetchResponse_Info(resId));
+
+        let res = new Response(null, {status: info.status, statusText: info.statusText, headers: info.headers});
+        (res as any).url = info.url;
+        (res as any).redirected = info.redirected;
+        (res as any).type = "basic";
+
+        res.resId = resId;
+        res.abortSignal = signal;
+
+        gResponseRegistry.register(res, resId, res);
+        return res;
+    }
+}
+
+//endregion
+
+//region fetch
+
+export async function fetch(input: string|Request, init?: RequestInit): Promise<Response> {
+    let request = new Request(input, init);
+    let signal = request.signal;
+
+    if (signal && signal.aborted) throw signal.reason;
+
+    let body = new ArrayBuffer(0);
+    if (request._hasBody()) body = await request.arrayBuffer();
+
+    let sendHeaders: {[key:string]:string} = {};
+    for (let [key, value] of request.headers) sendHeaders[key] = value;
+
+    let options = {
+        method: request.method,
+        sendHeaders: sendHeaders,
+        redirect: request.redirect,
+        timeout: request.timeout || 0,
+        tls: request.tls || {}
+    };
+
+    return new Promise<Response>((resolve, reject) => {
+        let isDone = false;
+        let abortRes: SharedResource|undefined;
+
+        const onAbort = () => {
+            if (isDone) return;
+            isDone = true;
+
+            // Stops the Go side, which ends the request without waiting for the server.
+            if (abortRes!==undefined) modHttp.fetchAbort(abortRes);
+            reject(signal!.reason);
+        };
+
+        const onResult = (err: string, resId: SharedResource) => {
+            if (signal) signal.removeEventListener("abort", onAbort);
+
+            if (abortRes!==undefined) {
+                progpDispose(abortRes);
+                abortRes = undefined;
+            }
+
+            if (isDone) {
+                // Has been aborted while waiting.
+                if (!err) progpDispose(resId);
+                return;
+            }
+
+            isDone = true;
+
+            if (err) reject(new TypeError("fetch failed: " + err));
+            else resolve(Response._fromResource(resId, signal));
+        };
+
+        if (signal) {
+            abortRes = modHttp.fetchAbort_Create();
+            signal.addEventListener("abort", onAbort);
+            modHttp.fetchResponseWithAbort(request.url, options, body, abortRes, onResult);
+        } else {
+            modHttp.fetchResponse(request.url, options, body, onResult);
+        }
+    });
+}
+
+//endregion
+
+const g = globalThis as any;
+
+g.fetch = fetch;
+g.Headers = Headers;
+g.Request = Request;
+g.Response = Response;
+
+if (!g.AbortController) {
+    g.AbortController = AbortController;
+    g.AbortSignal = AbortSignal;
+}
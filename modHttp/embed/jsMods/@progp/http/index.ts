@@ -18,19 +18,43 @@
 
 import {SharedResource} from "@progp/core"
 
+// Installs the web standard fetch, Request, Response, Headers and AbortController as globals.
+import "@progp/http_fetch"
+export {Request, Response, Headers, AbortController, AbortSignal} from "@progp/http_fetch"
+
 //region Go plugin
 
 interface ModHttpServer {
     startServer(serverPort: number): void;
     configureServer(serverPort: number, config: any): boolean;
+    serverOnHandlerTimeout(serverPort: number, callback: Function): void;
+    serverOnError(serverPort: number, callback: Function): void;
 
     getHost(serverPort: number, hostName: string): SharedResource
-    VERB_withFunction(hostRes: SharedResource, verb: string, requestPath: string, handler: Function): void
+    hostSetMaxRequestBodySize(hostRes: SharedResource, maxSize: number): void
+    hostSetCompression(hostRes: SharedResource, options: CompressionOptions): void
+    hostSetCors(hostRes: SharedResource, pathPrefix: string, options: CorsOptions): void
+    hostOnError(hostRes: SharedResource, callback: Function): void
+    hostAddRateLimit(hostRes: SharedResource, pathPrefix: string, options: RateLimitOptions): SharedResource
+    hostAddRateLimitWithKey(hostRes: SharedResource, pathPrefix: string, options: RateLimitOptions, keyFunction: Function): SharedResource
+    VERB_withFunction(hostRes: SharedResource, verb: string, requestPath: string, options: RouteOptions, handler: Function): void
+    ALL_withFunction(hostRes: SharedResource, requestPath: string, options: RouteOptions, handler: Function): void
+    requestHandlerDone(resId: SharedResource, isException: boolean, message: string, stack: string): void
     
     returnString(resId: SharedResource, httpCode: number, contentType: string, value: string): void;
+    returnBytes(resId: SharedResource, httpCode: number, contentType: string, value: ArrayBuffer): void;
     responseSetHeader(resId: SharedResource, key: string, value: string): void;
     responseSetCookie(resId: SharedResource, key: string, value: string, options: CookieOptions): void;
 
+    responseStreamBegin(resId: SharedResource, httpCode: number, contentType: string): void;
+    responseStreamWriteString(resId: SharedResource, chunk: string): boolean;
+    responseStreamWriteBytes(resId: SharedResource, chunk: ArrayBuffer): boolean;
+    responseStreamWaitDrain(resId: SharedResource, callback: Function): void;
+    responseStreamEnd(resId: SharedResource): void;
+    responseSseBegin(resId: SharedResource, heartbeatInterval: number): void;
+    responseSseSend(resId: SharedResource, event: ServerSentEvent): boolean;
+    requestLastEventId(resId: SharedResource): string;
+
     requestURI(resId: SharedResource): string;
     requestPath(resId: SharedResource): string;
     requestIP(resId: SharedResource): string;
@@ -38,7 +62,10 @@ interface ModHttpServer {
     requestHost(resId: SharedResource): string;
     requestQueryArgs(resId: SharedResource): any;
     requestPostArgs(resId: SharedResource): any;
+    requestBodyAsString(resId: SharedResource): string;
+    requestBodyAsArrayBuffer(resId: SharedResource): ArrayBuffer;
     requestWildcards(resId: SharedResource): string[]|null;
+    requestParams(resId: SharedResource): {[key:string]:string};
     requestCookie(resId: SharedResource, name: string): HttpCookie|null;
     requestHeaders(resId: SharedResource): any;
     requestCookies(resId: SharedResource): {[key:string]:HttpCookie};
@@ -53,14 +80,35 @@ interface ModHttpServer {
     brotliCompressFile(sourceFile: string, destFile: string, compressionLevel: number, callback: Function): void;
 
     fetch(url: string, options: FetchOptions, callback: Function): void;
-
-    proxyTo(resId: SharedResource, fromPath: string, targetHost: string, options: ProxyTypeOptions): void
+    fetchWithBytes(url: string, options: FetchOptions, body: ArrayBuffer, callback: Function): void;
+    fetchResponse(url: string, options: FetchOptions, body: ArrayBuffer, callback: Function): void;
+    fetchResponse_Info(resId: SharedResource): string;
+    fetchResponse_Cookies(resId: SharedResource): string;
+    fetchResponse_ReadAll(resId: SharedResource, callback: Function): void;
+    fetchResponse_ReadChunk(resId: SharedResource, callback: Function): void;
+    httpClient_Create(options: HttpClientOptions): SharedResource;
+    httpClient_Fetch(resId: SharedResource, url: string, options: FetchOptions, body: ArrayBuffer, callback: Function): void;
+    httpClient_FetchResponse(resId: SharedResource, url: string, options: FetchOptions, body: ArrayBuffer, callback: Function): void;
+    httpClient_Cookies(resId: SharedResource, url: string): string;
+
+    proxyTo(resId: SharedResource, fromPath: string, targetHost: string, options: ProxyTypeOptions): SharedResource
+    proxyToWithHook(resId: SharedResource, fromPath: string, targetHost: string, options: ProxyTypeOptions, hook: Function): SharedResource
+    proxyPool_GetUpstreams(resId: SharedResource): string
+
+    websocket_withFunction(hostRes: SharedResource, requestPath: string, options: WebSocketOptions, onOpen: Function, onTextMessage: Function, onBinaryMessage: Function, onClose: Function): void
+    websocketSendString(resId: SharedResource, message: string): boolean
+    websocketSendBytes(resId: SharedResource, message: ArrayBuffer): boolean
+    websocketClose(resId: SharedResource, code: number, reason: string): void
+    websocketAttach(resId: SharedResource, res: SharedResource): void
 
     fileServer_Create(resId: SharedResource, fromPath: string, dirPath: string, options: ServeFileOptions): SharedResource
     fileServer_RemoveAll(resId: SharedResource): void
     fileServer_RemoveUri(resId: SharedResource, uri: string, data: string): void
     fileServer_VisitCache(resId: SharedResource, callback: Function): void
     fileServer_OnFileNotFound(resId: SharedResource, callback: Function): void
+    fileServer_SetDataFunction(resId: SharedResource, callback: Function): void
+    fileServer_Stats(resId: SharedResource): string
+    fileServer_OnChange(resId: SharedResource, callback: Function): void
 }
 
 interface CookieOptions {
@@ -105,10 +153,18 @@ export class HttpRequest {
     private _requestQueryArgs: any|undefined;
     private _requestPostArgs: any|undefined;
     private _requestWildcards: string[]|null|undefined;
+    private _requestParams: {[key:string]:string}|undefined;
     private _requestHeaders: any|undefined;
     private _requestCookies: {[key:string]:HttpCookie}|undefined;
+    private _requestBody: string|undefined;
     private _contentType: string = "text/html";
 
+    /**
+     * Allows the middlewares to attach values to this request,
+     * which can then be read by the next middlewares and the handler.
+     */
+    readonly state: {[key:string]:any} = {};
+
     constructor(resId: SharedResource, caller: any) {
         if (caller!==gSecureCaller) throw Error("Forbiden call");
         this.resId = resId;
@@ -122,16 +178,114 @@ export class HttpRequest {
         modHttp.returnString(this.resId, httpCode, this._contentType, value);
     }
 
+    /**
+     * Returns a binary response, for example an image or a PDF generated in memory.
+     * If contentType isn't set, then the value set with setContentType is used.
+     */
+    returnBytes(httpCode: number, value: ArrayBuffer, contentType?: string) {
+        if (contentType===undefined) contentType = this._contentType;
+        modHttp.returnBytes(this.resId, httpCode, contentType, value);
+    }
+
+    /**
+     * Send the file, like a static file server does: the ETag and Last-Modified headers are set,
+     * the conditional requests (If-None-Match, If-Modified-Since) get a 304,
+     * and the range requests (Range, If-Range) get a 206 partial response.
+     */
     sendFile(filePath: string) {
         modHttp.sendFile(this.resId, filePath);
     }
 
+    /**
+     * Like sendFile, but the file is sent with this content type and content encoding.
+     * Allows sending a pre-compressed file, for which the ranges are ranges of the compressed content.
+     */
     sendFileAsIs(filePath: string, mimeType?: string, contentEncoding?: string) {
         if (mimeType===undefined) mimeType = "";
         if (contentEncoding===undefined) contentEncoding = "";
         modHttp.sendFileAsIs(this.resId, filePath, mimeType, contentEncoding);
     }
 
+    /**
+     * Start a streamed response. The headers are sent immediately and the body
+     * is sent chunk by chunk, using chunked transfer encoding.
+     * Once started, use write to send a chunk and endStream to terminate the response.
+     */
+    beginStream(httpCode: number, headers?: {[key:string]:string}) {
+        if (headers) {
+            for (let key in headers) this.setHeader(key, headers[key]);
+        }
+
+        modHttp.responseStreamBegin(this.resId, httpCode, this._contentType);
+    }
+
+    /**
+     * Send a chunk of a streamed response.
+     * Returns false if the client is too slow to receive the previous chunks,
+     * in which case this chunk isn't sent and must be written again once drain resolves.
+     * Use writeAsync to have this done automatically.
+     */
+    write(chunk: string|ArrayBuffer): boolean {
+        if (typeof(chunk)==="string") return modHttp.responseStreamWriteString(this.resId, chunk);
+        else return modHttp.responseStreamWriteBytes(this.resId, chunk);
+    }
+
+    /**
+     * Resolves once a chunk can be written, which allows waiting for a slow client.
+     * Rejects if the stream is closed, which occurs when the client is gone.
+     */
+    drain(): Promise<void> {
+        return new Promise<void>((resolve, reject) => {
+            modHttp.responseStreamWaitDrain(this.resId, (err: string) => {
+                if (err) reject(err);
+                else resolve();
+            });
+        });
+    }
+
+    /**
+     * Send a chunk of a streamed response, waiting for the client if he is too slow.
+     * Allows writing large responses without keeping them in memory:
+     *      for (let row of rows) await req.writeAsync(toCsvLine(row));
+     */
+    async writeAsync(chunk: string|ArrayBuffer): Promise<void> {
+        while (!this.write(chunk)) {
+            await this.drain();
+        }
+    }
+
+    /**
+     * Terminate a streamed response.
+     */
+    endStream() {
+        modHttp.responseStreamEnd(this.resId);
+    }
+
+    /**
+     * Start a Server-Sent Events stream. The connection stays open after the handler
+     * returns, until the stream is closed or the client is gone.
+     * A heartbeat comment is sent every 15 seconds, unless options.heartbeatInterval
+     * says otherwise (in seconds, a negative value disables it).
+     */
+    beginEventStream(options?: EventStreamOptions): EventStream {
+        if (!options) options = {};
+
+        if (options.headers) {
+            for (let key in options.headers) this.setHeader(key, options.headers[key]);
+        }
+
+        modHttp.responseSseBegin(this.resId, options.heartbeatInterval || 0);
+        return new EventStream(this.resId);
+    }
+
+    /**
+     * Returns the value of the "Last-Event-ID" header, which is sent by
+     * the client when reconnecting to an event stream, or an empty string.
+     */
+    lastEventId(): string {
+        return modHttp.requestLastEventId(this.resId);
+    }
+
     setHeader(key: string, value: string) {
         modHttp.responseSetHeader(this.resId, key, value);
     }
@@ -246,6 +400,33 @@ export class HttpRequest {
         return this._requestPostArgs;
     }
 
+    /**
+     * Returns the raw body of the request as a string.
+     * Throws an error, and send a 413 response, if the body exceeds the max size set for the host.
+     */
+    requestBodyAsString(): string {
+        if (this._requestBody===undefined) {
+            return this._requestBody = modHttp.requestBodyAsString(this.resId);
+        }
+
+        return this._requestBody;
+    }
+
+    /**
+     * Returns the raw body of the request as an ArrayBuffer.
+     * Throws an error, and send a 413 response, if the body exceeds the max size set for the host.
+     */
+    requestBodyAsArrayBuffer(): ArrayBuffer {
+        return modHttp.requestBodyAsArrayBuffer(this.resId);
+    }
+
+    /**
+     * Returns the body of the request decoded as json.
+     */
+    requestJson(): any {
+        return JSON.parse(this.requestBodyAsString());
+    }
+
     requestWildcards(): any {
         if (this._requestWildcards===undefined) {
             return this._requestWildcards = modHttp.requestWildcards(this.resId);
@@ -254,6 +435,18 @@ export class HttpRequest {
         return this._requestWildcards;
     }
 
+    /**
+     * Returns the values of the named params of the route.
+     * For example with the route "/users/:userId/posts/:postId" it returns {userId: "...", postId: "..."}.
+     */
+    requestParams(): {[key:string]:string} {
+        if (this._requestParams===undefined) {
+            return this._requestParams = modHttp.requestParams(this.resId);
+        }
+
+        return this._requestParams;
+    }
+
     requestCookies(): {[key:string]:HttpCookie} {
         if (this._requestCookies===undefined) {
             let res = modHttp.requestCookies(this.resId);
@@ -277,6 +470,72 @@ export class HttpRequest {
     }
 }
 
+export interface ServerSentEvent {
+    event?: string
+    id?: string
+    data?: string
+
+    /**
+     * The reconnection time, in milliseconds, the client must use.
+     */
+    retry?: number
+}
+
+export interface EventStreamOptions {
+    /**
+     * Time, in seconds, between two heartbeat comments. Default is 15 seconds.
+     */
+    heartbeatInterval?: number
+
+    headers?: {[key:string]:string}
+}
+
+export class EventStream {
+    private readonly resId: SharedResource
+    private _isClosed = false
+
+    constructor(resId: SharedResource) {
+        this.resId = resId
+    }
+
+    get isClosed(): boolean {
+        return this._isClosed
+    }
+
+    /**
+     * Send an event. If data isn't a string, then it's sent as json.
+     * Returns false if the event hasn't been sent, because the stream is closed, which occurs
+     * when the client is gone, or because the client is too slow. Use isClosed to know which.
+     */
+    send(data: any, event?: string, id?: string): boolean {
+        if (typeof(data)!=="string") data = JSON.stringify(data);
+        return this.sendEvent({data, event, id});
+    }
+
+    sendEvent(event: ServerSentEvent): boolean {
+        if (this._isClosed) return false;
+
+        try {
+            // Returns false if the client is too slow, but the stream is still open.
+            return modHttp.responseSseSend(this.resId, {
+                event: event.event || "",
+                id: event.id || "",
+                data: event.data || "",
+                retry: event.retry || 0
+            });
+        } catch (e) {
+            this._isClosed = true;
+            return false;
+        }
+    }
+
+    close() {
+        if (this._isClosed) return;
+        this._isClosed = true;
+        modHttp.responseStreamEnd(this.resId);
+    }
+}
+
 export interface HttCertificate {
     hostName: string
 
@@ -291,10 +550,266 @@ export interface HttpServerConfig {
     /**
      * Allows to hide server error and don't write message in the console.
      * It's important to hide error, without what attackers can slow down the server by a lot with fake calls.
+     * If set, the default error response doesn't show the message and the stack of the error.
      */
     hideErrors?: boolean
     enableHttps?: boolean
     certificates: HttCertificate[]
+
+    /**
+     * The max number of requests processed at the same time for a client ip,
+     * above which a 429 response is returned. Unlimited if not set.
+     * It's a cap on the concurrent requests, not on the connections: the idle keep-alive connections aren't counted.
+     */
+    maxRequestsPerIp?: number
+
+    /**
+     * The time, in milliseconds, the handlers have to respond. Unlimited if not set.
+     * Once elapsed, a handlerTimeoutStatus response is sent, and the request can't be used anymore by the handler.
+     * Can be changed for a route with RouteOptions.
+     */
+    handlerTimeout?: number
+
+    /**
+     * The status code sent when a handler doesn't respond in time, 503 or 504. Default is 504.
+     */
+    handlerTimeoutStatus?: 503|504
+}
+
+export interface RouteOptions {
+    /**
+     * The time, in milliseconds, the handler has to respond.
+     * If not set, then the handlerTimeout of the server is used. If negative, then there is no timeout.
+     */
+    timeout?: number
+
+    /**
+     * The status code sent on timeout, 503 or 504. Default is the one of the server.
+     */
+    timeoutStatus?: 503|504
+}
+
+export interface HandlerTimeoutEvent {
+    hostname: string
+    method: string
+    path: string
+
+    /**
+     * The path the handler is bound to, as "/users/:userId".
+     */
+    route: string
+
+    /**
+     * The time, in milliseconds, the handler had to respond.
+     */
+    timeout: number
+}
+
+/**
+ * Tells why a handler has failed:
+ * - "exception": the handler has thrown an exception.
+ * - "timeout": the handler hasn't responded in time.
+ * - "noResponse": the handler has ended without sending a response.
+ * - "error": a handler implemented by the server has failed, like the file server.
+ */
+export type HttpErrorKind = "exception"|"timeout"|"noResponse"|"error"
+
+export interface HttpErrorEvent {
+    kind: HttpErrorKind
+    message: string
+    stack?: string
+
+    /**
+     * The status code of the default response, 500 or the timeout status.
+     */
+    statusCode: number
+
+    hostname: string
+    method: string
+    path: string
+    ip: string
+
+    /**
+     * The path the handler is bound to, as "/users/:userId".
+     */
+    route: string
+}
+
+/**
+ * The response sent in place of the failed handler.
+ * The handler can't send his response anymore.
+ */
+export interface HttpErrorResponse {
+    /**
+     * Default is the statusCode of the event.
+     */
+    statusCode?: number
+
+    /**
+     * Default is "text/plain; charset=utf-8".
+     */
+    contentType?: string
+
+    body?: string
+    headers?: {[key: string]: string}
+}
+
+/**
+ * Renders the response of an error, or returns nothing to let the next error handler do it.
+ */
+export type HttpErrorHandler = (error: HttpErrorEvent) => HttpErrorResponse|void|Promise<HttpErrorResponse|void>
+
+function bindErrorHandler(f: HttpErrorHandler) {
+    return (resId: SharedResource, json: string) => {
+        Promise.resolve().then(() => f(JSON.parse(json))).then(
+            (res) => {
+                if (res) progpReturnString(resId, JSON.stringify(res));
+                else progpReturnVoid(resId);
+            },
+            (err) => progpReturnError(resId, String(err))
+        );
+    };
+}
+
+export interface CompressionOptions {
+    /**
+     * If true, the responses aren't compressed.
+     */
+    disabled?: boolean
+
+    /**
+     * The min size, in bytes, of a response to compress it. Default is 1024.
+     */
+    minSize?: number
+
+    /**
+     * The content types which are compressed. A trailing "*" matches all the types having this prefix.
+     * Default is text/*, application/json, application/javascript, application/xml,
+     * application/manifest+json and image/svg+xml.
+     */
+    contentTypes?: string[]
+
+    /**
+     * The encodings which can be used, by order of preference.
+     * Default is ["br", "gzip", "deflate"].
+     */
+    encodings?: ("br"|"gzip"|"deflate")[]
+
+    /**
+     * Compression level for gzip and deflate, from 1 to 9. Default is 6.
+     */
+    gzipLevel?: number
+
+    /**
+     * Compression level for brotli, from 1 to 11. Default is 4.
+     */
+    brotliLevel?: number
+}
+
+export interface CorsOptions {
+    /**
+     * If true, no CORS header is sent under this path prefix,
+     * which allows excluding a path from the policy of his parent.
+     */
+    disabled?: boolean
+
+    /**
+     * The origins allowed, as "https://example.com".
+     * "*" allows all the origins, and a "*" inside an origin matches any characters.
+     * Ex: ["https://example.com", "https://*.example.com"]
+     */
+    allowedOrigins?: string[]
+
+    /**
+     * Regular expressions (Go syntax) matching the allowed origins.
+     * They must match the whole origin, "^" and "$" being implied.
+     * Ex: ["https://[a-z]+\\.example\\.com"]
+     */
+    allowedOriginPatterns?: string[]
+
+    /**
+     * The methods allowed. Default is GET, HEAD, POST, PUT, PATCH and DELETE.
+     */
+    allowedMethods?: string[]
+
+    /**
+     * The request headers allowed. If not set, all the headers asked by the browser are allowed.
+     */
+    allowedHeaders?: string[]
+
+    /**
+     * The response headers which can be read by the browser scripts.
+     */
+    exposedHeaders?: string[]
+
+    /**
+     * If true, the browser can send the cookies and the authorization headers.
+     * Can't be used when all the origins are allowed.
+     */
+    allowCredentials?: boolean
+
+    /**
+     * The time, in seconds, the browser can keep the response of a preflight request.
+     */
+    maxAge?: number
+}
+
+export interface RateLimitOptions {
+    /**
+     * "tokenBucket" allows bursts of "burst" requests, then "limit" requests per window.
+     * "slidingWindow" allows "limit" requests during any window.
+     * Default is "tokenBucket".
+     */
+    algorithm?: "tokenBucket"|"slidingWindow"
+
+    /**
+     * The number of requests allowed during the window.
+     */
+    limit: number
+
+    /**
+     * The duration, in milliseconds, of the window. Default is 1000.
+     */
+    window?: number
+
+    /**
+     * The max number of requests allowed at once by the token bucket. Default is the limit.
+     */
+    burst?: number
+
+    /**
+     * How the clients are identified: by "ip", which is the default, or by "header".
+     * Ignored if "key" is set.
+     */
+    keyBy?: "ip"|"header"
+
+    /**
+     * The request header identifying the clients, when keyBy is "header", like "Authorization".
+     * The ip is used for the requests without this header.
+     */
+    header?: string
+
+    /**
+     * Computes the key identifying the client of a request.
+     * Returning an empty string means that the request isn't limited.
+     * If it fails, or takes more than 500 ms since it runs for each request, the ip is used.
+     */
+    key?: (req: ProxyRequestInfo) => string|Promise<string>
+}
+
+export class RateLimit {
+    private readonly resId: SharedResource
+
+    constructor(resId: SharedResource) {
+        this.resId = resId
+    }
+
+    /**
+     * Remove the rate limit.
+     */
+    dispose() {
+        progpDispose(this.resId)
+    }
 }
 
 export interface FetchOptions {
@@ -352,31 +867,512 @@ export interface FetchOptions {
      * Isn't set when no request are set.
      */
     userAgent?: string
-}
 
-export interface ProxyTypeOptions {
     /**
-     * Allows to avoid including url from this path.
+     * The body to send. An object which isn't an ArrayBuffer is sent as json.
      */
-    excludeSubPaths?: boolean
-}
-
-export interface ServeFileOptions {
-
-}
+    body?: string|ArrayBuffer|object
 
-export class HttpServer {
-    private readonly serverPort: number;
-    private isStarted: boolean = false;
-    private config: HttpServerConfig|undefined;
+    /**
+     * Values sent as an url-encoded body.
+     */
+    form?: {[key:string]:string}
 
-    constructor(serverPort: number) {
-        this.serverPort = serverPort;
-    }
+    /**
+     * Values and files sent as a multipart/form-data body.
+     */
+    multipart?: FetchMultipart
 
-    configure(config: HttpServerConfig) {
-        this.config = config
-    }
+    /**
+     * The max time, in milliseconds, for the whole request, redirects included.
+     * Default is no timeout.
+     */
+    timeout?: number
+
+    /**
+     * "follow" (default) follows the redirects, "manual" returns the redirect response,
+     * and "error" rejects the promise if a redirect occurs.
+     */
+    redirect?: "follow"|"manual"|"error"
+
+    /**
+     * The max number of redirects followed. Default is 10.
+     */
+    maxRedirects?: number
+
+    tls?: FetchTlsOptions
+
+    /**
+     * How the body is returned:
+     * - "text" (default) returns it inside the "body" field.
+     * - "arrayBuffer" returns it inside the "bodyBuffer" field, which allows binary content.
+     * - "stream" gives it chunk by chunk to "onChunk", without keeping it in memory.
+     */
+    responseType?: "text"|"arrayBuffer"|"stream"
+
+    /**
+     * Receive the chunks of the body when responseType is "stream".
+     * If it returns a promise, then the next chunk is read once this promise resolves.
+     */
+    onChunk?: (chunk: ArrayBuffer) => void|Promise<void>
+}
+
+export interface FetchMultipart {
+    fields?: {[key:string]:string}
+    files?: FetchMultipartFile[]
+}
+
+export interface FetchMultipartFile {
+    fieldName: string
+    filePath: string
+
+    /**
+     * The name sent for this file. Default is the name of the file on disk.
+     */
+    fileName?: string
+
+    /**
+     * Default is deduced from the file extension.
+     */
+    contentType?: string
+}
+
+export interface FetchTlsOptions {
+    /**
+     * Path of a PEM file containing the certificate authorities to trust,
+     * in addition to the system ones.
+     */
+    caFile?: string
+
+    /**
+     * Like caFile, but directly contains the PEM certificates.
+     */
+    caCert?: string
+
+    /**
+     * Disable the certificate verification. Must only be used for development.
+     */
+    insecureSkipVerify?: boolean
+}
+
+export interface ProxyTypeOptions {
+    /**
+     * Allows to avoid including url from this path.
+     */
+    excludeSubPaths?: boolean
+
+    /**
+     * The max time, in seconds, to wait for the target.
+     * Default is 60 seconds.
+     */
+    timeout?: number
+
+    /**
+     * If true, remove the proxied path from the path sent to the target.
+     * For example with proxyTo("/api", ...) the url "/api/users" is sent as "/users".
+     */
+    stripPrefix?: boolean
+
+    /**
+     * Is added before the path sent to the target.
+     */
+    pathPrefix?: string
+
+    /**
+     * If true, add the headers X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host.
+     */
+    forwardedHeaders?: boolean
+
+    /**
+     * Headers added to the request sent to the target.
+     */
+    requestHeaders?: {[key:string]:string}
+
+    /**
+     * Headers added to the response returned by the target, replacing the existing ones.
+     */
+    responseHeaders?: {[key:string]:string}
+
+    /**
+     * Headers removed from the response returned by the target.
+     */
+    removeResponseHeaders?: string[]
+
+    /**
+     * Is called before each proxied request, and can veto it by returning the response to send:
+     * false for a "403 Forbidden", a string for a 403 with this text, or a ProxyRefusal.
+     * If it throws, or doesn't return within 5 seconds, then a "500 Internal Server Error" is returned.
+     */
+    onRequest?: (info: ProxyRequestInfo) => ProxyHookResult|Promise<ProxyHookResult>
+
+    /**
+     * The targets between which the requests are balanced.
+     * Is filled when giving an array of targets to proxyTo.
+     */
+    upstreams?: ProxyUpstream[]
+
+    /**
+     * How the target is selected when there is more than one.
+     * Default is "roundRobin".
+     */
+    strategy?: "roundRobin"|"leastConnections"|"weighted"
+
+    /**
+     * Allows to periodically check the targets. The unhealthy ones are not used.
+     */
+    healthCheck?: ProxyHealthCheck
+
+    /**
+     * The number of consecutive failures (connection error, 502, 503 or 504)
+     * after which a target is ejected. Default is 0, which means never.
+     */
+    maxFails?: number
+
+    /**
+     * The time, in seconds, a target is ejected after too many failures.
+     * Default is 30 seconds.
+     */
+    failTimeout?: number
+}
+
+export interface ProxyUpstream {
+    target: string
+
+    /**
+     * Is used by the "weighted" strategy. Default is 1.
+     */
+    weight?: number
+}
+
+export interface ProxyHealthCheck {
+    /**
+     * The path requested on each target, for example "/health".
+     */
+    path: string
+
+    /**
+     * Time, in milliseconds, between two checks. Default is 5000.
+     */
+    interval?: number
+
+    /**
+     * Time, in milliseconds, to wait for the response. Default is 2000.
+     */
+    timeout?: number
+
+    /**
+     * The expected status code. If not set, all codes from 200 to 399 are accepted.
+     */
+    expectedStatus?: number
+}
+
+export interface ProxyUpstreamHealth {
+    target: string
+    weight: number
+    isHealthy: boolean
+    isEjected: boolean
+    activeConnections: number
+    consecutiveFailures: number
+    totalRequests: number
+    totalFailures: number
+}
+
+export class ProxyPool {
+    private readonly resId: SharedResource
+
+    constructor(resId: SharedResource) {
+        this.resId = resId
+    }
+
+    /**
+     * Returns the current state of each target.
+     */
+    getUpstreams(): ProxyUpstreamHealth[] {
+        return JSON.parse(modHttp.proxyPool_GetUpstreams(this.resId))
+    }
+
+    /**
+     * Stop the health checks.
+     */
+    dispose() {
+        progpDispose(this.resId)
+    }
+}
+
+export interface ProxyRequestInfo {
+    method: string
+    uri: string
+    path: string
+    ip: string
+    hostname: string
+    headers: {[key:string]:string}
+}
+
+/**
+ * The response sent when the onRequest hook of a proxy vetoes a request.
+ */
+export interface ProxyRefusal {
+    /**
+     * Default is 403.
+     */
+    status?: number
+
+    /**
+     * Default is the text of the status, like "Forbidden".
+     */
+    body?: string
+
+    /**
+     * Default is "text/plain".
+     */
+    contentType?: string
+}
+
+export type ProxyHookResult = boolean|string|ProxyRefusal|void;
+
+export interface WebSocketOptions {
+    /**
+     * Time, in seconds, between two pings sent to the client.
+     * The connection is closed if nothing is received during two intervals.
+     * Default is 30 seconds, a negative value disables the pings.
+     */
+    pingInterval?: number
+
+    /**
+     * The max size, in bytes, of a message sent by the client. Default is 4Mb.
+     */
+    maxMessageSize?: number
+
+    /**
+     * The sub-protocols supported. The first one also asked by the client is selected.
+     */
+    protocols?: string[]
+}
+
+export interface WebSocketOpenInfo {
+    uri: string
+    path: string
+    ip: string
+    hostname: string
+    headers: {[key:string]:string}
+    params: {[key:string]:string}|null
+
+    /**
+     * The selected sub-protocol, or an empty string.
+     */
+    protocol: string
+}
+
+export interface WebSocketCloseInfo {
+    code: number
+    reason: string
+
+    /**
+     * Is false if the connection has been lost without closing handshake.
+     */
+    wasClean: boolean
+}
+
+export interface WebSocketHandlers {
+    onOpen?: (ws: WebSocketConnection, info: WebSocketOpenInfo) => void
+    onMessage?: (ws: WebSocketConnection, message: string|ArrayBuffer) => void
+    onClose?: (ws: WebSocketConnection, info: WebSocketCloseInfo) => void
+}
+
+export class WebSocketConnection {
+    private readonly resId: SharedResource
+    private _isOpen = true
+
+    /**
+     * Allows storing values bound to this connection.
+     */
+    readonly state: {[key:string]:any} = {}
+
+    constructor(resId: SharedResource) {
+        this.resId = resId
+    }
+
+    get isOpen(): boolean {
+        return this._isOpen
+    }
+
+    /**
+     * Send a message, without waiting for it to be written.
+     * Returns false if the client is too slow to receive the previous messages,
+     * in which case this message isn't sent and must be sent again later.
+     */
+    send(message: string|ArrayBuffer): boolean {
+        if (typeof(message)==="string") return modHttp.websocketSendString(this.resId, message);
+        else return modHttp.websocketSendBytes(this.resId, message);
+    }
+
+    /**
+     * Bind a resource to this connection, which is disposed once the connection ends.
+     */
+    attach(res: SharedResource) {
+        modHttp.websocketAttach(this.resId, res);
+    }
+
+    /**
+     * Start the closing handshake, once the messages already sent are written.
+     * The code must be one of the codes defined by RFC 6455, like 1000, 1001, 1008 or 1011, or between 3000 and 4999.
+     */
+    close(code?: number, reason?: string) {
+        if (!this._isOpen) return;
+        modHttp.websocketClose(this.resId, code || 1000, reason || "");
+    }
+
+    /**
+     * @internal
+     */
+    _markClosed() {
+        this._isOpen = false
+    }
+}
+
+export interface ServeFileOptions {
+    /**
+     * By default, if a file "name.br" or "name.gz" exists next to "name", then it's sent
+     * to the clients accepting this encoding. This option disables it.
+     * A pre-compressed file older than the original one is ignored.
+     */
+    disablePreCompressed?: boolean
+
+    /**
+     * The max-age, in seconds, of the Cache-Control header, by file extension.
+     * The key "*" is used for the other extensions, and a negative value sends "no-cache".
+     * Ex: {".html": -1, ".js": 31536000, "*": 3600}
+     */
+    maxAge?: {[extension: string]: number}
+
+    /**
+     * If true, the ETag header isn't sent and If-None-Match is ignored.
+     */
+    disableEtag?: boolean
+
+    /**
+     * If true, the Last-Modified header isn't sent and If-Modified-Since is ignored.
+     */
+    disableLastModified?: boolean
+
+    /**
+     * The files searched when a directory is requested. Default is ["index.html"].
+     */
+    indexFiles?: string[]
+
+    /**
+     * If true, the list of the files is returned when a directory has no index file.
+     */
+    directoryListing?: boolean
+
+    /**
+     * By default, the files whose name, or the name of a parent directory, starts with a dot aren't served.
+     */
+    showDotFiles?: boolean
+
+    /**
+     * For single-page-apps: the file sent, with a 200 status, when a path without extension
+     * isn't found, which allows the client-side router to handle it. The missing assets,
+     * which have an extension, are still 404. Relative to the served directory, unless absolute.
+     * Ex: "index.html"
+     */
+    spaFallback?: string
+
+    /**
+     * The files sent for an error, by status code. Only 404 and 500 are used.
+     * Relative to the served directory, unless absolute.
+     * Ex: {404: "404.html", 500: "500.html"}
+     */
+    errorPages?: {[statusCode: number]: string}
+
+    /**
+     * Bounds the memory used to keep the files.
+     */
+    cache?: FileServerCacheOptions
+
+    /**
+     * If true, the files are watched, and the cache entries of the files modified on the disk are evicted.
+     * Only supported on Linux.
+     */
+    watch?: boolean
+}
+
+export interface FileServerChange {
+    event: "create"|"change"|"remove"
+    filePath: string
+
+    /**
+     * The uri of the file, as requested by the clients.
+     */
+    uri: string
+
+    isDir: boolean
+}
+
+export interface FileServerCacheOptions {
+    /**
+     * The max size, in bytes, of the content kept in memory for all the files. Unlimited if not set.
+     * When reached, entries are evicted according to the policy.
+     */
+    maxSize?: number
+
+    /**
+     * The max size, in bytes, of a file kept in memory. Default is 1MB.
+     * The bigger files are read from the disk for each request.
+     */
+    maxEntrySize?: number
+
+    /**
+     * Selects the entries evicted when maxSize is reached:
+     * "lru" evicts the entries requested the least recently, "lfu" the entries requested the least often.
+     * Default is "lru".
+     */
+    policy?: "lru"|"lfu"
+
+    /**
+     * The time, in milliseconds, after which a file is read again from the disk. Never if not set.
+     */
+    ttl?: number
+}
+
+export interface FileServerStats {
+    /**
+     * The number of files in the cache.
+     */
+    entries: number
+
+    /**
+     * The size, in bytes, of the content kept in memory.
+     */
+    memorySize: number
+
+    hits: number
+    misses: number
+
+    /**
+     * hits / (hits + misses), or 0 if there was no request.
+     */
+    hitRatio: number
+
+    /**
+     * The number of entries removed because the max size was reached.
+     */
+    evictions: number
+}
+
+export class HttpServer {
+    private readonly serverPort: number;
+    private isStarted: boolean = false;
+    private config: HttpServerConfig|undefined;
+    private readonly hosts: {[hostName:string]:HttpHost} = {};
+
+    constructor(serverPort: number) {
+        this.serverPort = serverPort;
+    }
+
+    configure(config: HttpServerConfig) {
+        this.config = config
+    }
 
     /**
      * Register a https certificate.
@@ -431,38 +1427,322 @@ export class HttpServer {
         this.isStarted = true;
     }
 
+    /**
+     * Set the function called when a handler doesn't respond in time,
+     * which allows knowing which routes time out.
+     */
+    onHandlerTimeout(f: (event: HandlerTimeoutEvent) => void) {
+        modHttp.serverOnHandlerTimeout(this.serverPort, (_: string, raw: string) => {
+            f(JSON.parse(raw))
+        })
+    }
+
+    /**
+     * Set the function rendering the response when a handler fails, if the error handler of his host doesn't.
+     * Like for the host, it has 2 seconds to return the response.
+     * When no error handler renders it, a 500 response is sent, or the timeout status on timeout,
+     * which shows the details of the error unless hideErrors is set.
+     */
+    onError(f: HttpErrorHandler) {
+        modHttp.serverOnError(this.serverPort, bindErrorHandler(f));
+    }
+
     getHost(hostName: string): HttpHost {
+        // Is cached, which allows sharing the middlewares.
+        let host = this.hosts[hostName];
+        if (host) return host;
+
         let hostResId = modHttp.getHost(this.serverPort, hostName);
-        return new HttpHost(hostResId);
+        return this.hosts[hostName] = new HttpHost(hostResId);
     }
 }
 
+interface MiddlewareEntry {
+    path: string
+    middleware: HttpMiddleware
+}
+
+/**
+ * Returns true if the path is the same as the prefix or is a sub-path of this prefix.
+ */
+function isPathInside(prefix: string, path: string): boolean {
+    if (prefix==="/") return true;
+    if (!path.startsWith(prefix)) return false;
+    if (path.length===prefix.length) return true;
+    return (path[prefix.length]==="/") || prefix.endsWith("/");
+}
+
 export class HttpHost {
     private readonly hostResId: SharedResource;
+    private readonly middlewares: MiddlewareEntry[] = [];
 
     constructor(hostResId: SharedResource) {
         this.hostResId = hostResId;
     }
 
-    verb(verb: string, requestPath: string, handler: HttpRequestHandler): void {
-        modHttp.VERB_withFunction(this.hostResId, verb, requestPath, (_: string, resId: SharedResource) => {
-            handler(new HttpRequest(resId, gSecureCaller));
+    /**
+     * Add a middleware which is executed before the handlers of the routes matching this path.
+     * The middlewares are executed in the order they are added, and each of them must
+     * call "next" to continue, or send a response in order to stop the chain.
+     *
+     * If path is omitted, then the middleware is executed for all the routes.
+     */
+    use(path: string|HttpMiddleware, middleware?: HttpMiddleware): void {
+        if (typeof(path)==="function") {
+            middleware = path;
+            path = "/";
+        }
+
+        if (!path) path = "/";
+        this.middlewares.push({path: path, middleware: middleware!});
+    }
+
+    /**
+     * Set the function rendering the response when a handler of this host fails:
+     * when it throws, doesn't respond in time, or ends without sending a response.
+     * If it returns nothing, throws, or takes more than 2 seconds, then the error handler of the server is used.
+     */
+    onError(f: HttpErrorHandler) {
+        modHttp.hostOnError(this.hostResId, bindErrorHandler(f));
+    }
+
+    /**
+     * Execute the handler, then tells the server he has ended.
+     * A handler must have sent his response when his promise resolves, otherwise an error response is sent.
+     */
+    private execute(resId: SharedResource, handler: HttpRequestHandler) {
+        const done = (isException: boolean, message: string, stack: string) => {
+            try {
+                modHttp.requestHandlerDone(resId, isException, message, stack);
+            } catch (e) {
+                // The request is already disposed if the response has been sent.
+            }
+        };
+
+        this.executeChain(new HttpRequest(resId, gSecureCaller), handler).then(
+            () => done(false, "", ""),
+            (err) => done(true, String((err && err.message) || err), (err && err.stack) || "")
+        );
+    }
+
+    private async executeChain(req: HttpRequest, handler: HttpRequestHandler): Promise<void> {
+        // Middlewares are selected when the request occurs,
+        // which allows adding them after the routes.
+        //
+        let requestPath = req.requestPath();
+        let toCall = this.middlewares.filter(e => isPathInside(e.path, requestPath));
+
+        if (!toCall.length) {
+            await handler(req);
+            return;
+        }
+
+        const callAt = async (offset: number): Promise<void> => {
+            if (offset < toCall.length) {
+                let isNextCalled = false;
+
+                // Calling next twice would execute the rest of the chain twice.
+                await toCall[offset].middleware(req, () => {
+                    if (isNextCalled) throw new Error("next() called multiple times");
+                    isNextCalled = true;
+                    return callAt(offset + 1);
+                });
+            } else {
+                await handler(req);
+            }
+        };
+
+        await callAt(0);
+    }
+
+    /**
+     * Bind the handler to a verb and a path.
+     * If the path is known but not the verb, then "OPTIONS" requests are automatically
+     * answered with the list of allowed verbs, and the others verbs with a "405 Method Not Allowed".
+     *
+     * The path can contain named params, like "/users/:userId/posts/:postId", whose values
+     * are returned by requestParams(). A param can have a constraint, which is "int", "uuid"
+     * or a regular expression, like "/users/:userId(int)" or "/files/:name([a-z]+)".
+     * If a constraint doesn't match, then a 404 response is returned. A constraint can't contain a "/".
+     * Two routes with params at the same place, like "/users/:id(int)" and "/users/:name", can't be bound to the same verb.
+     */
+    verb(verb: string, requestPath: string, handler: HttpRequestHandler, options?: RouteOptions): void {
+        modHttp.VERB_withFunction(this.hostResId, verb, requestPath, options || {}, (_: string, resId: SharedResource) => {
+            this.execute(resId, handler);
+        });
+    }
+
+    GET(requestPath: string, handler: HttpRequestHandler, options?: RouteOptions): void {
+        this.verb("GET", requestPath, handler, options);
+    }
+
+    POST(requestPath: string, handler: HttpRequestHandler, options?: RouteOptions): void {
+        this.verb("POST", requestPath, handler, options);
+    }
+
+    PUT(requestPath: string, handler: HttpRequestHandler, options?: RouteOptions): void {
+        this.verb("PUT", requestPath, handler, options);
+    }
+
+    PATCH(requestPath: string, handler: HttpRequestHandler, options?: RouteOptions): void {
+        this.verb("PATCH", requestPath, handler, options);
+    }
+
+    DELETE(requestPath: string, handler: HttpRequestHandler, options?: RouteOptions): void {
+        this.verb("DELETE", requestPath, handler, options);
+    }
+
+    HEAD(requestPath: string, handler: HttpRequestHandler, options?: RouteOptions): void {
+        this.verb("HEAD", requestPath, handler, options);
+    }
+
+    OPTIONS(requestPath: string, handler: HttpRequestHandler, options?: RouteOptions): void {
+        this.verb("OPTIONS", requestPath, handler, options);
+    }
+
+    /**
+     * Bind a Server-Sent Events stream to a GET path.
+     * The stream is started before calling the handler.
+     */
+    eventStream(requestPath: string, handler: (stream: EventStream, req: HttpRequest) => void|Promise<void>, options?: EventStreamOptions): void {
+        this.GET(requestPath, async (req) => {
+            await handler(req.beginEventStream(options), req);
+        });
+    }
+
+    /**
+     * Bind the handler to all the http verbs.
+     * Use requestMethod() to know which verb is used.
+     */
+    ALL(requestPath: string, handler: HttpRequestHandler, options?: RouteOptions): void {
+        modHttp.ALL_withFunction(this.hostResId, requestPath, options || {}, (_: string, resId: SharedResource) => {
+            this.execute(resId, handler);
         });
     }
 
-    GET(requestPath: string, handler: HttpRequestHandler): void {
-        this.verb("GET", requestPath, handler);
+    /**
+     * Set the max size, in bytes, of the request bodies accepted by this host.
+     * When exceeded, a 413 response is returned. Default is no limit, like zero.
+     * It applies to all the bodies, the uploaded form files included.
+     */
+    setMaxRequestBodySize(maxSize: number) {
+        modHttp.hostSetMaxRequestBodySize(this.hostResId, maxSize);
+    }
+
+    /**
+     * Set how the responses of the handlers are compressed.
+     * By default, the responses of more than 1Kb are compressed with the encoding preferred by the client.
+     * The compressed request bodies are always uncompressed before calling the handlers.
+     */
+    setCompression(options: CompressionOptions) {
+        modHttp.hostSetCompression(this.hostResId, options);
+    }
+
+    /**
+     * Set the CORS policy of the paths starting with pathPrefix, or of the whole host if not set.
+     * When more than one policy matches a path, the longest prefix wins.
+     * The preflight requests are answered without calling the handlers.
+     */
+    setCors(options: CorsOptions, pathPrefix?: string) {
+        if (pathPrefix===undefined) pathPrefix = "";
+        modHttp.hostSetCors(this.hostResId, pathPrefix, options);
     }
 
-    POST(requestPath: string, handler: HttpRequestHandler): void {
-        this.verb("POST", requestPath, handler);
+    /**
+     * Limit the number of requests of each client for the paths starting with pathPrefix,
+     * or for the whole host if not set. When reached, a 429 response is returned with a Retry-After header.
+     * More than one rate limit can apply to a path, for example a global one and a stricter one for "/login".
+     */
+    addRateLimit(options: RateLimitOptions, pathPrefix?: string): RateLimit {
+        if (pathPrefix===undefined) pathPrefix = "";
+
+        let key = options.key;
+
+        if (!key) {
+            return new RateLimit(modHttp.hostAddRateLimit(this.hostResId, pathPrefix, options));
+        }
+
+        let resId = modHttp.hostAddRateLimitWithKey(this.hostResId, pathPrefix, options, (resId: SharedResource, json: string) => {
+            Promise.resolve().then(() => key!(JSON.parse(json))).then(
+                (res) => progpReturnString(resId, res),
+                (err) => progpReturnError(resId, String(err))
+            );
+        });
+
+        return new RateLimit(resId);
     }
 
-    proxyTo(fromPath: string, targetHost: string, options?: ProxyTypeOptions) {
+    /**
+     * Proxy the requests to a target, or balance them between a set of targets.
+     */
+    proxyTo(fromPath: string, targetHost: string|(string|ProxyUpstream)[], options?: ProxyTypeOptions): ProxyPool {
         if (!fromPath) fromPath = "/";
         if (!options) options = {};
 
-        modHttp.proxyTo(this.hostResId, fromPath, targetHost, options)
+        if (typeof(targetHost)!=="string") {
+            let upstreams = targetHost.map(e => (typeof(e)==="string") ? {target: e} : e);
+            options = {...options, upstreams: upstreams.concat(options.upstreams || [])};
+            targetHost = "";
+        }
+
+        let onRequest = options.onRequest;
+
+        if (!onRequest) {
+            return new ProxyPool(modHttp.proxyTo(this.hostResId, fromPath, targetHost, options));
+        }
+
+        let resId = modHttp.proxyToWithHook(this.hostResId, fromPath, targetHost, options, (resId: SharedResource, json: string) => {
+            Promise.resolve().then(() => onRequest!(JSON.parse(json))).then(
+                (res) => {
+                    // A refusal is returned as json, while an error means the hook has failed.
+                    if (res===false) progpReturnString(resId, "{}");
+                    else if (typeof(res)==="string") progpReturnString(resId, JSON.stringify({body: res}));
+                    else if (res && (typeof(res)==="object")) progpReturnString(resId, JSON.stringify(res));
+                    else progpReturnVoid(resId);
+                },
+                (err) => progpReturnError(resId, String(err))
+            );
+        });
+
+        return new ProxyPool(resId);
+    }
+
+    /**
+     * Accept the WebSocket connections on this path.
+     * The path can contain named params, like the routes.
+     */
+    websocket(requestPath: string, handlers: WebSocketHandlers, options?: WebSocketOptions) {
+        const connections = new Map<SharedResource, WebSocketConnection>();
+
+        const getConnection = (resId: SharedResource) => {
+            let ws = connections.get(resId);
+
+            if (!ws) {
+                ws = new WebSocketConnection(resId);
+                connections.set(resId, ws);
+            }
+
+            return ws;
+        };
+
+        modHttp.websocket_withFunction(this.hostResId, requestPath, options || {},
+            (resId: SharedResource, json: string) => {
+                if (handlers.onOpen) handlers.onOpen(getConnection(resId), JSON.parse(json));
+            },
+            (resId: SharedResource, message: string) => {
+                if (handlers.onMessage) handlers.onMessage(getConnection(resId), message);
+            },
+            (resId: SharedResource, message: ArrayBuffer) => {
+                if (handlers.onMessage) handlers.onMessage(getConnection(resId), message);
+            },
+            (resId: SharedResource, json: string) => {
+                let ws = getConnection(resId);
+                connections.delete(resId);
+
+                ws._markClosed();
+                if (handlers.onClose) handlers.onClose(ws, JSON.parse(json));
+            }
+        );
     }
 
     serveFiles(fromPath: string, dirPath: string, options?: ServeFileOptions) {
@@ -487,6 +1767,14 @@ export interface FsCacheEntry {
     gzipContentLength: number
 }
 
+export interface FsDataRequest {
+    uri: string
+    uriPath: string
+    queryString: string
+    hostname: string
+    headers: {[key: string]: string}
+}
+
 export interface FsOnFileNodeFound {
     filePath: string
     uri: string
@@ -513,6 +1801,38 @@ export class FileServer {
         })
     }
 
+    /**
+     * Returns the state of the cache.
+     */
+    getStats(): FileServerStats {
+        return JSON.parse(modHttp.fileServer_Stats(this.resId))
+    }
+
+    /**
+     * Set the function called when a file changes on the disk.
+     * Requires the option "watch". The cache entries of the file are already evicted when it's called.
+     */
+    onChange(f: ((change: FileServerChange) => void)) {
+        modHttp.fileServer_OnChange(this.resId, (_: string, raw: string) => {
+            f(JSON.parse(raw))
+        })
+    }
+
+    /**
+     * Set the function returning the data of a request, for example his language.
+     * The cache keeps an entry for each uri and data, the data is given to onFileNotFound
+     * and allows removeUri to remove only one version of a file.
+     * It's called for each request, before looking at the cache.
+     */
+    setDataFunction(f: ((req: FsDataRequest) => string|Promise<string>)) {
+        modHttp.fileServer_SetDataFunction(this.resId, (resId: SharedResource, json: string) => {
+            Promise.resolve().then(() => f(JSON.parse(json))).then(
+                (res) => progpReturnString(resId, res),
+                (err) => progpReturnError(resId, String(err))
+            );
+        })
+    }
+
     onFileNotFound(f: ((v: FsOnFileNodeFound, oneDone: Function) => void)) {
         modHttp.fileServer_OnFileNotFound(this.resId, (resId: SharedResource, json: string) => {
             f(<FsOnFileNodeFound>JSON.parse(json), () => progpReturnVoid(resId))
@@ -522,7 +1842,7 @@ export class FileServer {
     /**
      * Remove the exact uri.
      * @param uri   The uri to remove.
-     * @param data  Is used by hooks to finely select content to remove.
+     * @param data  Is used by hooks to finely select content to remove, see setDataFunction.
      */
     removeUri(uri: string, data?: string) {
         if (data===undefined) data = "";
@@ -532,6 +1852,13 @@ export class FileServer {
 
 export type HttpRequestHandler = (res: HttpRequest) => Promise<void>;
 
+/**
+ * A middleware is executed before the route handler.
+ * It must call "next" to execute the next middleware, or send a response to stop here.
+ * Calling "next" more than once throws an error.
+ */
+export type HttpMiddleware = (req: HttpRequest, next: () => Promise<void>) => Promise<void>|void;
+
 export function asHttpRequest(f: (req:HttpRequest)=>void) {
     return (resId:SharedResource)=> f(new HttpRequest(resId, gSecureCaller))
 }
@@ -581,20 +1908,259 @@ export async function brotliCompressFile(sourceFilePath: string, targetFilePath:
 export interface FetchResult {
     statusCode: number,
     body?: string
+
+    /**
+     * Is set instead of body when responseType is "arrayBuffer".
+     */
+    bodyBuffer?: ArrayBuffer
+
     headers?: {[key:string]: string}
     cookies?: {[key:string]: any}
 }
 
 export async function fetch(url: string, options?: FetchOptions): Promise<FetchResult> {
-    if (!options) options = {};
-    if (!options.method) options.method = "GET";
+    let [fetchOptions, bytes] = prepareFetchOptions(options);
+
+    if (fetchOptions.responseType && (fetchOptions.responseType!=="text")) {
+        return fetchBody(url, fetchOptions, bytes);
+    }
+
+    if (bytes) {
+        return new Promise<FetchResult>(function (resolve, reject) {
+            modHttp.fetchWithBytes(url, fetchOptions, bytes!, (err: string, res: string) => {
+                if (err) reject(err);
+                else resolve(JSON.parse(res));
+            })
+        })
+    }
 
     return new Promise<FetchResult>(function (resolve, reject) {
-        modHttp.fetch(url, options!, (err: string, res: string) => {
+        modHttp.fetch(url, fetchOptions, (err: string, res: string) => {
             if (err) reject(err);
             else resolve(JSON.parse(res));
         })
     })
 }
 
+/**
+ * Set the default method and extract the binary body, which is sent apart from the options.
+ */
+function prepareFetchOptions(options?: FetchOptions): [FetchOptions, ArrayBuffer|undefined] {
+    if (!options) options = {};
+    if (!options.method) options.method = "GET";
+
+    let body = options.body;
+    let bytes: ArrayBuffer|undefined;
+
+    if ((body!==undefined) && (typeof(body)!=="string")) {
+        if (body instanceof ArrayBuffer) {
+            bytes = body;
+            options = {...options, body: undefined};
+        } else {
+            options = {...options, body: JSON.stringify(body)};
+            if (!options.contentType) options.contentType = "application/json";
+        }
+    }
+
+    return [options, bytes];
+}
+
+/**
+ * Implements the "arrayBuffer" and "stream" modes of fetch.
+ * The body stays on the Go side until read, which avoids converting it to json.
+ * If clientResId is set, then the request is sent with this client.
+ */
+async function fetchBody(url: string, options: FetchOptions, bytes?: ArrayBuffer, clientResId?: SharedResource): Promise<FetchResult> {
+    let resId = await new Promise<SharedResource>(function (resolve, reject) {
+        let callback = (err: string, res: SharedResource) => {
+            if (err) reject(err);
+            else resolve(res);
+        };
+
+        if (clientResId===undefined) modHttp.fetchResponse(url, options, bytes || new ArrayBuffer(0), callback);
+        else modHttp.httpClient_FetchResponse(clientResId, url, options, bytes || new ArrayBuffer(0), callback);
+    });
+
+    try {
+        let info = JSON.parse(modHttp.fetchResponse_Info(resId));
+        let result: FetchResult = {statusCode: info.status};
+
+        if (options.returnHeaders) {
+            result.headers = {};
+            for (let [key, value] of info.headers) result.headers[key] = value;
+        }
+
+        if (options.returnCookies) {
+            result.cookies = JSON.parse(modHttp.fetchResponse_Cookies(resId));
+        }
+
+        if (options.skipBody || ((result.statusCode!==200) && !options.forceReturningBody)) {
+            return result;
+        }
+
+        if (options.responseType==="arrayBuffer") {
+            result.bodyBuffer = await new Promise<ArrayBuffer>(function (resolve, reject) {
+                modHttp.fetchResponse_ReadAll(resId, (err: string, buffer: ArrayBuffer) => {
+                    if (err) reject(err);
+                    else resolve(buffer);
+                })
+            });
+        } else {
+            while (true) {
+                let chunk = await new Promise<ArrayBuffer|undefined>(function (resolve, reject) {
+                    modHttp.fetchResponse_ReadChunk(resId, (err: string, buffer: ArrayBuffer|undefined) => {
+                        if (err) reject(err);
+                        else resolve(buffer);
+                    })
+                });
+
+                if (!chunk) break;
+                if (options.onChunk) await options.onChunk(chunk);
+            }
+        }
+
+        return result;
+    } finally {
+        progpDispose(resId);
+    }
+}
+
+export interface HttpClientOptions {
+    /**
+     * Is prepended to the urls which aren't absolute.
+     * For example with "https://my.api/v1", the url "/users" targets "https://my.api/v1/users".
+     */
+    baseUrl?: string
+
+    /**
+     * Headers sent with each request, unless the request sets them.
+     */
+    headers?: {[key:string]:string}
+
+    userAgent?: string
+
+    /**
+     * By default the cookies set by the servers are kept and sent back with the next requests.
+     * This option disables it.
+     */
+    disableCookieJar?: boolean
+
+    /**
+     * The max number of connections opened to the same host. Default is no limit.
+     */
+    maxConnsPerHost?: number
+
+    /**
+     * The time, in milliseconds, a request waits for a free connection
+     * once maxConnsPerHost is reached. Default is 30 seconds.
+     */
+    maxConnWaitTimeout?: number
+
+    /**
+     * If true, the connection is closed after each request.
+     */
+    disableKeepAlive?: boolean
+
+    /**
+     * The time, in milliseconds, an unused connection is kept open. Default is 10 seconds.
+     */
+    maxIdleConnDuration?: number
+
+    /**
+     * The time, in milliseconds, after which a connection is closed, even if used. Default is no limit.
+     */
+    maxConnDuration?: number
+
+    /**
+     * The default timeout, in milliseconds, of the requests.
+     */
+    timeout?: number
+
+    retry?: HttpClientRetryOptions
+
+    /**
+     * The TLS options used for all the requests of this client.
+     * The tls option of the requests is ignored.
+     */
+    tls?: FetchTlsOptions
+}
+
+/**
+ * Only the idempotent methods (GET, HEAD, OPTIONS, TRACE, PUT, DELETE) are retried,
+ * after a network error or when the response status is in retryOnStatus.
+ */
+export interface HttpClientRetryOptions {
+    /**
+     * The max number of times a request is sent again. Default is 0, which disables the retries.
+     */
+    maxRetries?: number
+
+    /**
+     * The time, in milliseconds, before the first retry. Default is 100.
+     */
+    initialDelay?: number
+
+    /**
+     * The max time, in milliseconds, between two retries. Default is 10 seconds.
+     */
+    maxDelay?: number
+
+    /**
+     * The factor applied to the delay after each retry. Default is 2.
+     */
+    multiplier?: number
+
+    /**
+     * Default is [502, 503, 504].
+     */
+    retryOnStatus?: number[]
+}
+
+/**
+ * A client whose connections, default values and cookies are shared by all his requests.
+ */
+export class HttpClient {
+    private readonly resId: SharedResource
+
+    constructor(resId: SharedResource) {
+        this.resId = resId
+    }
+
+    /**
+     * Like the fetch function, but using the defaults of this client.
+     */
+    async fetch(url: string, options?: FetchOptions): Promise<FetchResult> {
+        let [fetchOptions, bytes] = prepareFetchOptions(options);
+
+        if (fetchOptions.responseType && (fetchOptions.responseType!=="text")) {
+            return fetchBody(url, fetchOptions, bytes, this.resId);
+        }
+
+        return new Promise<FetchResult>((resolve, reject) => {
+            modHttp.httpClient_Fetch(this.resId, url, fetchOptions, bytes || new ArrayBuffer(0), (err: string, res: string) => {
+                if (err) reject(err);
+                else resolve(JSON.parse(res));
+            })
+        })
+    }
+
+    /**
+     * Returns the cookies of the jar which are sent to this url.
+     */
+    getCookies(url?: string): {[key:string]:string} {
+        return JSON.parse(modHttp.httpClient_Cookies(this.resId, url || ""))
+    }
+
+    /**
+     * Close the connections.
+     */
+    dispose() {
+        progpDispose(this.resId)
+    }
+}
+
+export function createHttpClient(options?: HttpClientOptions): HttpClient {
+    return new HttpClient(modHttp.httpClient_Create(options || {}));
+}
+
 let gSecureCaller = {v: {}};
@@ -34,4 +34,5 @@ func registerEmbeddedModule(innerPath string, alias ...string) {
 
 func registerEmbeddedModules() {
 	registerEmbeddedModule("jsMods/@progp/http/index.ts", "@progp/http")
+	registerEmbeddedModule("jsMods/@progp/http/http_fetch.ts", "@progp/http_fetch")
 }
@@ -0,0 +1,309 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/progpjs/httpServer/v2"
+	"github.com/progpjs/progpAPI/v2"
+	"net/http"
+	"time"
+)
+
+// The kinds of error a handler can end with.
+const (
+	// HandlerErrorException is when the javascript handler has thrown an exception.
+	HandlerErrorException = "exception"
+
+	// HandlerErrorTimeout is when the javascript handler hasn't responded in time.
+	HandlerErrorTimeout = "timeout"
+
+	// HandlerErrorNoResponse is when the javascript handler has ended without sending a response.
+	HandlerErrorNoResponse = "noResponse"
+
+	// HandlerErrorInternal is when a handler implemented in Go has failed, like the file server.
+	HandlerErrorInternal = "error"
+)
+
+// ErrorHookTimeout is the time an error hook has to return the response.
+// Once elapsed, the next hook is used, or the default error response.
+// The error response is late already, which is why it's shorter than DefaultJsCallTimeout.
+const ErrorHookTimeout = 2 * time.Second
+
+// jsHandlerError is returned by a javascript handler which hasn't sent a response.
+type jsHandlerError struct {
+	kind       string
+	message    string
+	stack      string
+	route      string
+	statusCode int
+}
+
+func (m *jsHandlerError) Error() string {
+	return m.message
+}
+
+// jsHandlerErrorEvent is sent to the error hooks.
+type jsHandlerErrorEvent struct {
+	// Kind is one of "exception", "timeout", "noResponse" or "error".
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	Stack   string `json:"stack,omitempty"`
+
+	// StatusCode is the status code of the default response.
+	StatusCode int `json:"statusCode"`
+
+	Hostname string `json:"hostname"`
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Ip       string `json:"ip"`
+
+	// Route is the path the handler is bound to.
+	Route string `json:"route"`
+}
+
+// jsErrorResponse is the response rendered by an error hook, or the default one.
+type jsErrorResponse struct {
+	StatusCode  int               `json:"statusCode"`
+	ContentType string            `json:"contentType"`
+	Body        string            `json:"body"`
+	Headers     map[string]string `json:"headers"`
+}
+
+// jsErrorHook renders the response of an error. Returns nil to let the next hook do it.
+type jsErrorHook func(event *jsHandlerErrorEvent) *jsErrorResponse
+
+// getHandlerError returns why the handler hasn't sent a response.
+func (m *jsHttpRequest) getHandlerError(isTimedOut bool, timeoutStatus int, route string) *jsHandlerError {
+	if isTimedOut {
+		return &jsHandlerError{kind: HandlerErrorTimeout, message: ResponseTimedOutError.Error(), route: route, statusCode: timeoutStatus}
+	}
+
+	if m.handlerError != nil {
+		m.handlerError.route = route
+		return m.handlerError
+	}
+
+	return &jsHandlerError{kind: HandlerErrorNoResponse, message: NoResponseSendError.Error(), route: route, statusCode: 500}
+}
+
+// takeResponse forbids the handler to send a response, unless it has been sent meanwhile.
+// Once done, the calls of the handler writing the response return responseError.
+func (m *jsHttpRequest) takeResponse(responseError error) bool {
+	m.responseMutex.Lock()
+	defer m.responseMutex.Unlock()
+
+	if m.IsBodySend() {
+		return false
+	}
+
+	m.responseError = responseError
+	return true
+}
+
+// setHandlerDone is called once the javascript handler has ended.
+func (m *jsHttpRequest) setHandlerDone(handlerError *jsHandlerError) {
+	m.handlerDoneOnce.Do(func() {
+		m.handlerError = handlerError
+		close(m.handlerDone)
+	})
+}
+
+// withErrorHandling sends an error response when the handler fails,
+// which is rendered by the error hook of the host, else by the one of the server, else by the default one.
+func withErrorHandling(settings *jsHostSettings, route string, handler httpServer.HttpMiddleware) httpServer.HttpMiddleware {
+	return func(call httpServer.HttpRequest) error {
+		err := handler(call)
+		if err == nil {
+			return nil
+		}
+
+		event := newHandlerErrorEvent(call, route, err)
+
+		response := settings.getErrorHook()(event)
+		if response == nil {
+			response = settings.server.getErrorHook()(event)
+		}
+
+		if response == nil {
+			response = settings.server.getDefaultErrorResponse(event)
+		}
+
+		sendErrorResponse(call, event, response)
+		return nil
+	}
+}
+
+func newHandlerErrorEvent(call httpServer.HttpRequest, route string, err error) *jsHandlerErrorEvent {
+	event := &jsHandlerErrorEvent{
+		Kind:       HandlerErrorInternal,
+		Message:    err.Error(),
+		StatusCode: 500,
+		Hostname:   call.GetHost().GetHostName(),
+		Method:     call.GetMethodName(),
+		Path:       call.Path(),
+		Ip:         call.RemoteIP(),
+		Route:      route,
+	}
+
+	if handlerError, ok := err.(*jsHandlerError); ok {
+		event.Kind = handlerError.kind
+		event.Stack = handlerError.stack
+		event.StatusCode = handlerError.statusCode
+
+		if handlerError.route != "" {
+			event.Route = handlerError.route
+		}
+	}
+
+	return event
+}
+
+func sendErrorResponse(call httpServer.HttpRequest, event *jsHandlerErrorEvent, response *jsErrorResponse) {
+	for key, value := range response.Headers {
+		call.SetHeader(key, value)
+	}
+
+	if response.StatusCode == 0 {
+		response.StatusCode = event.StatusCode
+	}
+
+	if response.ContentType == "" {
+		response.ContentType = "text/plain; charset=utf-8"
+	}
+
+	call.SetContentType(response.ContentType)
+	call.ReturnString(response.StatusCode, response.Body)
+}
+
+// getDefaultErrorResponse returns the response sent when no error hook renders it.
+// The details of the error are only shown if the server doesn't hide them.
+func (m *jsServerSettings) getDefaultErrorResponse(event *jsHandlerErrorEvent) *jsErrorResponse {
+	m.mutex.Lock()
+	hideErrors := m.hideErrors
+	m.mutex.Unlock()
+
+	body := http.StatusText(event.StatusCode)
+
+	if !hideErrors {
+		body += "\n\n" + event.Message
+
+		if event.Stack != "" {
+			body += "\n\n" + event.Stack
+		}
+	}
+
+	return &jsErrorResponse{StatusCode: event.StatusCode, Body: body}
+}
+
+func (m *jsServerSettings) getErrorHook() jsErrorHook {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.onError == nil {
+		return noErrorHook
+	}
+
+	return m.onError
+}
+
+func (m *jsHostSettings) getErrorHook() jsErrorHook {
+	m.errorHookMutex.RLock()
+	defer m.errorHookMutex.RUnlock()
+
+	if m.onError == nil {
+		return noErrorHook
+	}
+
+	return m.onError
+}
+
+func noErrorHook(_ *jsHandlerErrorEvent) *jsErrorResponse {
+	return nil
+}
+
+// buildErrorHook returns an error hook calling a javascript function,
+// which returns the response to send, or nothing to let the next hook render it.
+// The next hook is also used if the function fails or doesn't return before ErrorHookTimeout.
+func buildErrorHook(rc *progpAPI.SharedResourceContainer, callback progpAPI.JsFunction) jsErrorHook {
+	callback.KeepAlive()
+
+	return func(event *jsHandlerErrorEvent) *jsErrorResponse {
+		b, err := json.Marshal(event)
+		if err != nil {
+			return nil
+		}
+
+		// If the hook fails, then the next one is used.
+		res, err := callJsFunctionAndWait(rc, callback, b, ErrorHookTimeout)
+		if err != nil || res == "" {
+			return nil
+		}
+
+		response := &jsErrorResponse{}
+		if err = json.Unmarshal([]byte(res), response); err != nil {
+			return nil
+		}
+
+		return response
+	}
+}
+
+// JsHostOnError set the function rendering the response when a handler of this host fails.
+func JsHostOnError(rc *progpAPI.SharedResourceContainer, resHost *progpAPI.SharedResource, callback progpAPI.JsFunction) error {
+	host, ok := resHost.Value.(*httpServer.HttpHost)
+	if !ok {
+		return errors.New("invalid resource")
+	}
+
+	settings := getHostSettings(host)
+
+	settings.errorHookMutex.Lock()
+	defer settings.errorHookMutex.Unlock()
+
+	settings.onError = buildErrorHook(rc, callback)
+	return nil
+}
+
+// JsServerOnError set the function rendering the response when a handler of this server fails,
+// and the error hook of his host doesn't render it.
+func JsServerOnError(rc *progpAPI.SharedResourceContainer, serverPort int, callback progpAPI.JsFunction) {
+	settings := getServerSettings(serverPort)
+
+	settings.mutex.Lock()
+	defer settings.mutex.Unlock()
+
+	settings.onError = buildErrorHook(rc, callback)
+}
+
+// JsRequestHandlerDone is called once the javascript handler has ended.
+// If it has thrown, then message and stack describe the exception.
+func JsRequestHandlerDone(resHttpRequest *progpAPI.SharedResource, isException bool, message string, stack string) error {
+	req, err := getJsHttpRequest(resHttpRequest)
+	if err != nil {
+		return err
+	}
+
+	if !isException {
+		req.setHandlerDone(nil)
+		return nil
+	}
+
+	req.setHandlerDone(&jsHandlerError{kind: HandlerErrorException, message: message, stack: stack, statusCode: 500})
+	return nil
+}
@@ -0,0 +1,668 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/progpjs/progpAPI/v2"
+	"github.com/valyala/fasthttp"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	FetchRedirectFollow = "follow"
+	FetchRedirectManual = "manual"
+	FetchRedirectError  = "error"
+)
+
+// DefaultFetchMaxRedirects is the max number of redirects followed by fetch.
+const DefaultFetchMaxRedirects = 10
+
+var TooManyRedirectsError = errors.New("too many redirects")
+var UnexpectedRedirectError = errors.New("unexpected redirect")
+var FetchAbortedError = errors.New("fetch aborted")
+
+// fetchClient sends the requests of a fetch.
+// The default one only sends them, while the clients created by javascript
+// also keep the cookies and retry the failed requests.
+type fetchClient struct {
+	client *fasthttp.Client
+
+	// jar, if not nil, stores the cookies set by the servers and sends them back.
+	jar   http.CookieJar
+	retry JsHttpClientRetry
+}
+
+// maxFetchClients is the number of clients kept in cache.
+// Each distinct TLS configuration has its own client, with its own connections.
+const maxFetchClients = 16
+
+type fetchClientEntry struct {
+	key    string
+	client *fetchClient
+}
+
+// fetchClientCache contains a client for each TLS configuration, the least recently used being evicted.
+// Reusing them allows reusing the connections.
+type fetchClientCache struct {
+	maxSize int
+	entries map[string]*list.Element
+	lru     *list.List
+	mutex   sync.Mutex
+}
+
+func newFetchClientCache(maxSize int) *fetchClientCache {
+	return &fetchClientCache{maxSize: maxSize, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+var gFetchClients = newFetchClientCache(maxFetchClients)
+
+// getFetchClientKey returns a key identifying the TLS configuration.
+// The modification date of the CA file is part of the key, which allows reloading it once changed.
+func getFetchClientKey(tlsOptions JsFetchTlsOptions) (string, error) {
+	var sb strings.Builder
+
+	sb.WriteString(strconv.FormatBool(tlsOptions.InsecureSkipVerify))
+
+	if tlsOptions.CaCert != "" {
+		sum := sha256.Sum256([]byte(tlsOptions.CaCert))
+		sb.WriteString("|" + hex.EncodeToString(sum[:]))
+	}
+
+	if tlsOptions.CaFile != "" {
+		caFile, err := filepath.Abs(tlsOptions.CaFile)
+		if err != nil {
+			return "", err
+		}
+
+		stat, err := os.Stat(caFile)
+		if err != nil {
+			return "", err
+		}
+
+		sb.WriteString("|" + caFile)
+		sb.WriteString("|" + strconv.FormatInt(stat.ModTime().UnixNano(), 10))
+		sb.WriteString("|" + strconv.FormatInt(stat.Size(), 10))
+	}
+
+	return sb.String(), nil
+}
+
+func getFetchClient(tlsOptions JsFetchTlsOptions) (*fetchClient, error) {
+	return gFetchClients.get(tlsOptions)
+}
+
+// get returns the client for this TLS configuration, creating it if needed.
+func (m *fetchClientCache) get(tlsOptions JsFetchTlsOptions) (*fetchClient, error) {
+	key, err := getFetchClientKey(tlsOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if element := m.entries[key]; element != nil {
+		m.lru.MoveToFront(element)
+		return element.Value.(*fetchClientEntry).client, nil
+	}
+
+	tlsConfig, err := buildFetchTlsConfig(tlsOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &fetchClient{client: &fasthttp.Client{
+		TLSConfig:              tlsConfig,
+		ReadBufferSize:         16 * 1024,
+		DisablePathNormalizing: true,
+	}}
+
+	m.add(key, client)
+	return client, nil
+}
+
+// add adds a client to the cache and evicts the least recently used one if the cache is full.
+// The mutex must be locked.
+func (m *fetchClientCache) add(key string, client *fetchClient) {
+	m.entries[key] = m.lru.PushFront(&fetchClientEntry{key: key, client: client})
+
+	if m.lru.Len() > m.maxSize {
+		oldest := m.lru.Back()
+		m.lru.Remove(oldest)
+
+		entry := oldest.Value.(*fetchClientEntry)
+		delete(m.entries, entry.key)
+
+		// The requests in progress can still use this client.
+		entry.client.client.CloseIdleConnections()
+	}
+}
+
+func buildFetchTlsConfig(options JsFetchTlsOptions) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: options.InsecureSkipVerify}
+
+	if (options.CaFile == "") && (options.CaCert == "") {
+		return config, nil
+	}
+
+	// The custom authorities are added to the system ones.
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	pemCerts := []byte(options.CaCert)
+
+	if options.CaFile != "" {
+		b, err := os.ReadFile(options.CaFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pemCerts = append(pemCerts, '\n')
+		pemCerts = append(pemCerts, b...)
+	}
+
+	if !pool.AppendCertsFromPEM(pemCerts) {
+		return nil, errors.New("no valid certificate found for the certificate authority")
+	}
+
+	config.RootCAs = pool
+	return config, nil
+}
+
+// buildFetchRequest set the url, the headers and the body of the request.
+// If body isn't nil, then it's used instead of the body set in the options.
+func buildFetchRequest(req *fasthttp.Request, fetchUrl string, options JsFetchOptions, body []byte) error {
+	req.SetRequestURI(fetchUrl)
+	req.Header.SetMethod(options.Method)
+
+	for key, value := range options.SendHeaders {
+		req.Header.Set(key, value)
+	}
+
+	for key, value := range options.SendCookies {
+		req.Header.SetCookie(key, value)
+	}
+
+	if options.UserAgent != "" {
+		req.Header.SetUserAgent(options.UserAgent)
+	}
+
+	contentType := options.ContentType
+
+	switch {
+	case body != nil:
+
+	case options.Body != "":
+		body = []byte(options.Body)
+
+	case options.Form != nil:
+		values := url.Values{}
+
+		for key, value := range options.Form {
+			values.Set(key, value)
+		}
+
+		body = []byte(values.Encode())
+
+		if contentType == "" {
+			contentType = "application/x-www-form-urlencoded"
+		}
+
+	case options.Multipart != nil:
+		var err error
+		var multipartContentType string
+
+		body, multipartContentType, err = buildMultipartBody(options.Multipart)
+		if err != nil {
+			return err
+		}
+
+		// The content type contains the boundary, it can't be replaced.
+		contentType = multipartContentType
+
+	default:
+		return nil
+	}
+
+	if contentType != "" {
+		req.Header.SetContentType(contentType)
+	}
+
+	req.SetBody(body)
+	return nil
+}
+
+var gMultipartQuoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// buildMultipartBody returns a multipart/form-data body and his content type.
+func buildMultipartBody(m *JsFetchMultipart) ([]byte, string, error) {
+	var buffer bytes.Buffer
+	writer := multipart.NewWriter(&buffer)
+
+	for key, value := range m.Fields {
+		if err := writer.WriteField(key, value); err != nil {
+			return nil, "", err
+		}
+	}
+
+	for _, f := range m.Files {
+		fileName := f.FileName
+		if fileName == "" {
+			fileName = filepath.Base(f.FilePath)
+		}
+
+		contentType := f.ContentType
+
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(fileName))
+
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+		}
+
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", `form-data; name="`+gMultipartQuoteEscaper.Replace(f.FieldName)+`"; filename="`+gMultipartQuoteEscaper.Replace(fileName)+`"`)
+		header.Set("Content-Type", contentType)
+
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+
+		file, err := os.Open(f.FilePath)
+		if err != nil {
+			return nil, "", err
+		}
+
+		_, err = io.Copy(part, file)
+		_ = file.Close()
+
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return buffer.Bytes(), writer.FormDataContentType(), nil
+}
+
+func isRedirectStatus(statusCode int) bool {
+	switch statusCode {
+	case 301, 302, 303, 307, 308:
+		return true
+	}
+
+	return false
+}
+
+// fetchResponse is the final response of a fetch, once the redirects are followed.
+type fetchResponse struct {
+	resp       *fasthttp.Response
+	url        string
+	redirected bool
+}
+
+// doFetch sends the request, following the redirects if asked.
+// If streamBody is true, then the body is read while consumed instead of before returning.
+// Closing abort, which can be nil, stops the fetch with FetchAbortedError.
+// The fasthttp response it returns must be released with fasthttp.ReleaseResponse.
+func doFetch(client *fetchClient, fetchUrl string, options JsFetchOptions, body []byte, streamBody bool, abort <-chan struct{}) (*fetchResponse, error) {
+	switch options.Redirect {
+	case "":
+		options.Redirect = FetchRedirectFollow
+	case FetchRedirectFollow, FetchRedirectManual, FetchRedirectError:
+	default:
+		return nil, errors.New("unknown redirect policy " + options.Redirect)
+	}
+
+	if options.MaxRedirects <= 0 {
+		options.MaxRedirects = DefaultFetchMaxRedirects
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	if err := buildFetchRequest(req, fetchUrl, options, body); err != nil {
+		return nil, err
+	}
+
+	// The timeout is for the whole request, redirects included.
+	var deadline time.Time
+
+	if options.Timeout > 0 {
+		deadline = time.Now().Add(time.Duration(options.Timeout) * time.Millisecond)
+	}
+
+	for redirectCount := 0; ; redirectCount++ {
+		resp, err := client.send(req, deadline, options.SkipBody, streamBody, abort)
+		if err != nil {
+			return nil, err
+		}
+
+		statusCode := resp.StatusCode()
+		location := string(resp.Header.Peek("Location"))
+
+		if !isRedirectStatus(statusCode) || (location == "") || (options.Redirect == FetchRedirectManual) {
+			return &fetchResponse{resp: resp, url: req.URI().String(), redirected: redirectCount != 0}, nil
+		}
+
+		fasthttp.ReleaseResponse(resp)
+
+		if options.Redirect == FetchRedirectError {
+			return nil, UnexpectedRedirectError
+		}
+
+		if redirectCount >= options.MaxRedirects {
+			return nil, TooManyRedirectsError
+		}
+
+		previousHost := string(req.URI().Host())
+
+		// Resolve the location, which can be relative to the current url.
+		req.URI().Update(location)
+
+		// Avoid sending the credentials to another host.
+		if string(req.URI().Host()) != previousHost {
+			req.Header.Del("Authorization")
+			req.Header.DelAllCookies()
+		}
+
+		method := string(req.Header.Method())
+
+		if (statusCode == 303) || (((statusCode == 301) || (statusCode == 302)) && (method == "POST")) {
+			if method != "HEAD" {
+				req.Header.SetMethod("GET")
+			}
+
+			req.ResetBody()
+			req.Header.Del("Content-Type")
+			req.Header.Del("Content-Length")
+		}
+	}
+}
+
+// send sends the request once, without following the redirects.
+// The request is sent again if the retry policy of the client allows it.
+func (m *fetchClient) send(req *fasthttp.Request, deadline time.Time, skipBody bool, streamBody bool, abort <-chan struct{}) (*fasthttp.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if m.jar != nil {
+			addJarCookies(m.jar, req)
+		}
+
+		resp, err := m.do(req, deadline, skipBody, streamBody, abort)
+		if err == FetchAbortedError {
+			return nil, err
+		}
+
+		if (err == nil) && (m.jar != nil) {
+			storeJarCookies(m.jar, req, resp)
+		}
+
+		delay, mustRetry := m.retry.getRetryDelay(req, resp, err, attempt)
+
+		if mustRetry && !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+			mustRetry = false
+		}
+
+		if !mustRetry {
+			if err != nil {
+				fasthttp.ReleaseResponse(resp)
+				return nil, err
+			}
+
+			return resp, nil
+		}
+
+		fasthttp.ReleaseResponse(resp)
+
+		select {
+		case <-abort:
+			return nil, FetchAbortedError
+		case <-time.After(delay):
+		}
+	}
+}
+
+// do executes the request and returns the response, which must be released even on error.
+// If abort is closed before the end, then FetchAbortedError is returned at once and the request
+// is left to end in the background, where his response is released. Releasing a response
+// whose body is streamed closes his connection.
+func (m *fetchClient) do(req *fasthttp.Request, deadline time.Time, skipBody bool, streamBody bool, abort <-chan struct{}) (*fasthttp.Response, error) {
+	execute := func(req *fasthttp.Request, resp *fasthttp.Response) error {
+		if deadline.IsZero() {
+			return m.client.Do(req, resp)
+		}
+
+		return m.client.DoDeadline(req, resp, deadline)
+	}
+
+	resp := fasthttp.AcquireResponse()
+	resp.SkipBody = skipBody
+	resp.StreamBody = streamBody
+
+	if abort == nil {
+		return resp, execute(req, resp)
+	}
+
+	select {
+	case <-abort:
+		fasthttp.ReleaseResponse(resp)
+		return nil, FetchAbortedError
+	default:
+	}
+
+	// The caller releases his request once aborted, while the background one still uses it.
+	reqCopy := fasthttp.AcquireRequest()
+	req.CopyTo(reqCopy)
+
+	result := make(chan error, 1)
+	var isAborted atomic.Bool
+
+	progpAPI.SafeGoRoutine(func() {
+		err := execute(reqCopy, resp)
+		fasthttp.ReleaseRequest(reqCopy)
+
+		// The swap makes sure only one side owns the response.
+		if isAborted.Swap(true) {
+			fasthttp.ReleaseResponse(resp)
+			return
+		}
+
+		result <- err
+	})
+
+	select {
+	case err := <-result:
+		return resp, err
+	case <-abort:
+		if isAborted.Swap(true) {
+			// The request has ended at the same time.
+			return resp, <-result
+		}
+
+		return nil, FetchAbortedError
+	}
+}
+
+func getFetchResponseHeaders(resp *fasthttp.Response) map[string]string {
+	headers := make(map[string]string)
+
+	resp.Header.VisitAll(func(key, value []byte) {
+		headers[string(key)] = string(value)
+	})
+
+	return headers
+}
+
+// getFetchResponseCookies returns the cookies set by the response,
+// using the same format as the cookies of the requests.
+func getFetchResponseCookies(resp *fasthttp.Response) (map[string]map[string]any, error) {
+	cookies := make(map[string]map[string]any)
+	var err error
+
+	resp.Header.VisitAllCookie(func(key, value []byte) {
+		if err != nil {
+			return
+		}
+
+		c := fasthttp.AcquireCookie()
+		defer fasthttp.ReleaseCookie(c)
+
+		if err = c.ParseBytes(value); err != nil {
+			return
+		}
+
+		var expireTime int64
+
+		if !c.Expire().Equal(fasthttp.CookieExpireUnlimited) {
+			expireTime = c.Expire().Unix()
+		}
+
+		cookies[string(c.Key())] = map[string]any{
+			"key":          string(c.Key()),
+			"value":        string(c.Value()),
+			"domain":       string(c.Domain()),
+			"maxAge":       c.MaxAge(),
+			"expireTime":   expireTime,
+			"sameSiteType": int(c.SameSite()),
+			"isSecure":     c.Secure(),
+			"isHTTPOnly":   c.HTTPOnly(),
+		}
+	})
+
+	return cookies, err
+}
+
+func buildFetchResult(resp *fasthttp.Response, options JsFetchOptions) (JsFetchResult, error) {
+	jsResult := JsFetchResult{}
+	jsResult.StatusCode = resp.StatusCode()
+
+	if !options.SkipBody && ((jsResult.StatusCode == 200) || options.ForceReturningBody) {
+		if options.StreamBodyToFile == "" {
+			body, err := resp.BodyUncompressed()
+			if err != nil {
+				return jsResult, err
+			}
+
+			jsResult.Body = string(body)
+		} else {
+			file, err := os.Create(options.StreamBodyToFile)
+			if err != nil {
+				return jsResult, err
+			}
+
+			err = resp.BodyWriteTo(file)
+			_ = file.Close()
+
+			if err != nil {
+				return jsResult, err
+			}
+		}
+	}
+
+	if options.ReturnHeaders {
+		jsResult.Headers = getFetchResponseHeaders(resp)
+	}
+
+	if options.ReturnCookies {
+		var err error
+
+		jsResult.Cookies, err = getFetchResponseCookies(resp)
+		if err != nil {
+			return jsResult, err
+		}
+	}
+
+	return jsResult, nil
+}
+
+// fetchAsync sends the request with the client, or the default client if nil.
+func fetchAsync(client *fetchClient, fetchUrl string, options JsFetchOptions, body []byte, callback progpAPI.JsFunction) {
+	progpAPI.SafeGoRoutine(func() {
+		if options.Method == "" {
+			options.Method = "GET"
+		}
+
+		if client == nil {
+			var err error
+
+			client, err = getFetchClient(options.Tls)
+			if err != nil {
+				callback.CallWithError(err)
+				return
+			}
+		}
+
+		res, err := doFetch(client, fetchUrl, options, body, options.StreamBodyToFile != "", nil)
+		if err != nil {
+			callback.CallWithError(err)
+			return
+		}
+
+		defer fasthttp.ReleaseResponse(res.resp)
+
+		jsResult, err := buildFetchResult(res.resp, options)
+		if err != nil {
+			callback.CallWithError(err)
+			return
+		}
+
+		asJson, err := json.Marshal(jsResult)
+		if err != nil {
+			callback.CallWithError(err)
+			return
+		}
+
+		callback.CallWithStringBuffer2(asJson)
+	})
+}
+
+func JsFetchAsync(url string, options JsFetchOptions, callback progpAPI.JsFunction) {
+	fetchAsync(nil, url, options, nil, callback)
+}
+
+// JsFetchWithBytesAsync is like JsFetchAsync but sends a binary body.
+func JsFetchWithBytesAsync(url string, options JsFetchOptions, body []byte, callback progpAPI.JsFunction) {
+	// The buffer memory is owned by javascript, it must be copied.
+	fetchAsync(nil, url, options, bytes.Clone(body), callback)
+}
@@ -0,0 +1,322 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/progpjs/progpAPI/v2"
+	"github.com/valyala/fasthttp"
+	"io"
+	"sync"
+)
+
+// FetchResponseChunkSize is the max size of the chunks returned when streaming a response body.
+const FetchResponseChunkSize = 64 * 1024
+
+var ResponseDisposedError = errors.New("response disposed")
+
+// jsFetchResponse is the value of the SharedResource returned to javascript for a fetch.
+// The body isn't read until javascript asks for it, which allows streaming it.
+type jsFetchResponse struct {
+	*fetchResponse
+
+	// mutex avoids releasing the response while his body is read.
+	mutex      sync.Mutex
+	isDisposed bool
+	bodyReader io.Reader
+}
+
+type jsFetchResponseInfo struct {
+	Status     int         `json:"status"`
+	StatusText string      `json:"statusText"`
+	Url        string      `json:"url"`
+	Redirected bool        `json:"redirected"`
+	Headers    [][2]string `json:"headers"`
+}
+
+func getJsFetchResponse(resResponse *progpAPI.SharedResource) (*jsFetchResponse, error) {
+	res, ok := resResponse.Value.(*jsFetchResponse)
+	if !ok {
+		return nil, errors.New("invalid resource")
+	}
+
+	return res, nil
+}
+
+func (m *jsFetchResponse) dispose() {
+	// Waiting for a pending read must not block the javascript thread.
+	progpAPI.SafeGoRoutine(func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		if !m.isDisposed {
+			m.isDisposed = true
+			fasthttp.ReleaseResponse(m.resp)
+		}
+	})
+}
+
+// getBodyReader returns a reader on the uncompressed body.
+// Must be called with the mutex locked.
+func (m *jsFetchResponse) getBodyReader() (io.Reader, error) {
+	if m.bodyReader != nil {
+		return m.bodyReader, nil
+	}
+
+	reader := m.resp.BodyStream()
+
+	if reader == nil {
+		reader = bytes.NewReader(m.resp.Body())
+	}
+
+	reader, err := newDecodingReader(reader, string(m.resp.Header.ContentEncoding()))
+	if err != nil {
+		return nil, err
+	}
+
+	m.bodyReader = reader
+	return reader, nil
+}
+
+func (m *jsFetchResponse) readAll() ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.isDisposed {
+		return nil, ResponseDisposedError
+	}
+
+	reader, err := m.getBodyReader()
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(reader)
+}
+
+// readChunk returns the next chunk of the body, or nil once the whole body is read.
+func (m *jsFetchResponse) readChunk() ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.isDisposed {
+		return nil, ResponseDisposedError
+	}
+
+	reader, err := m.getBodyReader()
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := make([]byte, FetchResponseChunkSize)
+
+	for {
+		n, err := reader.Read(buffer)
+
+		if n != 0 {
+			return buffer[:n], nil
+		}
+
+		if err == io.EOF {
+			return nil, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fetchResponseAsync sends the request with the client, or the default client if nil,
+// and returns a resource on the response.
+// The abort channel can be nil.
+func fetchResponseAsync(rc *progpAPI.SharedResourceContainer, client *fetchClient, url string, options JsFetchOptions, body []byte, abort <-chan struct{}, callback progpAPI.JsFunction) {
+	if len(body) == 0 {
+		body = nil
+	} else {
+		// The buffer memory is owned by javascript, it must be copied.
+		body = bytes.Clone(body)
+	}
+
+	progpAPI.SafeGoRoutine(func() {
+		if options.Method == "" {
+			options.Method = "GET"
+		}
+
+		if client == nil {
+			var err error
+
+			client, err = getFetchClient(options.Tls)
+			if err != nil {
+				callback.CallWithError(err)
+				return
+			}
+		}
+
+		res, err := doFetch(client, url, options, body, true, abort)
+		if err != nil {
+			callback.CallWithError(err)
+			return
+		}
+
+		jsRes := &jsFetchResponse{fetchResponse: res}
+
+		resResponse := rc.NewSharedResource(jsRes, func(value any) {
+			value.(*jsFetchResponse).dispose()
+		})
+
+		callback.CallWithResource2(resResponse)
+	})
+}
+
+// JsFetchResponseAsync sends a request and returns a resource on the response, without reading his body.
+// The body is sent only if not empty.
+func JsFetchResponseAsync(rc *progpAPI.SharedResourceContainer, url string, options JsFetchOptions, body []byte, callback progpAPI.JsFunction) {
+	fetchResponseAsync(rc, nil, url, options, body, nil, callback)
+}
+
+// fetchAbort allows javascript to abort a fetch in progress.
+type fetchAbort struct {
+	done chan struct{}
+	once sync.Once
+}
+
+func (m *fetchAbort) abort() {
+	m.once.Do(func() {
+		close(m.done)
+	})
+}
+
+func getFetchAbort(resAbort *progpAPI.SharedResource) (*fetchAbort, error) {
+	abort, ok := resAbort.Value.(*fetchAbort)
+	if !ok {
+		return nil, errors.New("invalid resource")
+	}
+
+	return abort, nil
+}
+
+// JsFetchAbortCreate returns a resource allowing to abort a fetch started with JsFetchResponseWithAbortAsync.
+func JsFetchAbortCreate(rc *progpAPI.SharedResourceContainer) *progpAPI.SharedResource {
+	return rc.NewSharedResource(&fetchAbort{done: make(chan struct{})}, nil)
+}
+
+// JsFetchAbort aborts the fetch, which ends with an error if still waiting for the response.
+// A response already returned isn't concerned.
+func JsFetchAbort(resAbort *progpAPI.SharedResource) error {
+	abort, err := getFetchAbort(resAbort)
+	if err != nil {
+		return err
+	}
+
+	abort.abort()
+	return nil
+}
+
+// JsFetchResponseWithAbortAsync is like JsFetchResponseAsync, but the request can be aborted with JsFetchAbort.
+func JsFetchResponseWithAbortAsync(rc *progpAPI.SharedResourceContainer, url string, options JsFetchOptions, body []byte, resAbort *progpAPI.SharedResource, callback progpAPI.JsFunction) {
+	abort, err := getFetchAbort(resAbort)
+	if err != nil {
+		callback.CallWithError(err)
+		return
+	}
+
+	fetchResponseAsync(rc, nil, url, options, body, abort.done, callback)
+}
+
+// JsFetchResponseInfo returns, as json, the status and the headers of the response.
+func JsFetchResponseInfo(resResponse *progpAPI.SharedResource) (progpAPI.StringBuffer, error) {
+	res, err := getJsFetchResponse(resResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	info := jsFetchResponseInfo{
+		Status:     res.resp.StatusCode(),
+		StatusText: fasthttp.StatusMessage(res.resp.StatusCode()),
+		Url:        res.url,
+		Redirected: res.redirected,
+		Headers:    [][2]string{},
+	}
+
+	// Using a list keeps the headers which are set more than once, like "Set-Cookie".
+	res.resp.Header.VisitAll(func(key, value []byte) {
+		info.Headers = append(info.Headers, [2]string{string(key), string(value)})
+	})
+
+	return json.Marshal(info)
+}
+
+// JsFetchResponseCookies returns, as json, the cookies set by the response.
+func JsFetchResponseCookies(resResponse *progpAPI.SharedResource) (progpAPI.StringBuffer, error) {
+	res, err := getJsFetchResponse(resResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	cookies, err := getFetchResponseCookies(res.resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(cookies)
+}
+
+// JsFetchResponseReadAllAsync returns the whole body of the response.
+func JsFetchResponseReadAllAsync(resResponse *progpAPI.SharedResource, callback progpAPI.JsFunction) {
+	res, err := getJsFetchResponse(resResponse)
+	if err != nil {
+		callback.CallWithError(err)
+		return
+	}
+
+	progpAPI.SafeGoRoutine(func() {
+		b, err := res.readAll()
+		if err != nil {
+			callback.CallWithError(err)
+			return
+		}
+
+		callback.CallWithArrayBuffer2(b)
+	})
+}
+
+// JsFetchResponseReadChunkAsync returns the next chunk of the body, or undefined once the body is fully read.
+func JsFetchResponseReadChunkAsync(resResponse *progpAPI.SharedResource, callback progpAPI.JsFunction) {
+	res, err := getJsFetchResponse(resResponse)
+	if err != nil {
+		callback.CallWithError(err)
+		return
+	}
+
+	progpAPI.SafeGoRoutine(func() {
+		b, err := res.readChunk()
+		if err != nil {
+			callback.CallWithError(err)
+			return
+		}
+
+		if b == nil {
+			callback.CallWithUndefined()
+			return
+		}
+
+		callback.CallWithArrayBuffer2(b)
+	})
+}
@@ -0,0 +1,150 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"github.com/valyala/fasthttp"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFetchClientKey(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+
+	if err := os.WriteFile(caFile, []byte("first"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	key1, err := getFetchClientKey(JsFetchTlsOptions{CaFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A changed file must give another key, so that the file is reloaded.
+	_ = os.WriteFile(caFile, []byte("second!"), 0600)
+	_ = os.Chtimes(caFile, time.Now(), time.Now().Add(time.Minute))
+
+	key2, _ := getFetchClientKey(JsFetchTlsOptions{CaFile: caFile})
+
+	if key1 == key2 {
+		t.Fatal("the key must change with the CA file")
+	}
+
+	if _, err = getFetchClientKey(JsFetchTlsOptions{CaFile: caFile + ".missing"}); err == nil {
+		t.Fatal("a missing CA file must be an error")
+	}
+}
+
+func TestFetchClientCacheIsBounded(t *testing.T) {
+	cache := newFetchClientCache(4)
+
+	first, err := cache.get(JsFetchTlsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again, _ := cache.get(JsFetchTlsOptions{}); again != first {
+		t.Fatal("the client must be reused")
+	}
+
+	firstKey, err := getFetchClientKey(JsFetchTlsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.mutex.Lock()
+
+	for i := 0; i < cache.maxSize*2; i++ {
+		cache.add(strconv.Itoa(i), &fetchClient{client: &fasthttp.Client{}})
+	}
+
+	cache.mutex.Unlock()
+
+	if (len(cache.entries) != cache.maxSize) || (cache.lru.Len() != cache.maxSize) {
+		t.Fatalf("cache not bounded: %d entries", len(cache.entries))
+	}
+
+	// The most recently added clients are kept.
+	if cache.entries[strconv.Itoa(cache.maxSize*2-1)] == nil {
+		t.Fatal("last client evicted")
+	}
+
+	if cache.entries[firstKey] != nil {
+		t.Fatal("least recently used client not evicted")
+	}
+
+	// A client used again must not be the next one evicted.
+	kept := strconv.Itoa(cache.maxSize)
+
+	cache.mutex.Lock()
+	cache.lru.MoveToFront(cache.entries[kept])
+	cache.add("new", &fetchClient{client: &fasthttp.Client{}})
+	cache.mutex.Unlock()
+
+	if cache.entries[kept] == nil {
+		t.Fatal("recently used client evicted")
+	}
+}
+
+func TestFetchAbort(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan bool)
+	server := &fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) {
+		<-release
+		ctx.SetBodyString("late")
+	}}
+
+	go func() { _ = server.Serve(ln) }()
+
+	defer func() { _ = server.Shutdown() }()
+	defer close(release)
+
+	client := &fetchClient{client: &fasthttp.Client{}}
+	abort := &fetchAbort{done: make(chan struct{})}
+
+	time.AfterFunc(50*time.Millisecond, abort.abort)
+
+	start := time.Now()
+	res, err := doFetch(client, "http://"+ln.Addr().String()+"/", JsFetchOptions{Method: "GET"}, nil, true, abort.done)
+
+	if err != FetchAbortedError {
+		t.Fatalf("got %v, expected FetchAbortedError", err)
+	}
+
+	if res != nil {
+		t.Fatal("no response expected once aborted")
+	}
+
+	if time.Since(start) > time.Second {
+		t.Fatal("the fetch must end as soon as aborted")
+	}
+
+	// Aborting again, or after the end, does nothing.
+	abort.abort()
+
+	if _, err = doFetch(client, "http://"+ln.Addr().String()+"/", JsFetchOptions{Method: "GET"}, nil, true, abort.done); err != FetchAbortedError {
+		t.Fatalf("got %v, an aborted signal must stop the fetch before sending it", err)
+	}
+}
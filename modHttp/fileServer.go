@@ -0,0 +1,834 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/progpjs/httpServer/v2"
+	"github.com/progpjs/progpAPI/v2"
+	"github.com/valyala/fasthttp"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FileServerDataFunctionTimeout is the time the javascript data function has to return the data of a request.
+const FileServerDataFunctionTimeout = 500 * time.Millisecond
+
+// gPreCompressedExtensions are the extensions of the pre-compressed files, by encoding.
+// The order is the order of preference.
+var gPreCompressedExtensions = []struct{ encoding, extension string }{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// fileVariant is the original content of a file, or one of his pre-compressed versions.
+type fileVariant struct {
+	filePath string
+	size     int64
+
+	// content is nil if the file is too large to be kept in memory.
+	content []byte
+}
+
+// fileCacheKey identifies a cache entry. The same uri can have an entry for each data.
+type fileCacheKey struct {
+	uri  string
+	data string
+}
+
+// fileCacheEntry contains what is needed to serve a file without accessing the disk.
+type fileCacheEntry struct {
+	uri string
+
+	// data is the value returned by the data function for the request which loaded the entry.
+	data string
+
+	contentType string
+	etag        string
+	modTime     time.Time
+
+	// identity is nil if only the pre-compressed versions of the file exist.
+	identity *fileVariant
+
+	// encoded contains the pre-compressed versions of the file, by encoding.
+	encoded map[string]*fileVariant
+
+	hitCount      atomic.Int64
+	lastRequested atomic.Int64
+
+	// loadDate is when the files have been read, which allows expiring the entry.
+	loadDate time.Time
+
+	// memorySize is the size, in bytes, of the content kept in memory.
+	memorySize int64
+}
+
+func (m *fileCacheEntry) key() fileCacheKey {
+	return fileCacheKey{uri: m.uri, data: m.data}
+}
+
+func (m *fileCacheEntry) getLastRequestedDate() time.Time {
+	return time.Unix(0, m.lastRequested.Load())
+}
+
+type jsFileServer struct {
+	// requestPath is the path the files are served from, without his trailing slash.
+	requestPath string
+	dirPath     string
+	options     JsServeFilesOptions
+
+	// onFileNotFound is called before returning a 404, which allows creating the file.
+	onFileNotFound func(call httpServer.HttpRequest, filePath string, data string) error
+
+	// getData, if set, returns the data of a request. The cache keeps an entry for each uri and data,
+	// which allows the hooks to select the content and to remove only some versions of a file.
+	getData func(call httpServer.HttpRequest) (string, error)
+
+	// onChange is called when a watched file changes.
+	onChange func(change fileChange)
+
+	// hooksMutex protects onFileNotFound, getData and onChange, which are set
+	// from the javascript thread while the requests and the watcher read them.
+	hooksMutex sync.RWMutex
+
+	cache      map[fileCacheKey]*fileCacheEntry
+	cacheMutex sync.RWMutex
+	isDisposed atomic.Bool
+
+	// pageEntries contains the SPA fallback and the error pages, by file path.
+	pageEntries map[string]*fileCacheEntry
+
+	stats fileCacheStats
+
+	// watcher is nil if the files aren't watched.
+	watcher fileWatcher
+}
+
+func getJsFileServer(resFS *progpAPI.SharedResource) (*jsFileServer, error) {
+	fs, ok := resFS.Value.(*jsFileServer)
+	if !ok {
+		return nil, errors.New("invalid resource")
+	}
+
+	return fs, nil
+}
+
+func newJsFileServer(requestPath string, dirPath string, options JsServeFilesOptions) (*jsFileServer, error) {
+	dirPath, err := filepath.Abs(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.IndexFiles == nil {
+		options.IndexFiles = []string{"index.html"}
+	}
+
+	if options.Cache, err = options.Cache.withDefaults(); err != nil {
+		return nil, err
+	}
+
+	return &jsFileServer{
+		requestPath: strings.TrimSuffix(requestPath, "/"),
+		dirPath:     dirPath,
+		options:     options,
+		cache:       make(map[fileCacheKey]*fileCacheEntry),
+		pageEntries: make(map[string]*fileCacheEntry),
+	}, nil
+}
+
+// register binds the file server to the root path and his sub-paths.
+func (m *jsFileServer) register(host *httpServer.HttpHost) {
+	root := m.requestPath + "/"
+
+	for _, verb := range []string{"GET", "HEAD"} {
+		registerRoute(host, verb, root, m.serve)
+		registerRoute(host, verb, root+"*", m.serve)
+
+		if m.requestPath != "" {
+			registerRoute(host, verb, m.requestPath, m.serve)
+		}
+	}
+}
+
+func (m *jsFileServer) serve(call httpServer.HttpRequest) error {
+	ctx, err := getFastHttpCtx(call)
+
+	if err == UnsupportedRequestError {
+		return m.serveWithoutCache(call)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if m.isDisposed.Load() {
+		call.GetHost().OnNotFound(call)
+		return nil
+	}
+
+	uri := call.Path()
+
+	data, err := m.getRequestData(call)
+	if err != nil {
+		return m.sendError(ctx, err)
+	}
+
+	m.cacheMutex.RLock()
+	entry := m.cache[fileCacheKey{uri: uri, data: data}]
+	m.cacheMutex.RUnlock()
+
+	if (entry != nil) && m.isExpired(entry) {
+		m.removeEntry(entry)
+		entry = nil
+	}
+
+	if entry != nil {
+		m.stats.hits.Add(1)
+		return m.sendEntry(ctx, entry)
+	}
+
+	relPath, ok := m.getRelativePath(uri)
+	if !ok {
+		return m.sendNotFound(call, ctx)
+	}
+
+	filePath := filepath.Join(m.dirPath, filepath.FromSlash(relPath))
+
+	stat, err := os.Stat(filePath)
+
+	if (err == nil) && stat.IsDir() {
+		// The relative links of the index file need the trailing slash.
+		if !strings.HasSuffix(uri, "/") {
+			ctx.Redirect(uri+"/", 301)
+			return nil
+		}
+
+		indexPath := m.findIndexFile(filePath)
+
+		if indexPath == "" {
+			if m.options.DirectoryListing {
+				if err = m.sendDirectoryListing(ctx, uri, filePath); err != nil {
+					return m.sendError(ctx, err)
+				}
+
+				return nil
+			}
+
+			return m.sendNotFound(call, ctx)
+		}
+
+		filePath = indexPath
+	}
+
+	entry, err = m.loadEntry(uri, data, filePath)
+
+	if onFileNotFound := m.getOnFileNotFound(); os.IsNotExist(err) && (onFileNotFound != nil) {
+		if err = onFileNotFound(call, filePath, data); err != nil {
+			return m.sendError(ctx, err)
+		}
+
+		// The hook can have created the file.
+		entry, err = m.loadEntry(uri, data, filePath)
+	}
+
+	if os.IsNotExist(err) {
+		return m.sendNotFound(call, ctx)
+	}
+
+	if err != nil {
+		return m.sendError(ctx, err)
+	}
+
+	m.stats.misses.Add(1)
+	m.addEntry(entry)
+
+	return m.sendEntry(ctx, entry)
+}
+
+// serveWithoutCache is used when the server implementation doesn't give access to the fasthttp context.
+// The file is sent with the implementation's own SendFile, without the cache, the pre-compressed files,
+// the SPA fallback and the error pages.
+func (m *jsFileServer) serveWithoutCache(call httpServer.HttpRequest) error {
+	if m.isDisposed.Load() {
+		call.GetHost().OnNotFound(call)
+		return nil
+	}
+
+	relPath, ok := m.getRelativePath(call.Path())
+	if !ok {
+		call.GetHost().OnNotFound(call)
+		return nil
+	}
+
+	filePath := filepath.Join(m.dirPath, filepath.FromSlash(relPath))
+
+	if stat, err := os.Stat(filePath); (err == nil) && stat.IsDir() {
+		filePath = m.findIndexFile(filePath)
+	} else if onFileNotFound := m.getOnFileNotFound(); os.IsNotExist(err) && (onFileNotFound != nil) {
+		data, err := m.getRequestData(call)
+		if err != nil {
+			return err
+		}
+
+		if err = onFileNotFound(call, filePath, data); err != nil {
+			return err
+		}
+	}
+
+	if filePath == "" {
+		call.GetHost().OnNotFound(call)
+		return nil
+	}
+
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		call.GetHost().OnNotFound(call)
+		return nil
+	}
+
+	return call.SendFile(filePath)
+}
+
+// getRequestData returns the data of the request, or "" if there is no data function.
+func (m *jsFileServer) getRequestData(call httpServer.HttpRequest) (string, error) {
+	m.hooksMutex.RLock()
+	getData := m.getData
+	m.hooksMutex.RUnlock()
+
+	if getData == nil {
+		return "", nil
+	}
+
+	return getData(call)
+}
+
+// setDataFunction set the function returning the data of a request.
+// The cached entries are removed, since they have been loaded without it.
+func (m *jsFileServer) setDataFunction(getData func(call httpServer.HttpRequest) (string, error)) {
+	m.hooksMutex.Lock()
+	m.getData = getData
+	m.hooksMutex.Unlock()
+
+	m.removeAll()
+}
+
+func (m *jsFileServer) getOnFileNotFound() func(call httpServer.HttpRequest, filePath string, data string) error {
+	m.hooksMutex.RLock()
+	defer m.hooksMutex.RUnlock()
+
+	return m.onFileNotFound
+}
+
+func (m *jsFileServer) setOnFileNotFound(onFileNotFound func(call httpServer.HttpRequest, filePath string, data string) error) {
+	m.hooksMutex.Lock()
+	defer m.hooksMutex.Unlock()
+
+	m.onFileNotFound = onFileNotFound
+}
+
+func (m *jsFileServer) getOnChange() func(change fileChange) {
+	m.hooksMutex.RLock()
+	defer m.hooksMutex.RUnlock()
+
+	return m.onChange
+}
+
+func (m *jsFileServer) setOnChange(onChange func(change fileChange)) {
+	m.hooksMutex.Lock()
+	defer m.hooksMutex.Unlock()
+
+	m.onChange = onChange
+}
+
+// isSpaRoute returns true if the path is a route of a single-page-app, and not an asset.
+// The assets are recognized by the extension of their name.
+func isSpaRoute(uri string) bool {
+	return path.Ext(path.Base(uri)) == ""
+}
+
+// getPageEntry returns the entry for the SPA fallback or an error page.
+// The file path is relative to the served directory, unless absolute.
+func (m *jsFileServer) getPageEntry(filePath string) (*fileCacheEntry, error) {
+	m.cacheMutex.RLock()
+	entry := m.pageEntries[filePath]
+	m.cacheMutex.RUnlock()
+
+	if (entry != nil) && !m.isExpired(entry) {
+		return entry, nil
+	}
+
+	fullPath := filePath
+
+	if !filepath.IsAbs(fullPath) {
+		fullPath = filepath.Join(m.dirPath, filepath.FromSlash(filePath))
+	}
+
+	entry, err := m.loadEntry(m.requestPath+"/"+path.Base(filepath.ToSlash(filePath)), "", fullPath)
+	if err != nil {
+		return nil, err
+	}
+
+	m.cacheMutex.Lock()
+	m.pageEntries[filePath] = entry
+	m.cacheMutex.Unlock()
+
+	return entry, nil
+}
+
+// sendErrorPage sends the page set for this status code.
+// Returns false if there is none.
+func (m *jsFileServer) sendErrorPage(ctx *fasthttp.RequestCtx, statusCode int) bool {
+	filePath := m.options.ErrorPages[statusCode]
+	if filePath == "" {
+		return false
+	}
+
+	entry, err := m.getPageEntry(filePath)
+	if err != nil {
+		return false
+	}
+
+	ctx.SetStatusCode(statusCode)
+	ctx.Response.Header.Set("Cache-Control", "no-cache")
+
+	return sendEntryContent(ctx, entry) == nil
+}
+
+// sendNotFound sends the SPA fallback if the path is a route of the app,
+// otherwise the 404 error page.
+func (m *jsFileServer) sendNotFound(call httpServer.HttpRequest, ctx *fasthttp.RequestCtx) error {
+	if (m.options.SpaFallback != "") && isSpaRoute(call.Path()) {
+		entry, err := m.getPageEntry(m.options.SpaFallback)
+
+		if err == nil {
+			return m.sendEntry(ctx, entry)
+		}
+	}
+
+	if !m.sendErrorPage(ctx, 404) {
+		call.GetHost().OnNotFound(call)
+	}
+
+	return nil
+}
+
+// sendError sends the 500 error page, or returns the error if there is none.
+func (m *jsFileServer) sendError(ctx *fasthttp.RequestCtx, err error) error {
+	if m.sendErrorPage(ctx, 500) {
+		return nil
+	}
+
+	return err
+}
+
+// getRelativePath returns the path of the file, relative to the served directory.
+// Returns false if the path is forbidden.
+func (m *jsFileServer) getRelativePath(uri string) (string, bool) {
+	relPath := strings.TrimPrefix(uri, m.requestPath)
+	relPath = path.Clean("/" + relPath)
+
+	if !m.options.ShowDotFiles {
+		for _, segment := range strings.Split(relPath, "/") {
+			if strings.HasPrefix(segment, ".") {
+				return "", false
+			}
+		}
+	}
+
+	return relPath, true
+}
+
+func (m *jsFileServer) findIndexFile(dirPath string) string {
+	for _, name := range m.options.IndexFiles {
+		filePath := filepath.Join(dirPath, name)
+
+		if stat, err := os.Stat(filePath); (err == nil) && !stat.IsDir() {
+			return filePath
+		}
+	}
+
+	return ""
+}
+
+// loadVariant reads the file, or only his size if it's larger than maxMemorySize.
+func loadVariant(filePath string, maxMemorySize int64) (*fileVariant, os.FileInfo, error) {
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if stat.IsDir() {
+		return nil, nil, os.ErrNotExist
+	}
+
+	variant := &fileVariant{filePath: filePath, size: stat.Size()}
+
+	if stat.Size() <= maxMemorySize {
+		if variant.content, err = os.ReadFile(filePath); err != nil {
+			return nil, nil, err
+		}
+
+		variant.size = int64(len(variant.content))
+	}
+
+	return variant, stat, nil
+}
+
+// isPreCompressedFileValid checks that a pre-compressed file matches the original file.
+// It must not be older than it, and for gzip, the size stored at his end must be the original size.
+func isPreCompressedFileValid(variant *fileVariant, stat os.FileInfo, encoding string, original *fileVariant, originalStat os.FileInfo) bool {
+	if (encoding == "gzip") && (variant.content != nil) {
+		c := variant.content
+
+		if (len(c) < 18) || (c[0] != 0x1f) || (c[1] != 0x8b) {
+			return false
+		}
+
+		// The gzip trailer contains the uncompressed size, modulo 2^32.
+		if (original != nil) && (binary.LittleEndian.Uint32(c[len(c)-4:]) != uint32(original.size)) {
+			return false
+		}
+	}
+
+	if originalStat != nil {
+		return !stat.ModTime().Before(originalStat.ModTime())
+	}
+
+	return true
+}
+
+// loadEntry reads the file and his pre-compressed versions.
+// The pre-compressed versions can exist without the original file.
+func (m *jsFileServer) loadEntry(uri string, data string, filePath string) (*fileCacheEntry, error) {
+	entry := &fileCacheEntry{uri: uri, data: data, encoded: make(map[string]*fileVariant), loadDate: time.Now()}
+
+	identity, identityStat, err := loadVariant(filePath, m.options.Cache.MaxEntrySize)
+
+	if err == nil {
+		entry.identity = identity
+		entry.modTime = identityStat.ModTime()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if !m.options.DisablePreCompressed {
+		for _, e := range gPreCompressedExtensions {
+			variant, stat, err := loadVariant(filePath+e.extension, m.options.Cache.MaxEntrySize)
+			if err != nil {
+				continue
+			}
+
+			if !isPreCompressedFileValid(variant, stat, e.encoding, identity, identityStat) {
+				continue
+			}
+
+			entry.encoded[e.encoding] = variant
+
+			if (identity == nil) && stat.ModTime().After(entry.modTime) {
+				entry.modTime = stat.ModTime()
+			}
+		}
+	}
+
+	if (entry.identity == nil) && (len(entry.encoded) == 0) {
+		return nil, os.ErrNotExist
+	}
+
+	entry.contentType = mime.TypeByExtension(filepath.Ext(filePath))
+
+	if entry.contentType == "" {
+		if (identity != nil) && (identity.content != nil) {
+			entry.contentType = http.DetectContentType(identity.content)
+		} else {
+			entry.contentType = "application/octet-stream"
+		}
+	}
+
+	var size int64
+
+	if identity != nil {
+		size = identity.size
+	}
+
+	entry.etag = makeFileEtag(entry.modTime, size)
+
+	if identity != nil {
+		entry.memorySize += int64(len(identity.content))
+	}
+
+	for _, variant := range entry.encoded {
+		entry.memorySize += int64(len(variant.content))
+	}
+
+	return entry, nil
+}
+
+// getCacheControl returns the Cache-Control header for this file, or "" if none.
+func (m *jsFileServer) getCacheControl(uri string) string {
+	if m.options.MaxAge == nil {
+		return ""
+	}
+
+	maxAge, ok := m.options.MaxAge[strings.ToLower(path.Ext(uri))]
+
+	if !ok {
+		if maxAge, ok = m.options.MaxAge["*"]; !ok {
+			return ""
+		}
+	}
+
+	if maxAge < 0 {
+		return "no-cache"
+	}
+
+	return "public, max-age=" + strconv.Itoa(maxAge)
+}
+
+// isNotModified returns true if the client already has this version of the file.
+func (m *jsFileServer) isNotModified(ctx *fasthttp.RequestCtx, entry *fileCacheEntry) bool {
+	etag := entry.etag
+	if m.options.DisableEtag {
+		etag = ""
+	}
+
+	modTime := entry.modTime
+	if m.options.DisableLastModified {
+		modTime = time.Time{}
+	}
+
+	return isRequestNotModified(ctx, etag, modTime)
+}
+
+func (m *jsFileServer) sendEntry(ctx *fasthttp.RequestCtx, entry *fileCacheEntry) error {
+	entry.hitCount.Add(1)
+	entry.lastRequested.Store(time.Now().UnixNano())
+
+	if cacheControl := m.getCacheControl(entry.uri); cacheControl != "" {
+		ctx.Response.Header.Set("Cache-Control", cacheControl)
+	}
+
+	if !m.options.DisableEtag {
+		ctx.Response.Header.Set("ETag", entry.etag)
+	}
+
+	if !m.options.DisableLastModified {
+		ctx.Response.Header.Set("Last-Modified", entry.modTime.UTC().Format(http.TimeFormat))
+	}
+
+	if len(entry.encoded) != 0 {
+		ctx.Response.Header.Set("Vary", "Accept-Encoding")
+	}
+
+	if m.isNotModified(ctx, entry) {
+		ctx.SetStatusCode(304)
+		return nil
+	}
+
+	ctx.SetStatusCode(200)
+	return sendEntryContent(ctx, entry)
+}
+
+// sendEntryContent set the body of the response, using the encoding preferred by the client.
+func sendEntryContent(ctx *fasthttp.RequestCtx, entry *fileCacheEntry) error {
+	ctx.SetContentType(entry.contentType)
+
+	var encodings []string
+
+	for _, e := range gPreCompressedExtensions {
+		if entry.encoded[e.encoding] != nil {
+			encodings = append(encodings, e.encoding)
+		}
+	}
+
+	if encoding := negotiateContentEncoding(string(ctx.Request.Header.Peek("Accept-Encoding")), encodings); encoding != "" {
+		ctx.Response.Header.SetContentEncoding(encoding)
+		return sendFileVariant(ctx, entry.encoded[encoding])
+	}
+
+	if entry.identity != nil {
+		return sendFileVariant(ctx, entry.identity)
+	}
+
+	// Only a pre-compressed version exists, and the client doesn't accept it.
+	return sendDecompressedVariant(ctx, entry)
+}
+
+func sendFileVariant(ctx *fasthttp.RequestCtx, variant *fileVariant) error {
+	if variant.content != nil {
+		// The content is never modified, it can be shared between the responses.
+		ctx.Response.SetBodyRaw(variant.content)
+		return nil
+	}
+
+	file, err := os.Open(variant.filePath)
+	if err != nil {
+		return err
+	}
+
+	// The file is closed by fasthttp once sent.
+	ctx.Response.SetBodyStream(file, int(variant.size))
+	return nil
+}
+
+// decodingFileReader uncompress a file while reading it, and closes the file with it.
+type decodingFileReader struct {
+	io.Reader
+	file *os.File
+}
+
+func (m *decodingFileReader) Close() error {
+	return m.file.Close()
+}
+
+func sendDecompressedVariant(ctx *fasthttp.RequestCtx, entry *fileCacheEntry) error {
+	for _, e := range gPreCompressedExtensions {
+		variant := entry.encoded[e.encoding]
+		if variant == nil {
+			continue
+		}
+
+		if variant.content != nil {
+			reader, err := newDecodingReader(bytes.NewReader(variant.content), e.encoding)
+			if err != nil {
+				return err
+			}
+
+			ctx.Response.SetBodyStream(reader, -1)
+			return nil
+		}
+
+		file, err := os.Open(variant.filePath)
+		if err != nil {
+			return err
+		}
+
+		reader, err := newDecodingReader(file, e.encoding)
+		if err != nil {
+			_ = file.Close()
+			return err
+		}
+
+		ctx.Response.SetBodyStream(&decodingFileReader{Reader: reader, file: file}, -1)
+		return nil
+	}
+
+	return os.ErrNotExist
+}
+
+func (m *jsFileServer) sendDirectoryListing(ctx *fasthttp.RequestCtx, uri string, dirPath string) error {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	var sb strings.Builder
+	title := html.EscapeString(uri)
+
+	sb.WriteString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>" + title + "</title></head><body>\n")
+	sb.WriteString("<h1>" + title + "</h1>\n<ul>\n")
+
+	if uri != m.requestPath+"/" {
+		sb.WriteString("<li><a href=\"../\">../</a></li>\n")
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+
+		if !m.options.ShowDotFiles && strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		if e.IsDir() {
+			name += "/"
+		}
+
+		sb.WriteString("<li><a href=\"" + html.EscapeString((&url.URL{Path: name}).EscapedPath()) + "\">" + html.EscapeString(name) + "</a></li>\n")
+	}
+
+	sb.WriteString("</ul>\n</body></html>\n")
+
+	ctx.SetStatusCode(200)
+	ctx.SetContentType("text/html; charset=utf-8")
+	ctx.SetBodyString(sb.String())
+	return nil
+}
+
+func (m *jsFileServer) removeAll() {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+
+	m.cache = make(map[fileCacheKey]*fileCacheEntry)
+	m.pageEntries = make(map[string]*fileCacheEntry)
+	m.stats.memorySize = 0
+}
+
+// removeUri removes the entry of this uri and data.
+func (m *jsFileServer) removeUri(uri string, data string) {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+
+	key := fileCacheKey{uri: uri, data: data}
+
+	if entry := m.cache[key]; entry != nil {
+		delete(m.cache, key)
+		m.stats.memorySize -= entry.memorySize
+	}
+}
+
+// visitCache calls f for each entry of the cache.
+// The entries are copied first, which allows f to modify the cache.
+func (m *jsFileServer) visitCache(f func(entry *fileCacheEntry)) {
+	m.cacheMutex.RLock()
+	entries := make([]*fileCacheEntry, 0, len(m.cache))
+
+	for _, entry := range m.cache {
+		entries = append(entries, entry)
+	}
+
+	m.cacheMutex.RUnlock()
+
+	for _, entry := range entries {
+		f(entry)
+	}
+}
+
+func (m *jsFileServer) dispose() {
+	m.isDisposed.Store(true)
+
+	if m.watcher != nil {
+		m.watcher.close()
+	}
+
+	m.removeAll()
+}
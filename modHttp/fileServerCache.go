@@ -0,0 +1,200 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultFileServerMaxCachedFileSize is the max size, in bytes, of a file kept in memory.
+// The bigger files are read from the disk for each request.
+const DefaultFileServerMaxCachedFileSize = 1024 * 1024
+
+const (
+	// FileCachePolicyLru evicts the entries requested the least recently.
+	FileCachePolicyLru = "lru"
+
+	// FileCachePolicyLfu evicts the entries requested the least often.
+	FileCachePolicyLfu = "lfu"
+)
+
+type JsFileServerCacheOptions struct {
+	// MaxSize is the max size, in bytes, of the content kept in memory for all the files. Unlimited if 0.
+	MaxSize int64 `json:"maxSize"`
+
+	// MaxEntrySize is the max size, in bytes, of a file kept in memory.
+	// The bigger files are read from the disk for each request.
+	MaxEntrySize int64 `json:"maxEntrySize"`
+
+	// Policy selects the entries evicted when MaxSize is reached, "lru" or "lfu". Default is "lru".
+	Policy string `json:"policy"`
+
+	// Ttl is the time, in milliseconds, after which the files are read again. Never if 0.
+	Ttl int `json:"ttl"`
+}
+
+// withDefaults returns a copy of the options where the missing values are set.
+func (m JsFileServerCacheOptions) withDefaults() (JsFileServerCacheOptions, error) {
+	if m.MaxEntrySize <= 0 {
+		m.MaxEntrySize = DefaultFileServerMaxCachedFileSize
+	}
+
+	switch m.Policy {
+	case "":
+		m.Policy = FileCachePolicyLru
+	case FileCachePolicyLru, FileCachePolicyLfu:
+	default:
+		return m, errors.New("unknown cache policy: " + m.Policy)
+	}
+
+	return m, nil
+}
+
+type fileCacheStats struct {
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+
+	// memorySize is the sum of the memory size of the cache entries.
+	// It's protected by the cache mutex.
+	memorySize int64
+}
+
+// JsFileServerCacheStats is the state of the cache of a file server, as returned to javascript.
+type JsFileServerCacheStats struct {
+	Entries    int     `json:"entries"`
+	MemorySize int64   `json:"memorySize"`
+	Hits       int64   `json:"hits"`
+	Misses     int64   `json:"misses"`
+	HitRatio   float64 `json:"hitRatio"`
+	Evictions  int64   `json:"evictions"`
+}
+
+func (m *jsFileServer) isExpired(entry *fileCacheEntry) bool {
+	ttl := m.options.Cache.Ttl
+	return (ttl > 0) && (time.Since(entry.loadDate) > time.Duration(ttl)*time.Millisecond)
+}
+
+// addEntry adds the entry to the cache, replacing the entry of the same uri and data,
+// then evicts other entries if the max size is exceeded.
+// An entry larger than the max size isn't added.
+func (m *jsFileServer) addEntry(entry *fileCacheEntry) {
+	maxSize := m.options.Cache.MaxSize
+
+	if (maxSize > 0) && (entry.memorySize > maxSize) {
+		return
+	}
+
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+
+	key := entry.key()
+
+	if previous := m.cache[key]; previous != nil {
+		m.stats.memorySize -= previous.memorySize
+	}
+
+	m.cache[key] = entry
+	m.stats.memorySize += entry.memorySize
+
+	if (maxSize > 0) && (m.stats.memorySize > maxSize) {
+		m.evict(entry)
+	}
+}
+
+// removeEntry removes the entry from the cache, unless it has already been replaced.
+func (m *jsFileServer) removeEntry(entry *fileCacheEntry) {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+
+	if key := entry.key(); m.cache[key] == entry {
+		delete(m.cache, key)
+		m.stats.memorySize -= entry.memorySize
+	}
+}
+
+// evict removes entries until the cache size is below his max size.
+// The expired entries are removed first, then the entries selected by the policy.
+// The cache mutex must be locked.
+func (m *jsFileServer) evict(keep *fileCacheEntry) {
+	candidates := make([]*fileCacheEntry, 0, len(m.cache))
+
+	for _, entry := range m.cache {
+		if (entry != keep) && (entry.memorySize != 0) {
+			candidates = append(candidates, entry)
+		}
+	}
+
+	isLfu := m.options.Cache.Policy == FileCachePolicyLfu
+
+	// The values are copied since they change while sorting.
+	type candidate struct {
+		entry         *fileCacheEntry
+		isExpired     bool
+		hitCount      int64
+		lastRequested int64
+	}
+
+	sorted := make([]candidate, len(candidates))
+
+	for i, entry := range candidates {
+		sorted[i] = candidate{entry, m.isExpired(entry), entry.hitCount.Load(), entry.lastRequested.Load()}
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+
+		if a.isExpired != b.isExpired {
+			return a.isExpired
+		}
+
+		if isLfu && (a.hitCount != b.hitCount) {
+			return a.hitCount < b.hitCount
+		}
+
+		return a.lastRequested < b.lastRequested
+	})
+
+	for _, c := range sorted {
+		if m.stats.memorySize <= m.options.Cache.MaxSize {
+			break
+		}
+
+		delete(m.cache, c.entry.key())
+		m.stats.memorySize -= c.entry.memorySize
+		m.stats.evictions.Add(1)
+	}
+}
+
+func (m *jsFileServer) getStats() JsFileServerCacheStats {
+	m.cacheMutex.RLock()
+	stats := JsFileServerCacheStats{Entries: len(m.cache), MemorySize: m.stats.memorySize}
+	m.cacheMutex.RUnlock()
+
+	stats.Hits = m.stats.hits.Load()
+	stats.Misses = m.stats.misses.Load()
+	stats.Evictions = m.stats.evictions.Load()
+
+	if total := stats.Hits + stats.Misses; total != 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+
+	return stats
+}
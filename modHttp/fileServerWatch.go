@@ -0,0 +1,140 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+const (
+	FileChangeCreate = "create"
+	FileChangeUpdate = "change"
+	FileChangeRemove = "remove"
+)
+
+// fileChange is a change of a file, or a directory, of the served directory.
+type fileChange struct {
+	// Event is one of FileChangeCreate, FileChangeUpdate or FileChangeRemove.
+	Event string `json:"event"`
+
+	FilePath string `json:"filePath"`
+
+	// Uri is the uri of the file, as requested by the clients.
+	Uri string `json:"uri"`
+
+	IsDir bool `json:"isDir"`
+}
+
+// fileWatcher is implemented by platform.
+type fileWatcher interface {
+	close()
+}
+
+// isFilePathAffected returns true if filePath is the changed file, one of his pre-compressed
+// versions, or is inside the changed directory.
+func isFilePathAffected(filePath string, changedPath string) bool {
+	if strings.HasPrefix(filePath, changedPath+string(filepath.Separator)) {
+		return true
+	}
+
+	if filePath == changedPath {
+		return true
+	}
+
+	// A pre-compressed version has been added or removed.
+	for _, e := range gPreCompressedExtensions {
+		if filePath+e.extension == changedPath {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isEntryAffected(entry *fileCacheEntry, changedPath string) bool {
+	if (entry.identity != nil) && isFilePathAffected(entry.identity.filePath, changedPath) {
+		return true
+	}
+
+	for _, variant := range entry.encoded {
+		if isFilePathAffected(variant.filePath, changedPath) {
+			return true
+		}
+	}
+
+	// Only the pre-compressed versions exist, the original file can have been created.
+	if entry.identity == nil {
+		for _, variant := range entry.encoded {
+			if ext := filepath.Ext(variant.filePath); isFilePathAffected(strings.TrimSuffix(variant.filePath, ext), changedPath) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// evictChangedPath removes from the cache the entries using the changed file.
+func (m *jsFileServer) evictChangedPath(changedPath string) {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+
+	for key, entry := range m.cache {
+		if isEntryAffected(entry, changedPath) {
+			delete(m.cache, key)
+			m.stats.memorySize -= entry.memorySize
+		}
+	}
+
+	for filePath, entry := range m.pageEntries {
+		if isEntryAffected(entry, changedPath) {
+			delete(m.pageEntries, filePath)
+		}
+	}
+}
+
+// onFileChanged is called by the watcher when a file, or a directory, of the served directory changes.
+func (m *jsFileServer) onFileChanged(event string, changedPath string, isDir bool) {
+	if m.isDisposed.Load() {
+		return
+	}
+
+	m.evictChangedPath(changedPath)
+
+	onChange := m.getOnChange()
+	if onChange == nil {
+		return
+	}
+
+	relPath, err := filepath.Rel(m.dirPath, changedPath)
+	if err != nil {
+		return
+	}
+
+	onChange(fileChange{
+		Event:    event,
+		FilePath: changedPath,
+		Uri:      m.requestPath + "/" + filepath.ToSlash(relPath),
+		IsDir:    isDir,
+	})
+}
+
+// isWatchedDir returns false for the directories which can't be served.
+func (m *jsFileServer) isWatchedDir(dirPath string) bool {
+	return m.options.ShowDotFiles || !strings.HasPrefix(filepath.Base(dirPath), ".") || (dirPath == m.dirPath)
+}
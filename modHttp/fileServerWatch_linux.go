@@ -0,0 +1,168 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyWatchMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// inotifyWatcher watches the served directory and all his sub-directories,
+// since inotify isn't recursive.
+type inotifyWatcher struct {
+	fileServer *jsFileServer
+
+	// file wraps the inotify descriptor, which allows closing it while reading.
+	file *os.File
+	fd   int
+
+	dirs      map[int]string
+	dirsMutex sync.Mutex
+}
+
+func (m *jsFileServer) startWatching() (fileWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	watcher := &inotifyWatcher{
+		fileServer: m,
+		file:       os.NewFile(uintptr(fd), "inotify"),
+		fd:         fd,
+		dirs:       make(map[int]string),
+	}
+
+	if err = watcher.addDirTree(m.dirPath); err != nil {
+		_ = watcher.file.Close()
+		return nil, err
+	}
+
+	go watcher.readEvents()
+
+	return watcher, nil
+}
+
+// addDirTree watches the directory and his sub-directories.
+func (m *inotifyWatcher) addDirTree(dirPath string) error {
+	return filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// The directory can have been removed since.
+			if path != dirPath {
+				return nil
+			}
+
+			return err
+		}
+
+		if !d.IsDir() {
+			return nil
+		}
+
+		if !m.fileServer.isWatchedDir(path) {
+			return filepath.SkipDir
+		}
+
+		wd, err := syscall.InotifyAddWatch(m.fd, path, inotifyWatchMask)
+		if err != nil {
+			return os.NewSyscallError("inotify_add_watch", err)
+		}
+
+		m.dirsMutex.Lock()
+		m.dirs[wd] = path
+		m.dirsMutex.Unlock()
+
+		return nil
+	})
+}
+
+func (m *inotifyWatcher) readEvents() {
+	buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+
+	for {
+		n, err := m.file.Read(buffer)
+		if err != nil {
+			// The watcher has been closed.
+			return
+		}
+
+		offset := 0
+
+		for offset+syscall.SizeofInotifyEvent <= n {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			nameBytes := buffer[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			m.onEvent(int(event.Wd), event.Mask, string(bytes.TrimRight(nameBytes, "\x00")))
+		}
+	}
+}
+
+func (m *inotifyWatcher) onEvent(wd int, mask uint32, name string) {
+	// Some events have been lost, nothing can be trusted.
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		m.fileServer.removeAll()
+		return
+	}
+
+	m.dirsMutex.Lock()
+	dirPath, ok := m.dirs[wd]
+
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(m.dirs, wd)
+	}
+
+	m.dirsMutex.Unlock()
+
+	if !ok || (name == "") {
+		return
+	}
+
+	changedPath := filepath.Join(dirPath, name)
+	isDir := mask&syscall.IN_ISDIR != 0
+
+	var event string
+
+	switch {
+	case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		event = FileChangeCreate
+
+		if isDir {
+			_ = m.addDirTree(changedPath)
+		}
+	case mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+		event = FileChangeRemove
+	case mask&(syscall.IN_CLOSE_WRITE|syscall.IN_ATTRIB) != 0:
+		event = FileChangeUpdate
+	default:
+		return
+	}
+
+	m.fileServer.onFileChanged(event, changedPath, isDir)
+}
+
+func (m *inotifyWatcher) close() {
+	_ = m.file.Close()
+}
@@ -0,0 +1,213 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileServerGetRelativePath(t *testing.T) {
+	tests := []struct {
+		name         string
+		requestPath  string
+		showDotFiles bool
+		uri          string
+		expected     string
+		ok           bool
+	}{
+		{"root", "", false, "/", "/", true},
+		{"file", "", false, "/css/site.css", "/css/site.css", true},
+		{"prefix removed", "/static", false, "/static/app.js", "/app.js", true},
+		{"parent segments", "", false, "/a/../../etc/passwd", "/etc/passwd", true},
+		{"parent above prefix", "/static", false, "/static/../../secret", "/secret", true},
+		{"encoded parent is a name", "", false, "/a/%2e%2e/b", "/a/%2e%2e/b", true},
+		{"dot file", "", false, "/.env", "", false},
+		{"dot directory", "", false, "/.git/config", "", false},
+		{"dot file inside parent", "", false, "/a/.hidden/../b", "/a/b", true},
+		{"dot file allowed", "", true, "/.well-known/file", "/.well-known/file", true},
+		{"dot in name", "", false, "/file.min.js", "/file.min.js", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &jsFileServer{requestPath: tt.requestPath, options: JsServeFilesOptions{ShowDotFiles: tt.showDotFiles}}
+
+			relPath, ok := fs.getRelativePath(tt.uri)
+
+			if (ok != tt.ok) || (relPath != tt.expected) {
+				t.Errorf("got (%q, %v), expected (%q, %v)", relPath, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
+
+func TestIsPreCompressedFileValid(t *testing.T) {
+	dir := t.TempDir()
+	original := []byte("hello world, hello world")
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	_, _ = w.Write(original)
+	_ = w.Close()
+
+	writeFile := func(name string, content []byte, modTime time.Time) (*fileVariant, os.FileInfo) {
+		filePath := filepath.Join(dir, name)
+
+		if err := os.WriteFile(filePath, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(filePath, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+
+		variant, stat, err := loadVariant(filePath, DefaultFileServerMaxCachedFileSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return variant, stat
+	}
+
+	now := time.Now()
+
+	identity, identityStat := writeFile("a.txt", original, now)
+	validGz, validGzStat := writeFile("a.txt.gz", gz.Bytes(), now)
+	olderGz, olderGzStat := writeFile("old.txt.gz", gz.Bytes(), now.Add(-time.Hour))
+	notGz, notGzStat := writeFile("bad.txt.gz", []byte("this isn't a gzip file at all"), now)
+	otherSize, otherSizeStat := writeFile("other.txt", []byte("short"), now)
+	br, brStat := writeFile("a.txt.br", []byte("any content"), now)
+	_, newerStat := writeFile("new.txt", original, now.Add(time.Hour))
+
+	tests := []struct {
+		name         string
+		variant      *fileVariant
+		stat         os.FileInfo
+		encoding     string
+		original     *fileVariant
+		originalStat os.FileInfo
+		expected     bool
+	}{
+		{"valid gzip", validGz, validGzStat, "gzip", identity, identityStat, true},
+		{"gzip without original", validGz, validGzStat, "gzip", nil, nil, true},
+		{"gzip older than original", olderGz, olderGzStat, "gzip", identity, identityStat, false},
+		{"not a gzip file", notGz, notGzStat, "gzip", identity, identityStat, false},
+		{"gzip of another size", validGz, validGzStat, "gzip", otherSize, otherSizeStat, false},
+		{"brotli isn't checked", br, brStat, "br", identity, identityStat, true},
+		{"brotli older than original", br, brStat, "br", identity, newerStat, false},
+		{"gzip too large to be checked", &fileVariant{filePath: notGz.filePath, size: notGz.size}, notGzStat, "gzip", identity, identityStat, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPreCompressedFileValid(tt.variant, tt.stat, tt.encoding, tt.original, tt.originalStat); got != tt.expected {
+				t.Errorf("got %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFileServerGetCacheControl(t *testing.T) {
+	maxAge := map[string]int{".js": 3600, ".html": -1, "*": 60}
+
+	tests := []struct {
+		name     string
+		maxAge   map[string]int
+		uri      string
+		expected string
+	}{
+		{"no max age", nil, "/app.js", ""},
+		{"by extension", maxAge, "/app.js", "public, max-age=3600"},
+		{"extension case", maxAge, "/APP.JS", "public, max-age=3600"},
+		{"negative is no-cache", maxAge, "/index.html", "no-cache"},
+		{"default", maxAge, "/logo.png", "public, max-age=60"},
+		{"no extension uses default", maxAge, "/about", "public, max-age=60"},
+		{"no default", map[string]int{".js": 10}, "/logo.png", ""},
+		{"zero", map[string]int{"*": 0}, "/logo.png", "public, max-age=0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &jsFileServer{options: JsServeFilesOptions{MaxAge: tt.maxAge}}
+
+			if got := fs.getCacheControl(tt.uri); got != tt.expected {
+				t.Errorf("got %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFileServerCacheIsKeyedByData(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "page.html")
+
+	if err := os.WriteFile(filePath, []byte("<p>page</p>"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := newJsFileServer("/", dir, JsServeFilesOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range []string{"", "en", "fr"} {
+		entry, err := fs.loadEntry("/page.html", data, filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fs.addEntry(entry)
+	}
+
+	visited := func() map[string]bool {
+		res := make(map[string]bool)
+		fs.visitCache(func(entry *fileCacheEntry) { res[entry.uri+"|"+entry.data] = true })
+		return res
+	}
+
+	if got := visited(); len(got) != 3 || !got["/page.html|en"] || !got["/page.html|fr"] || !got["/page.html|"] {
+		t.Fatalf("unexpected entries %v", got)
+	}
+
+	fs.removeUri("/page.html", "fr")
+
+	if got := visited(); len(got) != 2 || got["/page.html|fr"] {
+		t.Fatalf("only the entry with this data must be removed, got %v", got)
+	}
+
+	fs.removeUri("/page.html", "")
+
+	if got := visited(); len(got) != 1 || !got["/page.html|en"] {
+		t.Fatalf("got %v", got)
+	}
+
+	if stats := fs.getStats(); stats.MemorySize != int64(len("<p>page</p>")) {
+		t.Errorf("memory size not updated, got %d", stats.MemorySize)
+	}
+
+	// Setting the data function clears the entries loaded without it.
+	fs.setDataFunction(nil)
+
+	if got := visited(); len(got) != 0 {
+		t.Errorf("got %v", got)
+	}
+}
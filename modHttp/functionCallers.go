@@ -1,8 +1,11 @@
 package modHttp
 
 import (
+	"errors"
 	"github.com/progpjs/progpAPI/v2"
 	"github.com/progpjs/progpjs/v2"
+	"sync"
+	"time"
 )
 
 //region (SharedResource, StringBuffer)
@@ -22,6 +25,139 @@ func (*impl__CallJsFunctionWith_SharedResource_StringBuffer) Call(js progpAPI.Js
 
 //endregion
 
+//region (SharedResource, String)
+
+var gCallJsFunctionWith_SharedResource_String CallJsFunctionWith_SharedResource_String
+
+type CallJsFunctionWith_SharedResource_String interface {
+	Call(js progpAPI.JsFunction, res *progpAPI.SharedResource, value string)
+}
+
+type impl__CallJsFunctionWith_SharedResource_String struct {
+}
+
+func (*impl__CallJsFunctionWith_SharedResource_String) Call(js progpAPI.JsFunction, res *progpAPI.SharedResource, value string) {
+	js.DynamicFunctionCaller(res, value)
+}
+
+//endregion
+
+//region (SharedResource, ArrayBuffer)
+
+var gCallJsFunctionWith_SharedResource_ArrayBuffer CallJsFunctionWith_SharedResource_ArrayBuffer
+
+type CallJsFunctionWith_SharedResource_ArrayBuffer interface {
+	Call(js progpAPI.JsFunction, res *progpAPI.SharedResource, value []byte)
+}
+
+type impl__CallJsFunctionWith_SharedResource_ArrayBuffer struct {
+}
+
+func (*impl__CallJsFunctionWith_SharedResource_ArrayBuffer) Call(js progpAPI.JsFunction, res *progpAPI.SharedResource, value []byte) {
+	js.DynamicFunctionCaller(res, value)
+}
+
+//endregion
+
+//region Waiting for a javascript result
+
+// DefaultJsCallTimeout is the time a javascript function called from Go has to return his result.
+const DefaultJsCallTimeout = 5 * time.Second
+
+var JsCallTimedOutError = errors.New("the javascript function hasn't returned in time")
+
+// jsCallResult allows waiting for the result of a javascript function called from Go.
+// The function receives it as a SharedResource and must call progpReturnVoid,
+// progpReturnString or progpReturnError with this resource once done.
+//
+// It works like the locks created by SharedResourceContainer.CreateLock,
+// but allows javascript to return a value and the caller to stop waiting.
+type jsCallResult struct {
+	res  *progpAPI.SharedResource
+	done chan bool
+
+	mutex       sync.Mutex
+	isDone      bool
+	isAbandoned bool
+	value       string
+	err         error
+}
+
+// createJsCallResult returns the result and the resource to give to the javascript function.
+func createJsCallResult(rc *progpAPI.SharedResourceContainer) (*jsCallResult, *progpAPI.SharedResource) {
+	result := &jsCallResult{done: make(chan bool)}
+	result.res = rc.NewSharedResource(result, nil)
+	return result, result.res
+}
+
+func (m *jsCallResult) setResult(value string, err error) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.isDone {
+		return nil
+	}
+
+	m.isDone = true
+	m.value = value
+	m.err = err
+	close(m.done)
+
+	// The caller doesn't wait anymore, javascript was the last one using the resource.
+	if m.isAbandoned {
+		m.res.Dispose()
+	}
+
+	return nil
+}
+
+// waitFor waits for the result, then disposes the resource.
+// If the timeout occurs first, then the resource is disposed once javascript returns.
+func (m *jsCallResult) waitFor(timeout time.Duration) (string, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-m.done:
+	case <-timer.C:
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.isDone {
+		m.isAbandoned = true
+		return "", JsCallTimedOutError
+	}
+
+	m.res.Dispose()
+	return m.value, m.err
+}
+
+func (m *jsCallResult) OnReturnVoidAction() error {
+	return m.setResult("", nil)
+}
+
+func (m *jsCallResult) OnReturnStringAction(value string) error {
+	return m.setResult(value, nil)
+}
+
+func (m *jsCallResult) OnReturnErrorAction(err string) error {
+	return m.setResult("", errors.New(err))
+}
+
+// callJsFunctionAndWait calls a javascript function with a json value and waits for his result.
+// Returns JsCallTimedOutError if the result isn't returned before the timeout.
+func callJsFunctionAndWait(rc *progpAPI.SharedResourceContainer, js progpAPI.JsFunction, jsonValue []byte, timeout time.Duration) (string, error) {
+	result, res := createJsCallResult(rc)
+	gCallJsFunctionWith_SharedResource_StringBuffer.Call(js, res, jsonValue)
+	return result.waitFor(timeout)
+}
+
+//endregion
+
 func registerJsFunctionCallers() {
 	gCallJsFunctionWith_SharedResource_StringBuffer = progpjs.GetFunctionCaller(&impl__CallJsFunctionWith_SharedResource_StringBuffer{}).(CallJsFunctionWith_SharedResource_StringBuffer)
+	gCallJsFunctionWith_SharedResource_String = progpjs.GetFunctionCaller(&impl__CallJsFunctionWith_SharedResource_String{}).(CallJsFunctionWith_SharedResource_String)
+	gCallJsFunctionWith_SharedResource_ArrayBuffer = progpjs.GetFunctionCaller(&impl__CallJsFunctionWith_SharedResource_ArrayBuffer{}).(CallJsFunctionWith_SharedResource_ArrayBuffer)
 }
@@ -0,0 +1,136 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/progpjs/httpServer/v2"
+	"github.com/progpjs/progpAPI/v2"
+	"time"
+)
+
+// DefaultHandlerTimeoutStatus is the status code sent when a handler doesn't respond in time.
+const DefaultHandlerTimeoutStatus = 504
+
+var ResponseTimedOutError = errors.New("the handler has timed out")
+
+// JsRouteOptions are the options of a route bound to a javascript handler.
+type JsRouteOptions struct {
+	// Timeout is the time, in milliseconds, the handler has to respond.
+	// If 0, then the timeout of the server is used. If negative, then there is no timeout.
+	Timeout int `json:"timeout"`
+
+	// TimeoutStatus is the status code sent on timeout, 503 or 504. Default is the one of the server.
+	TimeoutStatus int `json:"timeoutStatus"`
+}
+
+// jsHandlerTimeoutEvent is sent to javascript when a handler times out.
+type jsHandlerTimeoutEvent struct {
+	Hostname string `json:"hostname"`
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+
+	// Route is the path the handler is bound to.
+	Route string `json:"route"`
+
+	// Timeout is the time, in milliseconds, the handler had to respond.
+	Timeout int `json:"timeout"`
+}
+
+// getHandlerTimeout returns the timeout of the route, and the status code to send.
+// Returns a zero duration if there is no timeout.
+func (m *jsServerSettings) getHandlerTimeout(options JsRouteOptions) (time.Duration, int) {
+	m.mutex.Lock()
+	timeout := m.handlerTimeout
+	statusCode := m.handlerTimeoutStatus
+	m.mutex.Unlock()
+
+	if options.Timeout != 0 {
+		timeout = options.Timeout
+	}
+
+	if options.TimeoutStatus != 0 {
+		statusCode = options.TimeoutStatus
+	}
+
+	if statusCode == 0 {
+		statusCode = DefaultHandlerTimeoutStatus
+	}
+
+	if timeout <= 0 {
+		return 0, statusCode
+	}
+
+	return time.Duration(timeout) * time.Millisecond, statusCode
+}
+
+// onHandlerTimeout tells javascript which route has timed out.
+func (m *jsServerSettings) onHandlerTimeout(call httpServer.HttpRequest, route string, timeout time.Duration) {
+	m.mutex.Lock()
+	listener := m.onTimeout
+	m.mutex.Unlock()
+
+	if listener == nil {
+		return
+	}
+
+	listener(jsHandlerTimeoutEvent{
+		Hostname: call.GetHost().GetHostName(),
+		Method:   call.GetMethodName(),
+		Path:     call.Path(),
+		Route:    route,
+		Timeout:  int(timeout.Milliseconds()),
+	})
+}
+
+// waitHandler waits until a response is sent, the handler ends, or the timeout if not zero.
+// Returns false if the timeout occurs first.
+func (m *jsHttpRequest) waitHandler(timeout time.Duration) bool {
+	var timerC <-chan time.Time
+
+	if timeout != 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timerC = timer.C
+	}
+
+	select {
+	case <-m.responseSent:
+		return true
+	case <-m.handlerDone:
+		return true
+	case <-timerC:
+		return false
+	}
+}
+
+// JsServerOnHandlerTimeout set the function called when a handler of this server doesn't respond in time.
+func JsServerOnHandlerTimeout(serverPort int, callback progpAPI.JsFunction) {
+	callback.KeepAlive()
+
+	settings := getServerSettings(serverPort)
+
+	settings.mutex.Lock()
+	defer settings.mutex.Unlock()
+
+	settings.onTimeout = func(event jsHandlerTimeoutEvent) {
+		if b, err := json.Marshal(event); err == nil {
+			callback.CallWithStringBuffer2(b)
+		}
+	}
+}
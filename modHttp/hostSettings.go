@@ -0,0 +1,132 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"github.com/progpjs/httpServer/v2"
+	"sync"
+	"sync/atomic"
+)
+
+// jsHostSettings contains the settings which are bound to a host by the javascript side.
+type jsHostSettings struct {
+	// maxRequestBodySize is the max size of a request body, in bytes.
+	// A value less or equal to zero means no limit, which is the default.
+	// It's set from the javascript thread while the requests read it.
+	maxRequestBodySize atomic.Int64
+
+	// compression tells how the responses of the javascript handlers are compressed.
+	// It's replaced from the javascript thread while the requests read it.
+	compression atomic.Pointer[JsCompressionOptions]
+
+	// server contains the settings shared by all the hosts of the server.
+	server *jsServerSettings
+
+	// rateLimiters are all applied to the paths starting with their prefix.
+	rateLimiters      []*jsRateLimiter
+	rateLimitersMutex sync.RWMutex
+
+	// corsPolicies are sorted from the longest path prefix to the shortest.
+	corsPolicies []*jsCorsPolicy
+	corsMutex    sync.RWMutex
+
+	// onError renders the response when a handler of this host fails.
+	onError        jsErrorHook
+	errorHookMutex sync.RWMutex
+
+	// routes contains the handlers bound to this host, by path then by verb.
+	routes      map[string]map[string]httpServer.HttpMiddleware
+	routesMutex sync.RWMutex
+
+	// patternRoutes contains, by resolver path then by verb, the routes with params bound to this host.
+	patternRoutes map[string]map[string]string
+
+	// wildcardRoutes contains, by verb, the routes of the routes map which contain wildcards.
+	wildcardRoutes map[string]*httpServer.UrlResolver
+}
+
+var gHostSettings = make(map[*httpServer.HttpHost]*jsHostSettings)
+var gHostSettingsMutex sync.Mutex
+
+// getHostSettings returns the settings for this host, creating them if needed.
+func getHostSettings(host *httpServer.HttpHost) *jsHostSettings {
+	gHostSettingsMutex.Lock()
+	defer gHostSettingsMutex.Unlock()
+
+	settings := gHostSettings[host]
+
+	if settings == nil {
+		settings = &jsHostSettings{
+			routes:         make(map[string]map[string]httpServer.HttpMiddleware),
+			wildcardRoutes: make(map[string]*httpServer.UrlResolver),
+			patternRoutes:  make(map[string]map[string]string),
+		}
+
+		if server := host.GetServer(); server != nil {
+			settings.server = getServerSettings(server.GetPort())
+		} else {
+			settings.server = &jsServerSettings{activeRequestsByIp: make(map[string]int)}
+		}
+
+		settings.compression.Store(JsCompressionOptions{}.withDefaults())
+		gHostSettings[host] = settings
+	}
+
+	return settings
+}
+
+// jsServerSettings contains the settings which are bound to a server by the javascript side.
+type jsServerSettings struct {
+	// maxRequestsPerIp is the max number of requests processed at the same time for a client IP.
+	// A value less or equal to zero means no limit.
+	maxRequestsPerIp int
+
+	// handlerTimeout is the default time, in milliseconds, a javascript handler has to respond.
+	// A value less or equal to zero means no limit.
+	handlerTimeout       int
+	handlerTimeoutStatus int
+
+	// onTimeout is called when a handler doesn't respond in time.
+	onTimeout func(event jsHandlerTimeoutEvent)
+
+	// onError renders the response when a handler fails and the error hook of his host doesn't.
+	onError jsErrorHook
+
+	// hideErrors avoids the default error response showing the details of the error.
+	hideErrors bool
+
+	activeRequestsByIp map[string]int
+	mutex              sync.Mutex
+}
+
+var gServerSettings = make(map[int]*jsServerSettings)
+var gServerSettingsMutex sync.Mutex
+
+// getServerSettings returns the settings for the server listening to this port, creating them if needed.
+func getServerSettings(serverPort int) *jsServerSettings {
+	gServerSettingsMutex.Lock()
+	defer gServerSettingsMutex.Unlock()
+
+	settings := gServerSettings[serverPort]
+
+	if settings == nil {
+		settings = &jsServerSettings{activeRequestsByIp: make(map[string]int)}
+		gServerSettings[serverPort] = settings
+	}
+
+	return settings
+}
@@ -0,0 +1,364 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/progpjs/progpAPI/v2"
+	"github.com/valyala/fasthttp"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/cookiejar"
+	"net/textproto"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultHttpClientRetryDelay is the time, in milliseconds, before the first retry.
+	DefaultHttpClientRetryDelay = 100
+
+	// DefaultHttpClientMaxRetryDelay is the max time, in milliseconds, between two retries.
+	DefaultHttpClientMaxRetryDelay = 10000
+
+	// DefaultHttpClientRetryMultiplier is the factor applied to the delay after each retry.
+	DefaultHttpClientRetryMultiplier = 2
+
+	// DefaultHttpClientMaxConnWaitTimeout is the time, in milliseconds, a request waits
+	// for a free connection when the max connections count is reached.
+	DefaultHttpClientMaxConnWaitTimeout = 30000
+)
+
+// DefaultHttpClientRetryOnStatus are the status codes for which a request is retried.
+var DefaultHttpClientRetryOnStatus = []int{502, 503, 504}
+
+type JsHttpClientOptions struct {
+	// BaseUrl is prepended to the urls which aren't absolute.
+	BaseUrl string `json:"baseUrl"`
+
+	// Headers are sent with each request, unless the request sets them.
+	Headers   map[string]string `json:"headers"`
+	UserAgent string            `json:"userAgent"`
+
+	// DisableCookieJar avoids keeping the cookies set by the servers.
+	DisableCookieJar bool `json:"disableCookieJar"`
+
+	// MaxConnsPerHost is the max number of connections opened to a host, unlimited if 0.
+	MaxConnsPerHost int `json:"maxConnsPerHost"`
+
+	// MaxConnWaitTimeout is the time, in milliseconds, a request waits for a free connection.
+	MaxConnWaitTimeout int `json:"maxConnWaitTimeout"`
+
+	// DisableKeepAlive closes the connection after each request.
+	DisableKeepAlive bool `json:"disableKeepAlive"`
+
+	// MaxIdleConnDuration is the time, in milliseconds, an unused connection is kept open.
+	MaxIdleConnDuration int `json:"maxIdleConnDuration"`
+
+	// MaxConnDuration is the time, in milliseconds, after which a connection is closed, even if used.
+	MaxConnDuration int `json:"maxConnDuration"`
+
+	// Timeout is the default timeout, in milliseconds, of the requests.
+	Timeout int `json:"timeout"`
+
+	Retry JsHttpClientRetry `json:"retry"`
+	Tls   JsFetchTlsOptions `json:"tls"`
+}
+
+// JsHttpClientRetry is the retry policy of a client.
+// Only the idempotent methods are retried, after a network error or a status code
+// listed in RetryOnStatus. The delay between two retries grows exponentially.
+type JsHttpClientRetry struct {
+	// MaxRetries is the max number of times a request is sent again, never if 0.
+	MaxRetries int `json:"maxRetries"`
+
+	// InitialDelay is the time, in milliseconds, before the first retry.
+	InitialDelay int `json:"initialDelay"`
+
+	// MaxDelay is the max time, in milliseconds, between two retries.
+	MaxDelay int `json:"maxDelay"`
+
+	Multiplier    float64 `json:"multiplier"`
+	RetryOnStatus []int   `json:"retryOnStatus"`
+}
+
+// jsHttpClient is the value of the SharedResource returned to javascript for a client.
+type jsHttpClient struct {
+	*fetchClient
+	options JsHttpClientOptions
+}
+
+func getJsHttpClient(resClient *progpAPI.SharedResource) (*jsHttpClient, error) {
+	client, ok := resClient.Value.(*jsHttpClient)
+	if !ok {
+		return nil, errors.New("invalid resource")
+	}
+
+	return client, nil
+}
+
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+
+	return false
+}
+
+// getRetryDelay returns the time to wait before sending the request again,
+// or false if the request must not be retried.
+func (m JsHttpClientRetry) getRetryDelay(req *fasthttp.Request, resp *fasthttp.Response, err error, attempt int) (time.Duration, bool) {
+	if (attempt >= m.MaxRetries) || !isIdempotentMethod(string(req.Header.Method())) {
+		return 0, false
+	}
+
+	if err == nil {
+		retryOnStatus := m.RetryOnStatus
+		if retryOnStatus == nil {
+			retryOnStatus = DefaultHttpClientRetryOnStatus
+		}
+
+		if !slices.Contains(retryOnStatus, resp.StatusCode()) {
+			return 0, false
+		}
+	}
+
+	initialDelay := m.InitialDelay
+	if initialDelay <= 0 {
+		initialDelay = DefaultHttpClientRetryDelay
+	}
+
+	maxDelay := m.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = DefaultHttpClientMaxRetryDelay
+	}
+
+	multiplier := m.Multiplier
+	if multiplier < 1 {
+		multiplier = DefaultHttpClientRetryMultiplier
+	}
+
+	// The server can say when to retry, which is then used as is.
+	if err == nil {
+		if seconds, e := strconv.Atoi(string(resp.Header.Peek("Retry-After"))); (e == nil) && (seconds >= 0) {
+			return time.Duration(min(seconds*1000, maxDelay)) * time.Millisecond, true
+		}
+	}
+
+	delay := math.Min(float64(initialDelay)*math.Pow(multiplier, float64(attempt)), float64(maxDelay))
+
+	// The jitter avoids all the clients retrying at the same time.
+	delay = delay/2 + rand.Float64()*delay/2
+
+	return time.Duration(delay) * time.Millisecond, true
+}
+
+func getRequestUrl(req *fasthttp.Request) (*url.URL, error) {
+	return url.Parse(req.URI().String())
+}
+
+// addJarCookies adds the cookies of the jar matching the request url.
+// The cookies already set by the request are kept.
+func addJarCookies(jar http.CookieJar, req *fasthttp.Request) {
+	u, err := getRequestUrl(req)
+	if err != nil {
+		return
+	}
+
+	for _, c := range jar.Cookies(u) {
+		if len(req.Header.Cookie(c.Name)) == 0 {
+			req.Header.SetCookie(c.Name, c.Value)
+		}
+	}
+}
+
+// storeJarCookies adds to the jar the cookies set by the response.
+func storeJarCookies(jar http.CookieJar, req *fasthttp.Request, resp *fasthttp.Response) {
+	var lines []string
+
+	resp.Header.VisitAllCookie(func(key, value []byte) {
+		lines = append(lines, string(value))
+	})
+
+	if lines == nil {
+		return
+	}
+
+	u, err := getRequestUrl(req)
+	if err != nil {
+		return
+	}
+
+	// net/http parses the cookies of his responses with the rules used by his jar.
+	httpResponse := http.Response{Header: http.Header{"Set-Cookie": lines}}
+	jar.SetCookies(u, httpResponse.Cookies())
+}
+
+// resolveUrl prepends the base url of the client if the url isn't absolute.
+func (m *jsHttpClient) resolveUrl(fetchUrl string) string {
+	if (m.options.BaseUrl == "") || strings.Contains(fetchUrl, "://") {
+		return fetchUrl
+	}
+
+	if fetchUrl == "" {
+		return m.options.BaseUrl
+	}
+
+	return strings.TrimSuffix(m.options.BaseUrl, "/") + "/" + strings.TrimPrefix(fetchUrl, "/")
+}
+
+// applyDefaults adds the default values of the client to the request options.
+func (m *jsHttpClient) applyDefaults(options JsFetchOptions) JsFetchOptions {
+	headers := make(map[string]string)
+
+	for key, value := range m.options.Headers {
+		headers[textproto.CanonicalMIMEHeaderKey(key)] = value
+	}
+
+	for key, value := range options.SendHeaders {
+		headers[textproto.CanonicalMIMEHeaderKey(key)] = value
+	}
+
+	if m.options.DisableKeepAlive {
+		headers["Connection"] = "close"
+	}
+
+	options.SendHeaders = headers
+
+	if options.UserAgent == "" {
+		options.UserAgent = m.options.UserAgent
+	}
+
+	if options.Timeout == 0 {
+		options.Timeout = m.options.Timeout
+	}
+
+	return options
+}
+
+// JsHttpClientCreate creates a client, whose connections are reused by all his requests.
+func JsHttpClientCreate(rc *progpAPI.SharedResourceContainer, options JsHttpClientOptions) (*progpAPI.SharedResource, error) {
+	if options.BaseUrl != "" {
+		baseUrl, err := url.Parse(options.BaseUrl)
+		if err != nil {
+			return nil, err
+		}
+
+		if !baseUrl.IsAbs() {
+			return nil, errors.New("the base url must be absolute")
+		}
+	}
+
+	tlsConfig, err := buildFetchTlsConfig(options.Tls)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &fasthttp.Client{
+		TLSConfig:              tlsConfig,
+		ReadBufferSize:         16 * 1024,
+		DisablePathNormalizing: true,
+		MaxConnsPerHost:        options.MaxConnsPerHost,
+		MaxIdleConnDuration:    time.Duration(options.MaxIdleConnDuration) * time.Millisecond,
+		MaxConnDuration:        time.Duration(options.MaxConnDuration) * time.Millisecond,
+	}
+
+	// Without it, fasthttp fails at once when all the connections are used.
+	if options.MaxConnsPerHost > 0 {
+		if options.MaxConnWaitTimeout <= 0 {
+			options.MaxConnWaitTimeout = DefaultHttpClientMaxConnWaitTimeout
+		}
+
+		client.MaxConnWaitTimeout = time.Duration(options.MaxConnWaitTimeout) * time.Millisecond
+	}
+
+	jsClient := &jsHttpClient{
+		fetchClient: &fetchClient{client: client, retry: options.Retry},
+		options:     options,
+	}
+
+	if !options.DisableCookieJar {
+		jsClient.jar, err = cookiejar.New(nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rc.NewSharedResource(jsClient, func(value any) {
+		value.(*jsHttpClient).client.CloseIdleConnections()
+	}), nil
+}
+
+// JsHttpClientFetchAsync is like JsFetchAsync, but uses the client and his defaults.
+// The body is sent only if not empty.
+func JsHttpClientFetchAsync(resClient *progpAPI.SharedResource, url string, options JsFetchOptions, body []byte, callback progpAPI.JsFunction) {
+	client, err := getJsHttpClient(resClient)
+	if err != nil {
+		callback.CallWithError(err)
+		return
+	}
+
+	if len(body) == 0 {
+		body = nil
+	} else {
+		// The buffer memory is owned by javascript, it must be copied.
+		body = bytes.Clone(body)
+	}
+
+	fetchAsync(client.fetchClient, client.resolveUrl(url), client.applyDefaults(options), body, callback)
+}
+
+// JsHttpClientFetchResponseAsync is like JsFetchResponseAsync, but uses the client and his defaults.
+func JsHttpClientFetchResponseAsync(rc *progpAPI.SharedResourceContainer, resClient *progpAPI.SharedResource, url string, options JsFetchOptions, body []byte, callback progpAPI.JsFunction) {
+	client, err := getJsHttpClient(resClient)
+	if err != nil {
+		callback.CallWithError(err)
+		return
+	}
+
+	fetchResponseAsync(rc, client.fetchClient, client.resolveUrl(url), client.applyDefaults(options), body, nil, callback)
+}
+
+// JsHttpClientCookies returns, as json, the name and value of the cookies the client sends to this url.
+func JsHttpClientCookies(resClient *progpAPI.SharedResource, cookiesUrl string) (progpAPI.StringBuffer, error) {
+	client, err := getJsHttpClient(resClient)
+	if err != nil {
+		return nil, err
+	}
+
+	cookies := make(map[string]string)
+
+	if client.jar != nil {
+		u, err := url.Parse(client.resolveUrl(cookiesUrl))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range client.jar.Cookies(u) {
+			cookies[c.Name] = c.Value
+		}
+	}
+
+	return json.Marshal(cookies)
+}
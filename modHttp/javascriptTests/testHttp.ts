@@ -16,4 +16,14 @@ async function handler(req: HttpRequest): Promise<void> {
 
 host.GET("/", handler);
 
+// Allows testing the range and conditional requests, when launched from this directory:
+//      curl -i -H "Range: bytes=0-9" http://localhost:8000/file                                 -> 206, the first 10 bytes
+//      curl -i -H "Range: bytes=20-29,0-9,5-14" http://localhost:8000/file                      -> 206, multipart with 0-14 and 20-29
+//      curl -i -H "Range: bytes=0-9" -H 'If-Range: "old"' http://localhost:8000/file            -> 200, the whole file
+//      curl -i -H "Range: bytes=999999-" http://localhost:8000/file                             -> 416
+//      curl -i -H 'If-None-Match: <the ETag of a previous response>' http://localhost:8000/file -> 304
+host.GET("/file", async req => {
+    req.sendFile("testHttp.ts");
+});
+
 server.start();
\ No newline at end of file
@@ -0,0 +1,208 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"errors"
+	"github.com/progpjs/httpServer/v2"
+	"github.com/progpjs/progpAPI/v2"
+	"github.com/valyala/fasthttp"
+	"sync"
+	"sync/atomic"
+)
+
+var UnsupportedRequestError = errors.New("not supported by this http server implementation")
+var ResponseAlreadySentError = errors.New("response already sent")
+
+// fastHttpRequestProvider is implemented by the requests coming from libFastHttpImpl.
+// It gives access to the underlying fasthttp context, which is required for
+// the features not exposed by httpServer.HttpRequest.
+type fastHttpRequestProvider interface {
+	GetFastHttpRequestCtx() *fasthttp.RequestCtx
+}
+
+// getFastHttpCtx returns the fasthttp context of a request.
+func getFastHttpCtx(call httpServer.HttpRequest) (*fasthttp.RequestCtx, error) {
+	if req, ok := call.(*jsHttpRequest); ok {
+		call = req.HttpRequest
+	}
+
+	provider, ok := call.(fastHttpRequestProvider)
+	if !ok {
+		return nil, UnsupportedRequestError
+	}
+
+	return provider.GetFastHttpRequestCtx(), nil
+}
+
+// jsHttpRequest is the value of the SharedResource given to the javascript handlers.
+// It wraps the original request, which allows knowing when a response has been sent,
+// whatever the way it has been sent, and keeping the resource alive while a response is streamed.
+type jsHttpRequest struct {
+	httpServer.HttpRequest
+
+	res          *progpAPI.SharedResource
+	responseSent chan bool
+	sentOnce     sync.Once
+	refCount     atomic.Int32
+
+	stream *jsResponseStream
+
+	// responseMutex avoids a response being written by the handler while the error response is written.
+	// Once the error response is written, responseError is returned to the handler.
+	responseMutex sync.Mutex
+	responseError error
+
+	// handlerDone is closed once the javascript handler has ended, with handlerError set if it has thrown.
+	handlerDone     chan bool
+	handlerDoneOnce sync.Once
+	handlerError    *jsHandlerError
+
+	// params are the values of the named params of the route.
+	params map[string]string
+}
+
+func newJsHttpRequest(rc *progpAPI.SharedResourceContainer, call httpServer.HttpRequest) *jsHttpRequest {
+	m := &jsHttpRequest{HttpRequest: call, responseSent: make(chan bool), handlerDone: make(chan bool)}
+	m.refCount.Store(1)
+	m.res = rc.NewSharedResource(m, nil)
+	return m
+}
+
+func getJsHttpRequest(resHttpRequest *progpAPI.SharedResource) (*jsHttpRequest, error) {
+	req, ok := resHttpRequest.Value.(*jsHttpRequest)
+	if !ok {
+		return nil, errors.New("invalid resource")
+	}
+
+	return req, nil
+}
+
+// acquire avoids disposing the resource while the response isn't fully sent.
+func (m *jsHttpRequest) acquire() {
+	m.refCount.Add(1)
+}
+
+// release disposes the resource once the handler and the streams are done with it.
+func (m *jsHttpRequest) release() {
+	if m.refCount.Add(-1) == 0 {
+		m.res.Dispose()
+	}
+}
+
+func (m *jsHttpRequest) markResponseSent() {
+	m.sentOnce.Do(func() {
+		close(m.responseSent)
+	})
+}
+
+func (m *jsHttpRequest) IsBodySend() bool {
+	select {
+	case <-m.responseSent:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *jsHttpRequest) WaitResponse() {
+	<-m.responseSent
+}
+
+// sendResponse calls f to write the response, then marks the response as sent.
+// Once an error response has been sent in place of the handler, f isn't called anymore since the request can be reused.
+func (m *jsHttpRequest) sendResponse(f func() error) error {
+	m.responseMutex.Lock()
+	defer m.responseMutex.Unlock()
+
+	if m.responseError != nil {
+		return m.responseError
+	}
+
+	if err := f(); err != nil {
+		return err
+	}
+
+	m.markResponseSent()
+	return nil
+}
+
+// modifyResponse calls f to change the response without sending it, for example to set a header.
+// Like with sendResponse, f isn't called once an error response has been sent in place of the handler.
+func (m *jsHttpRequest) modifyResponse(f func() error) error {
+	m.responseMutex.Lock()
+	defer m.responseMutex.Unlock()
+
+	if m.responseError != nil {
+		return m.responseError
+	}
+
+	return f()
+}
+
+func (m *jsHttpRequest) SetHeader(key, value string) {
+	_ = m.modifyResponse(func() error {
+		m.HttpRequest.SetHeader(key, value)
+		return nil
+	})
+}
+
+func (m *jsHttpRequest) SetContentType(contentType string) {
+	_ = m.modifyResponse(func() error {
+		m.HttpRequest.SetContentType(contentType)
+		return nil
+	})
+}
+
+func (m *jsHttpRequest) SetCookie(key string, value string, options httpServer.HttpCookieOptions) error {
+	return m.modifyResponse(func() error {
+		return m.HttpRequest.SetCookie(key, value, options)
+	})
+}
+
+func (m *jsHttpRequest) ReturnString(status int, text string) {
+	_ = m.sendResponse(func() error {
+		m.HttpRequest.ReturnString(status, text)
+		return nil
+	})
+}
+
+func (m *jsHttpRequest) SendFile(filePath string) error {
+	return m.sendResponse(func() error {
+		return sendFile(m.HttpRequest, filePath, "", "")
+	})
+}
+
+func (m *jsHttpRequest) SendFileAsIs(filePath string, mimeType string, contentEncoding string) error {
+	return m.sendResponse(func() error {
+		return sendFile(m.HttpRequest, filePath, mimeType, contentEncoding)
+	})
+}
+
+func (m *jsHttpRequest) Return500ErrorPage(err error) {
+	_ = m.sendResponse(func() error {
+		m.HttpRequest.Return500ErrorPage(err)
+		return nil
+	})
+}
+
+func (m *jsHttpRequest) Return404UnknownPage() {
+	_ = m.sendResponse(func() error {
+		m.HttpRequest.Return404UnknownPage()
+		return nil
+	})
+}
@@ -0,0 +1,64 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"errors"
+	"github.com/progpjs/httpServer/v2"
+	"testing"
+)
+
+func TestResponseTakenByErrorHandler(t *testing.T) {
+	// The wrapped request is nil: any call reaching it would panic.
+	req := &jsHttpRequest{responseSent: make(chan bool)}
+
+	if !req.takeResponse(ResponseTimedOutError) {
+		t.Fatal("the response must be taken")
+	}
+
+	req.SetHeader("X-Test", "value")
+	req.SetContentType("text/plain")
+	req.ReturnString(200, "hello")
+
+	if err := req.SetCookie("name", "value", httpServer.HttpCookieOptions{}); !errors.Is(err, ResponseTimedOutError) {
+		t.Fatalf("expected ResponseTimedOutError, got %v", err)
+	}
+
+	if err := req.modifyResponse(func() error { return nil }); !errors.Is(err, ResponseTimedOutError) {
+		t.Fatalf("expected ResponseTimedOutError, got %v", err)
+	}
+
+	if req.IsBodySend() {
+		t.Fatal("no response must be sent by the handler")
+	}
+}
+
+func TestResponseNotTakenOnceSent(t *testing.T) {
+	req := &jsHttpRequest{responseSent: make(chan bool)}
+
+	if err := req.sendResponse(func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	if req.takeResponse(NoResponseSendError) {
+		t.Fatal("a sent response can't be taken")
+	}
+
+	if err := req.modifyResponse(func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+}
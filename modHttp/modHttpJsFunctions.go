@@ -47,11 +47,22 @@ func registerExportedFunctions() {
 
 	group.AddFunction("startServer", "JsStartServer", JsStartServer)
 	group.AddFunction("configureServer", "JsConfigureServer", JsConfigureServer)
+	group.AddFunction("serverOnHandlerTimeout", "JsServerOnHandlerTimeout", JsServerOnHandlerTimeout)
+	group.AddFunction("serverOnError", "JsServerOnError", JsServerOnError)
 	group.AddFunction("getHost", "JsGetHost", JsGetHost)
+	group.AddFunction("hostSetMaxRequestBodySize", "JsHostSetMaxRequestBodySize", JsHostSetMaxRequestBodySize)
+	group.AddFunction("hostSetCompression", "JsHostSetCompression", JsHostSetCompression)
+	group.AddFunction("hostSetCors", "JsHostSetCors", JsHostSetCors)
+	group.AddFunction("hostOnError", "JsHostOnError", JsHostOnError)
+	group.AddFunction("hostAddRateLimit", "JsHostAddRateLimit", JsHostAddRateLimit)
+	group.AddFunction("hostAddRateLimitWithKey", "JsHostAddRateLimitWithKey", JsHostAddRateLimitWithKey)
 
 	group.AddFunction("VERB_withFunction", "JsVerbWithFunction", JsVerbWithFunction)
+	group.AddFunction("ALL_withFunction", "JsAllVerbsWithFunction", JsAllVerbsWithFunction)
+	group.AddFunction("requestHandlerDone", "JsRequestHandlerDone", JsRequestHandlerDone)
 
 	group.AddFunction("returnString", "JsReturnString", JsReturnString)
+	group.AddFunction("returnBytes", "JsReturnBytes", JsReturnBytes)
 	group.AddFunction("requestURI", "JsRequestURI", JsRequestURI)
 
 	group.AddFunction("requestPath", "JsRequestPath", JsRequestPath)
@@ -60,10 +71,13 @@ func registerExportedFunctions() {
 	group.AddFunction("requestHost", "JsRequestHost", JsRequestHost)
 	group.AddFunction("requestQueryArgs", "JsRequestQueryArgs", JsRequestQueryArgs)
 	group.AddFunction("requestPostArgs", "JsRequestPostArgs", JsRequestPostArgs)
+	group.AddFunction("requestBodyAsString", "JsRequestBodyAsString", JsRequestBodyAsString)
+	group.AddFunction("requestBodyAsArrayBuffer", "JsRequestBodyAsArrayBuffer", JsRequestBodyAsArrayBuffer)
 	group.AddAsyncFunction("requestReadFormFile", "JsRequestReadFormFileAsync", JsRequestReadFormFileAsync)
 	group.AddAsyncFunction("requestSaveFormFile", "JsRequestSaveFormFileAsync", JsRequestSaveFormFileAsync)
 
 	group.AddFunction("requestWildcards", "JsRequestWildcards", JsRequestWildcards)
+	group.AddFunction("requestParams", "JsRequestParams", JsRequestParams)
 
 	group.AddFunction("requestCookies", "JsRequestCookies", JsRequestCookies)
 	group.AddFunction("requestCookie", "JsRequestCookie", JsRequestCookie)
@@ -71,13 +85,43 @@ func registerExportedFunctions() {
 	group.AddFunction("responseSetHeader", "JsRequestSetHeader", JsRequestSetHeader)
 	group.AddFunction("responseSetCookie", "JsRequestSetCookie", JsRequestSetCookie)
 
+	group.AddFunction("responseStreamBegin", "JsResponseStreamBegin", JsResponseStreamBegin)
+	group.AddFunction("responseStreamWriteString", "JsResponseStreamWriteString", JsResponseStreamWriteString)
+	group.AddFunction("responseStreamWriteBytes", "JsResponseStreamWriteBytes", JsResponseStreamWriteBytes)
+	group.AddAsyncFunction("responseStreamWaitDrain", "JsResponseStreamWaitDrainAsync", JsResponseStreamWaitDrainAsync)
+	group.AddFunction("responseStreamEnd", "JsResponseStreamEnd", JsResponseStreamEnd)
+	group.AddFunction("responseSseBegin", "JsResponseSseBegin", JsResponseSseBegin)
+	group.AddFunction("responseSseSend", "JsResponseSseSend", JsResponseSseSend)
+	group.AddFunction("requestLastEventId", "JsRequestLastEventId", JsRequestLastEventId)
+
+	group.AddFunction("websocket_withFunction", "JsWebSocketWithFunction", JsWebSocketWithFunction)
+	group.AddFunction("websocketSendString", "JsWebSocketSendString", JsWebSocketSendString)
+	group.AddFunction("websocketSendBytes", "JsWebSocketSendBytes", JsWebSocketSendBytes)
+	group.AddFunction("websocketClose", "JsWebSocketClose", JsWebSocketClose)
+	group.AddFunction("websocketAttach", "JsWebSocketAttach", JsWebSocketAttach)
+
 	group.AddFunction("sendFileAsIs", "JsSendFileAsIs", JsSendFileAsIs)
 	group.AddFunction("sendFile", "JsSendFile", JsSendFile)
 	group.AddFunction("proxyTo", "JsProxyTo", JsProxyTo)
+	group.AddFunction("proxyToWithHook", "JsProxyToWithHook", JsProxyToWithHook)
+	group.AddFunction("proxyPool_GetUpstreams", "JsProxyPoolGetUpstreams", JsProxyPoolGetUpstreams)
 
 	group.AddAsyncFunction("gzipCompressFile", "JsGzipCompressFileAsync", JsGzipCompressFileAsync)
 	group.AddAsyncFunction("brotliCompressFile", "JsBrotliCompressFileAsync", JsBrotliCompressFileAsync)
 	group.AddAsyncFunction("fetch", "JsFetchAsync", JsFetchAsync)
+	group.AddAsyncFunction("fetchWithBytes", "JsFetchWithBytesAsync", JsFetchWithBytesAsync)
+	group.AddAsyncFunction("fetchResponse", "JsFetchResponseAsync", JsFetchResponseAsync)
+	group.AddAsyncFunction("fetchResponseWithAbort", "JsFetchResponseWithAbortAsync", JsFetchResponseWithAbortAsync)
+	group.AddFunction("fetchAbort_Create", "JsFetchAbortCreate", JsFetchAbortCreate)
+	group.AddFunction("fetchAbort", "JsFetchAbort", JsFetchAbort)
+	group.AddFunction("fetchResponse_Info", "JsFetchResponseInfo", JsFetchResponseInfo)
+	group.AddFunction("fetchResponse_Cookies", "JsFetchResponseCookies", JsFetchResponseCookies)
+	group.AddAsyncFunction("fetchResponse_ReadAll", "JsFetchResponseReadAllAsync", JsFetchResponseReadAllAsync)
+	group.AddAsyncFunction("fetchResponse_ReadChunk", "JsFetchResponseReadChunkAsync", JsFetchResponseReadChunkAsync)
+	group.AddFunction("httpClient_Create", "JsHttpClientCreate", JsHttpClientCreate)
+	group.AddAsyncFunction("httpClient_Fetch", "JsHttpClientFetchAsync", JsHttpClientFetchAsync)
+	group.AddAsyncFunction("httpClient_FetchResponse", "JsHttpClientFetchResponseAsync", JsHttpClientFetchResponseAsync)
+	group.AddFunction("httpClient_Cookies", "JsHttpClientCookies", JsHttpClientCookies)
 
 	// >>> File server
 
@@ -86,21 +130,53 @@ func registerExportedFunctions() {
 	group.AddFunction("fileServer_RemoveUri", "JsFileServerRemoveUri", JsFileServerRemoveUri)
 	group.AddFunction("fileServer_VisitCache", "JsFileServerVisitCache", JsFileServerVisitCache)
 	group.AddFunction("fileServer_OnFileNotFound", "JsFileServerOnFileNotFound", JsFileServerOnFileNotFound)
+	group.AddFunction("fileServer_SetDataFunction", "JsFileServerSetDataFunction", JsFileServerSetDataFunction)
+	group.AddFunction("fileServer_Stats", "JsFileServerStats", JsFileServerStats)
+	group.AddFunction("fileServer_OnChange", "JsFileServerOnChange", JsFileServerOnChange)
 }
 
 // JsConfigureServer configure a server designed by his port.
 // It does nothing if the server is already started, but returns false if the configuration can't be applied.
-func JsConfigureServer(serverPort int, params httpServer.StartParams) bool {
+func JsConfigureServer(serverPort int, config JsServerConfig) bool {
 	server := libFastHttpImpl.GetFastHttpServer(serverPort)
 
 	if server.IsStarted() {
 		return false
 	}
 
-	server.SetStartServerParams(params)
+	settings := getServerSettings(serverPort)
+	settings.mutex.Lock()
+	settings.maxRequestsPerIp = config.MaxRequestsPerIp
+	settings.handlerTimeout = config.HandlerTimeout
+	settings.handlerTimeoutStatus = config.HandlerTimeoutStatus
+	settings.hideErrors = config.HideErrors
+	settings.mutex.Unlock()
+
+	server.SetStartServerParams(config.StartParams)
 	return true
 }
 
+// JsServerConfig is the configuration of a server, with the settings handled by this module.
+type JsServerConfig struct {
+	httpServer.StartParams
+
+	// MaxRequestsPerIp is the max number of requests processed at the same time for a client ip,
+	// above which a 429 response is returned. Unlimited if 0.
+	// It's a cap on the concurrent requests, not on the connections: the idle keep-alive
+	// connections aren't counted, since the connections are managed by the server library.
+	MaxRequestsPerIp int `json:"maxRequestsPerIp"`
+
+	// HandlerTimeout is the time, in milliseconds, the javascript handlers have to respond,
+	// after which a HandlerTimeoutStatus response is sent. Unlimited if 0.
+	HandlerTimeout int `json:"handlerTimeout"`
+
+	// HandlerTimeoutStatus is 503 or 504. Default is 504.
+	HandlerTimeoutStatus int `json:"handlerTimeoutStatus"`
+
+	// HideErrors avoids the default error response showing the message and the stack of the error.
+	HideErrors bool `json:"hideErrors"`
+}
+
 // JsGetHost returns an HttpHost object from a port and a hostname.
 func JsGetHost(rc *progpAPI.SharedResourceContainer, serverPort int, hostName string) *progpAPI.SharedResource {
 	server := libFastHttpImpl.GetFastHttpServer(serverPort)
@@ -146,48 +222,167 @@ func JsStartServer(rc *progpAPI.SharedResourceContainer, serverPort int) error {
 
 // JsVerbWithFunction bind a GET/POST/... call to a function inside a context.
 // This function is executed when the GET request match.
-func JsVerbWithFunction(rc *progpAPI.SharedResourceContainer, resHost *progpAPI.SharedResource, verb string, requestPath string, callback progpAPI.JsFunction) error {
+func JsVerbWithFunction(rc *progpAPI.SharedResourceContainer, resHost *progpAPI.SharedResource, verb string, requestPath string, options JsRouteOptions, callback progpAPI.JsFunction) error {
 	host, ok := resHost.Value.(*httpServer.HttpHost)
 	if !ok {
 		return errors.New("invalid resource")
 	}
 
+	route := requestPath
+
+	pattern, err := parseRoutePattern(requestPath)
+	if err != nil {
+		return err
+	}
+
+	if pattern != nil {
+		if err = getHostSettings(host).claimResolverPath(strings.ToUpper(verb), pattern, route); err != nil {
+			return err
+		}
+
+		requestPath = pattern.resolverPath
+	}
+
 	// Allows calling this function more than one time.
 	callback.KeepAlive()
 
-	host.VERB(verb, requestPath, func(call httpServer.HttpRequest) error {
-		res := rc.NewSharedResource(call, nil)
+	registerRoute(host, verb, requestPath, buildJsHandler(rc, callback, pattern, route, options))
+	return nil
+}
 
-		// Allows disposing before the host script exit.
-		defer res.Dispose()
+// JsAllVerbsWithFunction is like JsVerbWithFunction but bind all the verbs.
+func JsAllVerbsWithFunction(rc *progpAPI.SharedResourceContainer, resHost *progpAPI.SharedResource, requestPath string, options JsRouteOptions, callback progpAPI.JsFunction) error {
+	host, ok := resHost.Value.(*httpServer.HttpHost)
+	if !ok {
+		return errors.New("invalid resource")
+	}
 
-		callback.CallWithResource2(res)
+	route := requestPath
 
-		// Will block the call until a response is sent.
-		call.WaitResponse()
+	pattern, err := parseRoutePattern(requestPath)
+	if err != nil {
+		return err
+	}
 
-		if !call.IsBodySend() {
-			return NoResponseSendError
+	if pattern != nil {
+		if err = getHostSettings(host).claimResolverPath(AllVerbs, pattern, route); err != nil {
+			return err
 		}
 
-		return nil
-	})
+		requestPath = pattern.resolverPath
+	}
 
+	callback.KeepAlive()
+
+	registerRoute(host, AllVerbs, requestPath, buildJsHandler(rc, callback, pattern, route, options))
 	return nil
 }
 
+// buildJsHandler returns a handler calling a javascript function with the request.
+// If the route has named params, then the request is rejected with a 404 when they don't match their constraints.
+func buildJsHandler(rc *progpAPI.SharedResourceContainer, callback progpAPI.JsFunction, pattern *routePattern, route string, options JsRouteOptions) httpServer.HttpMiddleware {
+	return func(call httpServer.HttpRequest) error {
+		var params map[string]string
+
+		if pattern != nil {
+			var ok bool
+
+			if params, ok = pattern.extract(call.Path()); !ok {
+				call.GetHost().OnNotFound(call)
+				return nil
+			}
+		}
+
+		// Avoid entering javascript if the body is too large.
+		if isRequestBodyTooLarge(call, call.GetContentLength()) {
+			returnRequestBodyTooLarge(call)
+			return nil
+		}
+
+		if !decodeRequestBody(call) {
+			return nil
+		}
+
+		req := newJsHttpRequest(rc, call)
+		req.params = params
+
+		// Allows disposing before the host script exit.
+		// If the response is streamed, the resource is disposed once the stream ends.
+		defer req.release()
+
+		callback.CallWithResource2(req.res)
+
+		serverSettings := getHostSettings(call.GetHost()).server
+		timeout, timeoutStatus := serverSettings.getHandlerTimeout(options)
+
+		// Will block the call until a response is sent, the handler ends, or the timeout occurs.
+		// On timeout, releasing the request disposes his resource, which makes
+		// the next calls of the handler fail instead of writing into a reused request.
+		isTimedOut := !req.waitHandler(timeout)
+
+		if !req.IsBodySend() {
+			responseError := NoResponseSendError
+			if isTimedOut {
+				responseError = ResponseTimedOutError
+			}
+
+			// The error response is sent by the error handling layer of the host.
+			if req.takeResponse(responseError) {
+				if isTimedOut {
+					serverSettings.onHandlerTimeout(call, route, timeout)
+				}
+
+				return req.getHandlerError(isTimedOut, timeoutStatus, route)
+			}
+		}
+
+		if ctx, err := getFastHttpCtx(call); err == nil {
+			compressResponse(ctx, getHostSettings(call.GetHost()).compression.Load())
+		}
+
+		return nil
+	}
+}
+
 // JsReturnString set the response to returns.
 func JsReturnString(resHttpRequest *progpAPI.SharedResource, responseCode int, contentType string, responseText string) error {
-	call, ok := resHttpRequest.Value.(httpServer.HttpRequest)
-	if !ok {
-		return errors.New("invalid resource")
+	req, err := getJsHttpRequest(resHttpRequest)
+	if err != nil {
+		return err
 	}
 
-	call.SetContentType(contentType)
-
 	// This response will unlock the caller mutex and the response will be sent.
-	call.ReturnString(responseCode, responseText)
-	return nil
+	return req.sendResponse(func() error {
+		req.HttpRequest.SetContentType(contentType)
+		req.HttpRequest.ReturnString(responseCode, responseText)
+		return nil
+	})
+}
+
+// JsReturnBytes set a binary response to returns.
+func JsReturnBytes(resHttpRequest *progpAPI.SharedResource, responseCode int, contentType string, responseBody []byte) error {
+	req, err := getJsHttpRequest(resHttpRequest)
+	if err != nil {
+		return err
+	}
+
+	if req.IsBodySend() {
+		return ResponseAlreadySentError
+	}
+
+	ctx, err := getFastHttpCtx(req)
+	if err != nil {
+		return err
+	}
+
+	return req.sendResponse(func() error {
+		ctx.SetStatusCode(responseCode)
+		ctx.SetContentType(contentType)
+
+		// SetBody copy the buffer, which is required since his memory is owned by javascript.
+		ctx.SetBody(responseBody)
+		return nil
+	})
 }
 
 func JsRequestURI(resHttpRequest *progpAPI.SharedResource) (error, string) {
@@ -428,13 +623,15 @@ func JsRequestHeaders(resHttpRequest *progpAPI.SharedResource) (error, map[strin
 }
 
 func JsRequestSetHeader(resHttpRequest *progpAPI.SharedResource, key string, value string) error {
-	call, ok := resHttpRequest.Value.(httpServer.HttpRequest)
-	if !ok {
-		return errors.New("invalid resource")
+	req, err := getJsHttpRequest(resHttpRequest)
+	if err != nil {
+		return err
 	}
 
-	call.SetHeader(key, value)
-	return nil
+	return req.modifyResponse(func() error {
+		req.HttpRequest.SetHeader(key, value)
+		return nil
+	})
 }
 
 func JsRequestCookies(resHttpRequest *progpAPI.SharedResource) (error, map[string]map[string]any) {
@@ -448,12 +645,12 @@ func JsRequestCookies(resHttpRequest *progpAPI.SharedResource) (error, map[strin
 }
 
 func JsRequestSetCookie(resHttpRequest *progpAPI.SharedResource, key string, value string, options httpServer.HttpCookieOptions) error {
-	call, ok := resHttpRequest.Value.(httpServer.HttpRequest)
-	if !ok {
-		return errors.New("invalid resource")
+	req, err := getJsHttpRequest(resHttpRequest)
+	if err != nil {
+		return err
 	}
 
-	return call.SetCookie(key, value, options)
+	return req.SetCookie(key, value, options)
 }
 
 func JsSendFileAsIs(resHttpRequest *progpAPI.SharedResource, filePath string, mimeType string, contentEncoding string) error {
@@ -514,82 +711,41 @@ func JsBrotliCompressFileAsync(sourceFilePath string, destFilePath string, compr
 	})
 }
 
-func JsFetchAsync(url string, options JsFetchOptions, callback progpAPI.JsFunction) {
-	progpAPI.SafeGoRoutine(func() {
-		if options.Method == "" {
-			options.Method = "GET"
-		}
-
-		fetchOptions := libFastHttpImpl.FetchOptions{
-			SendHeaders: options.SendHeaders,
-			SendCookies: options.SendCookies,
-			SkipBody:    options.SkipBody,
-			ContentType: options.ContentType,
-			UserAgent:   options.UserAgent,
-		}
-
-		httpResult, err := libFastHttpImpl.Fetch(url, options.Method, fetchOptions)
-		if err != nil {
-			callback.CallWithError(err)
-			return
-		}
-
-		defer httpResult.Dispose()
-
-		jsResult := JsFetchResult{}
-		jsResult.StatusCode = httpResult.StatusCode()
-
-		if !options.SkipBody && ((jsResult.StatusCode == 200) || options.ForceReturningBody) {
-			if options.StreamBodyToFile == "" {
-				jsResult.Body, err = httpResult.GetBodyAsString()
-				if err != nil {
-					callback.CallWithError(err)
-					return
-				}
-			} else {
-				err = httpResult.StreamBodyToFile(options.StreamBodyToFile)
-				if err != nil {
-					callback.CallWithError(err)
-					return
-				}
-			}
-		}
-
-		if options.ReturnHeaders {
-			jsResult.Headers = httpResult.GetHeaders()
-		}
-
-		if options.ReturnCookies {
-			jsResult.Cookies, err = httpResult.GetCookies()
-			if err != nil {
-				callback.CallWithError(err)
-				return
-			}
-		}
-
-		asJson, err := json.Marshal(jsResult)
-		if err != nil {
-			callback.CallWithError(err)
-			return
-		}
+// JsProxyTo allows to proxy the incoming call directly to a website.
+// The options can contain more than one target, in which case the requests are balanced between them.
+// Returns a resource allowing to inspect the health of the targets.
+func JsProxyTo(resHost *progpAPI.SharedResource, requestPath string, targetHostName string, options JsProxyOptions) (*progpAPI.SharedResource, error) {
+	return proxyTo(resHost, requestPath, targetHostName, options, nil)
+}
 
-		callback.CallWithStringBuffer2(asJson)
-	})
+// JsProxyToWithHook is like JsProxyTo, but calls a javascript function before each proxied request.
+// This function can inspect the request and veto it by returning an error.
+func JsProxyToWithHook(resHost *progpAPI.SharedResource, requestPath string, targetHostName string, options JsProxyOptions, hook progpAPI.JsFunction) (*progpAPI.SharedResource, error) {
+	hook.KeepAlive()
+	return proxyTo(resHost, requestPath, targetHostName, options, hook)
 }
 
-// JsProxyTo allows to proxy the incoming call directly to a website.
-func JsProxyTo(resHost *progpAPI.SharedResource, requestPath string, targetHostName string, options JsProxyOptions) error {
+func proxyTo(resHost *progpAPI.SharedResource, requestPath string, targetHostName string, options JsProxyOptions, hook progpAPI.JsFunction) (*progpAPI.SharedResource, error) {
 	host, ok := resHost.Value.(*httpServer.HttpHost)
 	if !ok {
-		return errors.New("invalid resource")
+		return nil, errors.New("invalid resource")
+	}
+
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultProxyTimeout
+	}
+
+	if targetHostName != "" {
+		options.Upstreams = append([]JsProxyUpstream{{Target: targetHostName, Weight: 1}}, options.Upstreams...)
 	}
 
-	mdw, err := libFastHttpImpl.BuildProxyAsIsMiddleware(targetHostName, 60)
+	pool, err := newProxyPool(options)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	host.AllVerbs(requestPath, mdw)
+	mdw := buildProxyMiddleware(resHost.GetContainer(), requestPath, pool.serve, options, hook)
+	registerRoute(host, AllVerbs, requestPath, mdw)
 
 	if !options.ExcludeSubPaths {
 		if !strings.HasSuffix(requestPath, "/") {
@@ -598,15 +754,12 @@ func JsProxyTo(resHost *progpAPI.SharedResource, requestPath string, targetHostN
 			requestPath += "*"
 		}
 
-		mdw, err := libFastHttpImpl.BuildProxyAsIsMiddleware(targetHostName, 60)
-		if err != nil {
-			return err
-		}
-
-		host.AllVerbs(requestPath, mdw)
+		registerRoute(host, AllVerbs, requestPath, mdw)
 	}
 
-	return nil
+	return resHost.GetContainer().NewSharedResource(pool, func(_ any) {
+		pool.Dispose()
+	}), nil
 }
 
 func JsFileServerCreate(resHost *progpAPI.SharedResource, requestPath string, dirPath string, options JsServeFilesOptions) (*progpAPI.SharedResource, error) {
@@ -623,60 +776,78 @@ func JsFileServerCreate(resHost *progpAPI.SharedResource, requestPath string, di
 		return nil, err
 	}
 
-	server, err := libFastHttpImpl.NewFileServer(requestPath, dirPath, libFastHttpImpl.StaticFileServerOptions{})
+	server, err := newJsFileServer(requestPath, dirPath, options)
 	if err != nil {
 		return nil, err
 	}
 
-	server.Register(host)
+	if options.Watch {
+		if server.watcher, err = server.startWatching(); err != nil {
+			return nil, err
+		}
+	}
+
+	server.register(host)
 
 	return resHost.GetContainer().NewSharedResource(server, func(_ any) {
-		server.Dispose()
+		server.dispose()
 	}), nil
 }
 
 func JsFileServerRemoveAll(resFS *progpAPI.SharedResource) error {
-	fs, ok := resFS.Value.(httpServer.FileServer)
-	if !ok {
-		return errors.New("invalid resource")
+	fs, err := getJsFileServer(resFS)
+	if err != nil {
+		return err
 	}
 
-	fs.RemoveAll()
+	fs.removeAll()
 	return nil
 }
 
 func JsFileServerRemoveUri(resFS *progpAPI.SharedResource, uri string, data string) error {
-	fs, ok := resFS.Value.(httpServer.FileServer)
-	if !ok {
-		return errors.New("invalid resource")
+	fs, err := getJsFileServer(resFS)
+	if err != nil {
+		return err
 	}
 
-	return fs.RemoveExactUri(uri, data)
+	fs.removeUri(uri, data)
+	return nil
 }
 
 func JsFileServerVisitCache(resFS *progpAPI.SharedResource, onCacheEntry progpAPI.JsFunction) error {
-	fs, ok := resFS.Value.(httpServer.FileServer)
-	if !ok {
-		return errors.New("invalid resource")
+	fs, err := getJsFileServer(resFS)
+	if err != nil {
+		return err
 	}
 
 	jsEntry := make(map[string]any)
 
 	onCacheEntry.KeepAlive()
 
-	fs.VisitCache(func(entry httpServer.FileServerCacheEntry) {
-		jsEntry["hitCount"] = entry.GetHitCount()
-		jsEntry["filePath"] = entry.GetFilePath()
-		jsEntry["gzipFilePath"] = entry.GetGzipFilePath()
-		jsEntry["uri"] = entry.GetFullUri()
-		jsEntry["data"] = entry.GetData()
+	fs.visitCache(func(entry *fileCacheEntry) {
+		jsEntry["hitCount"] = entry.hitCount.Load()
+		jsEntry["uri"] = entry.uri
+		jsEntry["data"] = entry.data
+
+		jsEntry["fileUpdateDate"] = entry.modTime.Unix()
+		jsEntry["lastRequestedData"] = entry.getLastRequestedDate().Unix()
+		jsEntry["contentType"] = entry.contentType
 
-		jsEntry["fileUpdateDate"] = entry.GetFileUpdateDate().Unix()
-		jsEntry["lastRequestedData"] = entry.GetLastRequestedDate().Unix()
+		jsEntry["filePath"] = ""
+		jsEntry["contentLength"] = 0
 
-		jsEntry["contentType"] = entry.GetContentType()
-		jsEntry["contentLength"] = entry.GetContentLength()
-		jsEntry["gzipContentLength"] = entry.GetGzipContentLength()
+		if entry.identity != nil {
+			jsEntry["filePath"] = entry.identity.filePath
+			jsEntry["contentLength"] = entry.identity.size
+		}
+
+		jsEntry["gzipFilePath"] = ""
+		jsEntry["gzipContentLength"] = 0
+
+		if gzip := entry.encoded["gzip"]; gzip != nil {
+			jsEntry["gzipFilePath"] = gzip.filePath
+			jsEntry["gzipContentLength"] = gzip.size
+		}
 
 		asBinary, err := json.Marshal(jsEntry)
 		if err == nil {
@@ -687,15 +858,25 @@ func JsFileServerVisitCache(resFS *progpAPI.SharedResource, onCacheEntry progpAP
 	return nil
 }
 
+// JsFileServerStats returns, as json, the state of the cache of the file server.
+func JsFileServerStats(resFS *progpAPI.SharedResource) (progpAPI.StringBuffer, error) {
+	fs, err := getJsFileServer(resFS)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(fs.getStats())
+}
+
 func JsFileServerOnFileNotFound(resFS *progpAPI.SharedResource, callback progpAPI.JsFunction) error {
-	fs, ok := resFS.Value.(httpServer.FileServer)
-	if !ok {
-		return errors.New("invalid resource")
+	fs, err := getJsFileServer(resFS)
+	if err != nil {
+		return err
 	}
 
 	callback.KeepAlive()
 
-	fs.GetHooks().OnFileNotFound = func(call httpServer.HttpRequest, filePath string, data string) error {
+	fs.setOnFileNotFound(func(call httpServer.HttpRequest, filePath string, data string) error {
 		info := make(map[string]any)
 
 		info["filePath"] = filePath
@@ -715,8 +896,62 @@ func JsFileServerOnFileNotFound(resFS *progpAPI.SharedResource, callback progpAP
 		lock.Wait()
 
 		return nil
+	})
+
+	return nil
+}
+
+// JsFileServerSetDataFunction set the javascript function returning the data of a request.
+// The cache keeps an entry for each uri and data, and the data is given to the not-found hook.
+// If the function fails, or doesn't return before FileServerDataFunctionTimeout, the request fails.
+func JsFileServerSetDataFunction(resFS *progpAPI.SharedResource, callback progpAPI.JsFunction) error {
+	fs, err := getJsFileServer(resFS)
+	if err != nil {
+		return err
+	}
+
+	callback.KeepAlive()
+
+	fs.setDataFunction(func(call httpServer.HttpRequest) (string, error) {
+		info := make(map[string]any)
+
+		info["uri"] = call.FullURI()
+		info["uriPath"] = call.Path()
+		info["queryString"] = string(call.URI().UriQueryString())
+		info["hostname"] = call.GetHost().GetHostName()
+		info["headers"] = call.GetHeaders()
+
+		b, err := json.Marshal(info)
+		if err != nil {
+			return "", err
+		}
+
+		return callJsFunctionAndWait(resFS.GetContainer(), callback, b, FileServerDataFunctionTimeout)
+	})
+
+	return nil
+}
+
+// JsFileServerOnChange set the function called when a file changes, if the files are watched.
+// The cache entries of the file have already been evicted when it's called.
+func JsFileServerOnChange(resFS *progpAPI.SharedResource, callback progpAPI.JsFunction) error {
+	fs, err := getJsFileServer(resFS)
+	if err != nil {
+		return err
+	}
+
+	if fs.watcher == nil {
+		return errors.New("the files aren't watched")
 	}
 
+	callback.KeepAlive()
+
+	fs.setOnChange(func(change fileChange) {
+		if b, err := json.Marshal(change); err == nil {
+			callback.CallWithStringBuffer2(b)
+		}
+	})
+
 	return nil
 }
 
@@ -750,11 +985,146 @@ type JsFetchOptions struct {
 	// UserAgent set the user agent used when sending a body with the request.
 	// Isn't set when no request are set.
 	UserAgent string
+
+	// Body is the text sent as the request body.
+	Body string `json:"body"`
+
+	// Form is sent as an url-encoded body.
+	Form map[string]string `json:"form"`
+
+	// Multipart is sent as a multipart/form-data body.
+	Multipart *JsFetchMultipart `json:"multipart"`
+
+	// Timeout is the max time, in milliseconds, for the whole request, redirects included.
+	// Zero means no timeout.
+	Timeout int `json:"timeout"`
+
+	// Redirect is "follow" (default), "manual" which returns the redirect response, or "error".
+	Redirect string `json:"redirect"`
+
+	// MaxRedirects is the max number of redirects followed. Default is 10.
+	MaxRedirects int `json:"maxRedirects"`
+
+	Tls JsFetchTlsOptions `json:"tls"`
+}
+
+type JsFetchMultipart struct {
+	Fields map[string]string      `json:"fields"`
+	Files  []JsFetchMultipartFile `json:"files"`
+}
+
+type JsFetchMultipartFile struct {
+	FieldName string `json:"fieldName"`
+	FilePath  string `json:"filePath"`
+
+	// FileName is the name sent for this file. Default is the name of the file on disk.
+	FileName string `json:"fileName"`
+
+	// ContentType is the content type of the file. Default is deduced from the file extension.
+	ContentType string `json:"contentType"`
+}
+
+type JsFetchTlsOptions struct {
+	// CaFile is the path of a PEM file containing the certificate authorities to trust.
+	CaFile string `json:"caFile"`
+
+	// CaCert is like CaFile, but directly contains the PEM certificates.
+	CaCert string `json:"caCert"`
+
+	// InsecureSkipVerify disables the certificate verification, it must only be used for development.
+	InsecureSkipVerify bool `json:"insecureSkipVerify"`
 }
 
 type JsProxyOptions struct {
 	ExcludeSubPaths bool `json:"excludeSubPaths"`
+
+	// Timeout is the max time, in seconds, to wait for the target.
+	// Default is 60 seconds.
+	Timeout int `json:"timeout"`
+
+	// StripPrefix allows to remove the proxied path from the path sent to the target.
+	StripPrefix bool `json:"stripPrefix"`
+
+	// PathPrefix is added before the path sent to the target.
+	PathPrefix string `json:"pathPrefix"`
+
+	// ForwardedHeaders allows to add the X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host headers.
+	ForwardedHeaders bool `json:"forwardedHeaders"`
+
+	// RequestHeaders are added to the headers sent to the target.
+	RequestHeaders map[string]string `json:"requestHeaders"`
+
+	// ResponseHeaders are added to the headers returned by the target, replacing existing ones.
+	ResponseHeaders map[string]string `json:"responseHeaders"`
+
+	// RemoveResponseHeaders are removed from the headers returned by the target.
+	RemoveResponseHeaders []string `json:"removeResponseHeaders"`
+
+	// Upstreams are the targets between which the requests are balanced.
+	Upstreams []JsProxyUpstream `json:"upstreams"`
+
+	// Strategy is how the upstream is selected: "roundRobin" (default), "leastConnections" or "weighted".
+	Strategy string `json:"strategy"`
+
+	// HealthCheck allows to periodically check the upstreams, the unhealthy ones are not used.
+	HealthCheck JsProxyHealthCheck `json:"healthCheck"`
+
+	// MaxFails is the number of consecutive failures after which an upstream is ejected.
+	// Zero means never ejecting.
+	MaxFails int `json:"maxFails"`
+
+	// FailTimeout is the time, in seconds, an upstream is ejected. Default is 30 seconds.
+	FailTimeout int `json:"failTimeout"`
+}
+
+type JsProxyUpstream struct {
+	Target string `json:"target"`
+	Weight int    `json:"weight"`
+}
+
+type JsProxyHealthCheck struct {
+	// Path is the path requested to check an upstream. Health checks are disabled if empty.
+	Path string `json:"path"`
+
+	// Interval is the time, in milliseconds, between two checks. Default is 5 seconds.
+	Interval int `json:"interval"`
+
+	// Timeout is the time, in milliseconds, to wait for a response. Default is 2 seconds.
+	Timeout int `json:"timeout"`
+
+	// ExpectedStatus is the expected status code. If not set, all codes from 200 to 399 are accepted.
+	ExpectedStatus int `json:"expectedStatus"`
 }
 
 type JsServeFilesOptions struct {
+	// DisablePreCompressed avoids serving the ".br" and ".gz" files found next to the requested file.
+	DisablePreCompressed bool `json:"disablePreCompressed"`
+
+	// MaxAge is the max-age, in seconds, of the Cache-Control header, by file extension.
+	// The key "*" is used for the other extensions, and a negative value sends "no-cache".
+	MaxAge map[string]int `json:"maxAge"`
+
+	DisableEtag         bool `json:"disableEtag"`
+	DisableLastModified bool `json:"disableLastModified"`
+
+	// IndexFiles are the files searched when a directory is requested. Default is index.html.
+	IndexFiles []string `json:"indexFiles"`
+
+	// DirectoryListing returns the list of the files when a directory has no index file.
+	DirectoryListing bool `json:"directoryListing"`
+
+	// ShowDotFiles allows serving the files whose name, or the name of a parent directory, starts with a dot.
+	ShowDotFiles bool `json:"showDotFiles"`
+
+	// SpaFallback is the file sent for the unknown paths without extension, which are the routes
+	// of a single-page-app. It's relative to the served directory, unless absolute.
+	SpaFallback string `json:"spaFallback"`
+
+	// ErrorPages are the files sent for an error, by status code. Only 404 and 500 are used.
+	ErrorPages map[int]string `json:"errorPages"`
+
+	Cache JsFileServerCacheOptions `json:"cache"`
+
+	// Watch evicts the cache entries of the files modified on the disk.
+	Watch bool `json:"watch"`
 }
@@ -0,0 +1,183 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/progpjs/httpServer/v2"
+	"github.com/progpjs/progpAPI/v2"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultProxyTimeout is the time, in seconds, to wait for the proxy target.
+const DefaultProxyTimeout = 60
+
+// buildProxyMiddleware wraps the middleware doing the proxy call, in order
+// to apply the options to the request before and to the response after.
+func buildProxyMiddleware(rc *progpAPI.SharedResourceContainer, basePath string, target httpServer.HttpMiddleware, options JsProxyOptions, hook progpAPI.JsFunction) httpServer.HttpMiddleware {
+	return func(call httpServer.HttpRequest) error {
+		ctx, err := getFastHttpCtx(call)
+		if err != nil {
+			return err
+		}
+
+		if hook != nil {
+			refusal, err := callProxyHook(rc, hook, call)
+
+			// The hook has failed, which isn't a refusal.
+			if err != nil {
+				return err
+			}
+
+			if refusal != nil {
+				call.SetContentType(refusal.ContentType)
+				call.ReturnString(refusal.Status, refusal.Body)
+				return nil
+			}
+		}
+
+		rewriteProxyRequest(ctx, basePath, options)
+
+		if err = target(call); err != nil {
+			return err
+		}
+
+		rewriteProxyResponse(ctx, options)
+		return nil
+	}
+}
+
+// jsProxyRefusal is the response sent when the proxy hook vetoes a request.
+type jsProxyRefusal struct {
+	Status      int    `json:"status"`
+	Body        string `json:"body"`
+	ContentType string `json:"contentType"`
+}
+
+// parseProxyRefusal decodes the refusal returned by the proxy hook, as json.
+// Returns nil if the hook has accepted the request, which is when nothing is returned.
+func parseProxyRefusal(res string) (*jsProxyRefusal, error) {
+	if res == "" {
+		return nil, nil
+	}
+
+	refusal := &jsProxyRefusal{}
+
+	if err := json.Unmarshal([]byte(res), refusal); err != nil {
+		return nil, err
+	}
+
+	if refusal.Status == 0 {
+		refusal.Status = 403
+	}
+
+	if refusal.Status < 100 || refusal.Status > 999 {
+		return nil, errors.New("invalid status code for the proxy refusal: " + strconv.Itoa(refusal.Status))
+	}
+
+	if refusal.Body == "" {
+		refusal.Body = http.StatusText(refusal.Status)
+	}
+
+	if refusal.ContentType == "" {
+		refusal.ContentType = "text/plain"
+	}
+
+	return refusal, nil
+}
+
+// callProxyHook allows javascript to inspect the request, and to veto it by returning the response to send.
+// Returns an error if the hook has thrown or hasn't returned in time.
+func callProxyHook(rc *progpAPI.SharedResourceContainer, hook progpAPI.JsFunction, call httpServer.HttpRequest) (*jsProxyRefusal, error) {
+	info := make(map[string]any)
+
+	info["method"] = call.GetMethodName()
+	info["uri"] = call.FullURI()
+	info["path"] = call.Path()
+	info["ip"] = call.RemoteIP()
+	info["hostname"] = call.GetHost().GetHostName()
+	info["headers"] = call.GetHeaders()
+
+	b, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := callJsFunctionAndWait(rc, hook, b, DefaultJsCallTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseProxyRefusal(res)
+}
+
+func rewriteProxyRequest(ctx *fasthttp.RequestCtx, basePath string, options JsProxyOptions) {
+	if options.StripPrefix || (options.PathPrefix != "") {
+		uri := ctx.Request.URI()
+		path := string(uri.Path())
+
+		if options.StripPrefix {
+			path = strings.TrimPrefix(path, strings.TrimSuffix(basePath, "/"))
+
+			if !strings.HasPrefix(path, "/") {
+				path = "/" + path
+			}
+		}
+
+		if options.PathPrefix != "" {
+			path = strings.TrimSuffix(options.PathPrefix, "/") + path
+		}
+
+		uri.SetPath(path)
+	}
+
+	if options.ForwardedHeaders {
+		header := &ctx.Request.Header
+		ip := ctx.RemoteIP().String()
+
+		if previous := string(header.Peek("X-Forwarded-For")); previous != "" {
+			ip = previous + ", " + ip
+		}
+
+		header.Set("X-Forwarded-For", ip)
+		header.Set("X-Forwarded-Host", string(ctx.Host()))
+
+		if ctx.IsTLS() {
+			header.Set("X-Forwarded-Proto", "https")
+		} else {
+			header.Set("X-Forwarded-Proto", "http")
+		}
+	}
+
+	for key, value := range options.RequestHeaders {
+		ctx.Request.Header.Set(key, value)
+	}
+}
+
+func rewriteProxyResponse(ctx *fasthttp.RequestCtx, options JsProxyOptions) {
+	for _, key := range options.RemoveResponseHeaders {
+		ctx.Response.Header.Del(key)
+	}
+
+	for key, value := range options.ResponseHeaders {
+		ctx.Response.Header.Set(key, value)
+	}
+}
@@ -0,0 +1,330 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/progpjs/httpServer/v2"
+	"github.com/progpjs/httpServer/v2/libFastHttpImpl"
+	"github.com/progpjs/progpAPI/v2"
+	"github.com/valyala/fasthttp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ProxyStrategyRoundRobin       = "roundRobin"
+	ProxyStrategyLeastConnections = "leastConnections"
+	ProxyStrategyWeighted         = "weighted"
+)
+
+// DefaultProxyFailTimeout is the time, in seconds, an upstream is ejected after too many failures.
+const DefaultProxyFailTimeout = 30
+
+// DefaultProxyHealthCheckInterval is the time, in milliseconds, between two active health checks.
+const DefaultProxyHealthCheckInterval = 5000
+
+// DefaultHealthCheckTimeout is the time, in milliseconds, an upstream has to answer a health check.
+const DefaultHealthCheckTimeout = 2000
+
+type proxyUpstream struct {
+	target     string
+	weight     int
+	middleware httpServer.HttpMiddleware
+
+	// isHealthy is updated by the active health checks.
+	isHealthy atomic.Bool
+
+	// ejectedUntil is set, as unix nano, when there is too many consecutive failures.
+	ejectedUntil atomic.Int64
+
+	activeConnections   atomic.Int32
+	consecutiveFailures atomic.Int32
+	totalRequests       atomic.Int64
+	totalFailures       atomic.Int64
+
+	// currentWeight is used by the weighted strategy, it's protected by the pool mutex.
+	currentWeight int
+}
+
+func (m *proxyUpstream) isAvailable(now int64) bool {
+	return m.isHealthy.Load() && (m.ejectedUntil.Load() < now)
+}
+
+// ProxyUpstreamHealth is the state of an upstream, as returned to javascript.
+type ProxyUpstreamHealth struct {
+	Target              string `json:"target"`
+	Weight              int    `json:"weight"`
+	IsHealthy           bool   `json:"isHealthy"`
+	IsEjected           bool   `json:"isEjected"`
+	ActiveConnections   int    `json:"activeConnections"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	TotalRequests       int64  `json:"totalRequests"`
+	TotalFailures       int64  `json:"totalFailures"`
+}
+
+// ProxyPool dispatches the proxied requests between a set of upstreams.
+type ProxyPool struct {
+	upstreams []*proxyUpstream
+	options   JsProxyOptions
+
+	nextUpstream atomic.Uint64
+	mutex        sync.Mutex
+
+	stopHealthCheck chan bool
+	stopOnce        sync.Once
+}
+
+func newProxyPool(options JsProxyOptions) (*ProxyPool, error) {
+	if len(options.Upstreams) == 0 {
+		return nil, errors.New("no proxy target")
+	}
+
+	switch options.Strategy {
+	case "":
+		options.Strategy = ProxyStrategyRoundRobin
+	case ProxyStrategyRoundRobin, ProxyStrategyLeastConnections, ProxyStrategyWeighted:
+	default:
+		return nil, errors.New("unknown proxy strategy " + options.Strategy)
+	}
+
+	if options.FailTimeout <= 0 {
+		options.FailTimeout = DefaultProxyFailTimeout
+	}
+
+	pool := &ProxyPool{options: options, stopHealthCheck: make(chan bool)}
+
+	for _, entry := range options.Upstreams {
+		mdw, err := libFastHttpImpl.BuildProxyAsIsMiddleware(entry.Target, options.Timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		upstream := &proxyUpstream{target: entry.Target, weight: entry.Weight, middleware: mdw}
+		upstream.isHealthy.Store(true)
+
+		if upstream.weight <= 0 {
+			upstream.weight = 1
+		}
+
+		pool.upstreams = append(pool.upstreams, upstream)
+	}
+
+	if options.HealthCheck.Path != "" {
+		pool.startHealthCheck()
+	}
+
+	return pool, nil
+}
+
+func (m *ProxyPool) Dispose() {
+	m.stopOnce.Do(func() {
+		close(m.stopHealthCheck)
+	})
+}
+
+// GetUpstreamsHealth returns the current state of each upstream.
+func (m *ProxyPool) GetUpstreamsHealth() []ProxyUpstreamHealth {
+	now := time.Now().UnixNano()
+	var res []ProxyUpstreamHealth
+
+	for _, u := range m.upstreams {
+		res = append(res, ProxyUpstreamHealth{
+			Target:              u.target,
+			Weight:              u.weight,
+			IsHealthy:           u.isHealthy.Load(),
+			IsEjected:           u.ejectedUntil.Load() >= now,
+			ActiveConnections:   int(u.activeConnections.Load()),
+			ConsecutiveFailures: int(u.consecutiveFailures.Load()),
+			TotalRequests:       u.totalRequests.Load(),
+			TotalFailures:       u.totalFailures.Load(),
+		})
+	}
+
+	return res
+}
+
+// selectUpstream returns the upstream to use, or nil if none is available.
+func (m *ProxyPool) selectUpstream() *proxyUpstream {
+	now := time.Now().UnixNano()
+	var available []*proxyUpstream
+
+	for _, u := range m.upstreams {
+		if u.isAvailable(now) {
+			available = append(available, u)
+		}
+	}
+
+	if len(available) == 0 {
+		return nil
+	}
+
+	switch m.options.Strategy {
+	case ProxyStrategyLeastConnections:
+		selected := available[0]
+
+		for _, u := range available[1:] {
+			if u.activeConnections.Load() < selected.activeConnections.Load() {
+				selected = u
+			}
+		}
+
+		return selected
+
+	case ProxyStrategyWeighted:
+		// Smooth weighted round-robin, which avoids sending bursts to the heaviest upstream.
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		total := 0
+		var selected *proxyUpstream
+
+		for _, u := range available {
+			u.currentWeight += u.weight
+			total += u.weight
+
+			if (selected == nil) || (u.currentWeight > selected.currentWeight) {
+				selected = u
+			}
+		}
+
+		selected.currentWeight -= total
+		return selected
+
+	default:
+		offset := m.nextUpstream.Add(1) - 1
+		return available[offset%uint64(len(available))]
+	}
+}
+
+// serve is the middleware sending the request to one of the upstreams.
+func (m *ProxyPool) serve(call httpServer.HttpRequest) error {
+	upstream := m.selectUpstream()
+
+	if upstream == nil {
+		call.SetContentType("text/plain")
+		call.ReturnString(503, "Service Unavailable")
+		return nil
+	}
+
+	upstream.activeConnections.Add(1)
+	upstream.totalRequests.Add(1)
+
+	err := upstream.middleware(call)
+
+	upstream.activeConnections.Add(-1)
+
+	isFailure := err != nil
+
+	if !isFailure {
+		if ctx, e := getFastHttpCtx(call); e == nil {
+			status := ctx.Response.StatusCode()
+			isFailure = (status == 502) || (status == 503) || (status == 504)
+		}
+	}
+
+	if isFailure {
+		m.onUpstreamFailure(upstream)
+	} else {
+		upstream.consecutiveFailures.Store(0)
+	}
+
+	return err
+}
+
+// onUpstreamFailure ejects the upstream when there is too many consecutive failures.
+func (m *ProxyPool) onUpstreamFailure(upstream *proxyUpstream) {
+	upstream.totalFailures.Add(1)
+	failures := upstream.consecutiveFailures.Add(1)
+
+	if (m.options.MaxFails > 0) && (int(failures) >= m.options.MaxFails) {
+		ejectedUntil := time.Now().Add(time.Duration(m.options.FailTimeout) * time.Second)
+		upstream.ejectedUntil.Store(ejectedUntil.UnixNano())
+		upstream.consecutiveFailures.Store(0)
+	}
+}
+
+func (m *ProxyPool) startHealthCheck() {
+	interval := m.options.HealthCheck.Interval
+	if interval <= 0 {
+		interval = DefaultProxyHealthCheckInterval
+	}
+
+	progpAPI.SafeGoRoutine(func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			for _, u := range m.upstreams {
+				u.isHealthy.Store(m.checkUpstreamHealth(u))
+			}
+
+			select {
+			case <-m.stopHealthCheck:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+func (m *ProxyPool) checkUpstreamHealth(upstream *proxyUpstream) bool {
+	url := upstream.target
+
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+
+	url = strings.TrimSuffix(url, "/") + "/" + strings.TrimPrefix(m.options.HealthCheck.Path, "/")
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(url)
+	req.Header.SetMethod("GET")
+
+	timeout := m.options.HealthCheck.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+
+	if err := fasthttp.DoTimeout(req, resp, time.Duration(timeout)*time.Millisecond); err != nil {
+		return false
+	}
+
+	if m.options.HealthCheck.ExpectedStatus != 0 {
+		return resp.StatusCode() == m.options.HealthCheck.ExpectedStatus
+	}
+
+	return (resp.StatusCode() >= 200) && (resp.StatusCode() < 400)
+}
+
+// JsProxyPoolGetUpstreams returns, as json, the current state of each upstream of a proxy.
+func JsProxyPoolGetUpstreams(resPool *progpAPI.SharedResource) (progpAPI.StringBuffer, error) {
+	pool, ok := resPool.Value.(*ProxyPool)
+	if !ok {
+		return nil, errors.New("invalid resource")
+	}
+
+	return json.Marshal(pool.GetUpstreamsHealth())
+}
@@ -0,0 +1,164 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestProxyPool(strategy string, weights ...int) *ProxyPool {
+	pool := &ProxyPool{options: JsProxyOptions{Strategy: strategy, FailTimeout: DefaultProxyFailTimeout}}
+
+	for i, weight := range weights {
+		upstream := &proxyUpstream{target: string(rune('a' + i)), weight: weight}
+		upstream.isHealthy.Store(true)
+		pool.upstreams = append(pool.upstreams, upstream)
+	}
+
+	return pool
+}
+
+func selectTargets(pool *ProxyPool, count int) string {
+	res := ""
+
+	for i := 0; i < count; i++ {
+		if u := pool.selectUpstream(); u == nil {
+			res += "-"
+		} else {
+			res += u.target
+		}
+	}
+
+	return res
+}
+
+func TestProxyPoolStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		weights  []int
+		expected string
+	}{
+		{"roundRobin", ProxyStrategyRoundRobin, []int{1, 1, 1}, "abcabcabc"},
+		{"roundRobin ignores weights", ProxyStrategyRoundRobin, []int{5, 1}, "ababab"},
+		{"smooth weighted", ProxyStrategyWeighted, []int{5, 1, 1}, "aabacaa"},
+		{"smooth weighted repeats", ProxyStrategyWeighted, []int{2, 1}, "abaaba"},
+		{"equal weights", ProxyStrategyWeighted, []int{1, 1}, "ababab"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newTestProxyPool(tt.strategy, tt.weights...)
+
+			if got := selectTargets(pool, len(tt.expected)); got != tt.expected {
+				t.Errorf("got %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProxyPoolLeastConnections(t *testing.T) {
+	pool := newTestProxyPool(ProxyStrategyLeastConnections, 1, 1, 1)
+
+	pool.upstreams[0].activeConnections.Store(3)
+	pool.upstreams[1].activeConnections.Store(1)
+	pool.upstreams[2].activeConnections.Store(2)
+
+	if got := pool.selectUpstream().target; got != "b" {
+		t.Errorf("got %q, expected the upstream with the fewest connections", got)
+	}
+
+	pool.upstreams[1].activeConnections.Store(5)
+
+	if got := pool.selectUpstream().target; got != "c" {
+		t.Errorf("got %q, expected c", got)
+	}
+
+	// On equality the first upstream is kept.
+	pool.upstreams[0].activeConnections.Store(2)
+
+	if got := pool.selectUpstream().target; got != "a" {
+		t.Errorf("got %q, expected a", got)
+	}
+}
+
+func TestProxyPoolPassiveEjection(t *testing.T) {
+	pool := newTestProxyPool(ProxyStrategyRoundRobin, 1, 1)
+	pool.options.MaxFails = 2
+
+	upstream := pool.upstreams[0]
+
+	pool.onUpstreamFailure(upstream)
+
+	if !upstream.isAvailable(time.Now().UnixNano()) {
+		t.Fatal("upstream ejected before reaching maxFails")
+	}
+
+	pool.onUpstreamFailure(upstream)
+
+	now := time.Now().UnixNano()
+
+	if upstream.isAvailable(now) {
+		t.Fatal("upstream not ejected after maxFails failures")
+	}
+
+	if upstream.consecutiveFailures.Load() != 0 {
+		t.Error("consecutive failures must be reset once ejected")
+	}
+
+	if upstream.totalFailures.Load() != 2 {
+		t.Errorf("got %d total failures, expected 2", upstream.totalFailures.Load())
+	}
+
+	expectedEnd := time.Now().Add(DefaultProxyFailTimeout * time.Second).UnixNano()
+	if until := upstream.ejectedUntil.Load(); (until <= now) || (until > expectedEnd) {
+		t.Errorf("unexpected ejection end %d", until)
+	}
+
+	if got := selectTargets(pool, 3); got != "bbb" {
+		t.Errorf("got %q, the ejected upstream must be skipped", got)
+	}
+
+	// Once the fail timeout elapsed the upstream comes back.
+	upstream.ejectedUntil.Store(time.Now().Add(-time.Second).UnixNano())
+
+	if !upstream.isAvailable(time.Now().UnixNano()) {
+		t.Error("upstream still ejected after the fail timeout")
+	}
+
+	pool.onUpstreamFailure(pool.upstreams[1])
+	pool.onUpstreamFailure(pool.upstreams[1])
+	pool.onUpstreamFailure(upstream)
+	pool.onUpstreamFailure(upstream)
+
+	if pool.selectUpstream() != nil {
+		t.Error("expected no upstream when all are ejected")
+	}
+}
+
+func TestProxyPoolWithoutMaxFails(t *testing.T) {
+	pool := newTestProxyPool(ProxyStrategyRoundRobin, 1)
+
+	for i := 0; i < 10; i++ {
+		pool.onUpstreamFailure(pool.upstreams[0])
+	}
+
+	if pool.selectUpstream() == nil {
+		t.Error("upstreams must never be ejected when maxFails is not set")
+	}
+}
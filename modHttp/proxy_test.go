@@ -0,0 +1,52 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProxyRefusal(t *testing.T) {
+	tests := []struct {
+		res      string
+		expected *jsProxyRefusal
+	}{
+		{"", nil},
+		{"{}", &jsProxyRefusal{Status: 403, Body: "Forbidden", ContentType: "text/plain"}},
+		{`{"body":"not allowed"}`, &jsProxyRefusal{Status: 403, Body: "not allowed", ContentType: "text/plain"}},
+		{`{"status":401}`, &jsProxyRefusal{Status: 401, Body: "Unauthorized", ContentType: "text/plain"}},
+		{`{"status":429,"body":"{\"error\":1}","contentType":"application/json"}`, &jsProxyRefusal{Status: 429, Body: `{"error":1}`, ContentType: "application/json"}},
+	}
+
+	for _, test := range tests {
+		refusal, err := parseProxyRefusal(test.res)
+		if err != nil {
+			t.Fatalf("%s: %s", test.res, err)
+		}
+
+		if !reflect.DeepEqual(refusal, test.expected) {
+			t.Fatalf("%s: got %+v", test.res, refusal)
+		}
+	}
+
+	for _, res := range []string{"forbidden", `{"status":42}`} {
+		if _, err := parseProxyRefusal(res); err == nil {
+			t.Fatalf("%s: expected an error", res)
+		}
+	}
+}
@@ -0,0 +1,472 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/progpjs/httpServer/v2"
+	"github.com/progpjs/progpAPI/v2"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RateLimitTokenBucket allows bursts of Burst requests, then Limit requests per window.
+	RateLimitTokenBucket = "tokenBucket"
+
+	// RateLimitSlidingWindow allows Limit requests during any window.
+	RateLimitSlidingWindow = "slidingWindow"
+)
+
+const (
+	RateLimitKeyIp       = "ip"
+	RateLimitKeyHeader   = "header"
+	RateLimitKeyFunction = "function"
+)
+
+// DefaultRateLimitWindow is the duration, in milliseconds, of the rate limit window.
+const DefaultRateLimitWindow = 1000
+
+// RateLimitKeyFunctionTimeout is the time the javascript key function has to return the key.
+// It's called for each request, before the handler, which is why it's shorter than DefaultJsCallTimeout.
+const RateLimitKeyFunctionTimeout = 500 * time.Millisecond
+
+type JsRateLimitOptions struct {
+	// Algorithm is RateLimitTokenBucket, which is the default, or RateLimitSlidingWindow.
+	Algorithm string `json:"algorithm"`
+
+	// Limit is the number of requests allowed during the window.
+	Limit int `json:"limit"`
+
+	// Window is the duration, in milliseconds, of the window.
+	Window int `json:"window"`
+
+	// Burst is the max number of requests allowed at once by the token bucket. Default is Limit.
+	Burst int `json:"burst"`
+
+	// KeyBy tells how the clients are identified: by RateLimitKeyIp, which is the default,
+	// or by RateLimitKeyHeader. RateLimitKeyFunction is set when a javascript function computes the key.
+	KeyBy string `json:"keyBy"`
+
+	// Header is the request header identifying the client, when KeyBy is RateLimitKeyHeader.
+	// The ip is used for the requests without this header.
+	Header string `json:"header"`
+}
+
+// rateLimitState is the state of the limiter for a client.
+type rateLimitState struct {
+	lastRequest time.Time
+
+	// For the token bucket.
+	tokens float64
+
+	// For the sliding window.
+	windowStart   time.Time
+	currentCount  float64
+	previousCount float64
+}
+
+type jsRateLimiter struct {
+	pathPrefix string
+	options    JsRateLimitOptions
+	window     time.Duration
+
+	// keyFunction computes the key of a request, when KeyBy is RateLimitKeyFunction.
+	// On error, or if it doesn't return before RateLimitKeyFunctionTimeout, the ip is used.
+	// It's protected by the mutex, since it's released when the limiter is disposed.
+	keyFunction func(call httpServer.HttpRequest) (string, error)
+
+	states    map[string]*rateLimitState
+	lastSweep time.Time
+	mutex     sync.Mutex
+}
+
+func newRateLimiter(pathPrefix string, options JsRateLimitOptions) (*jsRateLimiter, error) {
+	if options.Limit <= 0 {
+		return nil, errors.New("the rate limit must be greater than zero")
+	}
+
+	if options.Window <= 0 {
+		options.Window = DefaultRateLimitWindow
+	}
+
+	if options.Burst <= 0 {
+		options.Burst = options.Limit
+	}
+
+	switch options.Algorithm {
+	case "":
+		options.Algorithm = RateLimitTokenBucket
+	case RateLimitTokenBucket, RateLimitSlidingWindow:
+	default:
+		return nil, errors.New("unknown rate limit algorithm: " + options.Algorithm)
+	}
+
+	switch options.KeyBy {
+	case "":
+		options.KeyBy = RateLimitKeyIp
+	case RateLimitKeyIp, RateLimitKeyFunction:
+	case RateLimitKeyHeader:
+		if options.Header == "" {
+			return nil, errors.New("the header identifying the clients is missing")
+		}
+	default:
+		return nil, errors.New("unknown rate limit key: " + options.KeyBy)
+	}
+
+	return &jsRateLimiter{
+		pathPrefix: strings.TrimSuffix(pathPrefix, "/"),
+		options:    options,
+		window:     time.Duration(options.Window) * time.Millisecond,
+		states:     make(map[string]*rateLimitState),
+		lastSweep:  time.Now(),
+	}, nil
+}
+
+func (m *jsRateLimiter) isMatchingPath(path string) bool {
+	return (m.pathPrefix == "") || (path == m.pathPrefix) || strings.HasPrefix(path, m.pathPrefix+"/")
+}
+
+// getKey returns the key identifying the client. An empty key means that the request isn't limited.
+func (m *jsRateLimiter) getKey(call httpServer.HttpRequest) string {
+	switch m.options.KeyBy {
+	case RateLimitKeyHeader:
+		if ctx, err := getFastHttpCtx(call); err == nil {
+			if value := ctx.Request.Header.Peek(m.options.Header); len(value) != 0 {
+				return string(value)
+			}
+		}
+	case RateLimitKeyFunction:
+		m.mutex.Lock()
+		keyFunction := m.keyFunction
+		m.mutex.Unlock()
+
+		// The key function runs before the error handling of the route,
+		// so his errors and timeouts aren't reported but replaced by the ip.
+		if keyFunction != nil {
+			if key, err := keyFunction(call); err == nil {
+				return key
+			}
+		}
+	}
+
+	return call.RemoteIP()
+}
+
+// releaseKeyFunction drops the javascript key function once the limiter is disposed.
+// The requests still using the limiter are then keyed by their ip.
+func (m *jsRateLimiter) releaseKeyFunction() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.keyFunction = nil
+}
+
+// getIdleDuration returns the time after which the state of a client is the same as a new one.
+func (m *jsRateLimiter) getIdleDuration() time.Duration {
+	if m.options.Algorithm == RateLimitSlidingWindow {
+		return 2 * m.window
+	}
+
+	// The time to fill the bucket.
+	return time.Duration(float64(m.window) * float64(m.options.Burst) / float64(m.options.Limit))
+}
+
+// sweep removes the states of the idle clients, which keeps the memory bounded.
+// The mutex must be locked.
+func (m *jsRateLimiter) sweep(now time.Time) {
+	idleDuration := m.getIdleDuration()
+
+	if now.Sub(m.lastSweep) < max(idleDuration, time.Second) {
+		return
+	}
+
+	m.lastSweep = now
+
+	for key, state := range m.states {
+		if now.Sub(state.lastRequest) > idleDuration {
+			delete(m.states, key)
+		}
+	}
+}
+
+// allow counts a request of this client.
+// Returns false, and the time to wait before the next allowed request, if the limit is reached.
+func (m *jsRateLimiter) allow(key string) (bool, time.Duration) {
+	now := time.Now()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.sweep(now)
+
+	state := m.states[key]
+
+	if state == nil {
+		state = &rateLimitState{tokens: float64(m.options.Burst), windowStart: now}
+		m.states[key] = state
+	}
+
+	if m.options.Algorithm == RateLimitSlidingWindow {
+		return m.allowSlidingWindow(state, now)
+	}
+
+	return m.allowTokenBucket(state, now)
+}
+
+func (m *jsRateLimiter) allowTokenBucket(state *rateLimitState, now time.Time) (bool, time.Duration) {
+	rate := float64(m.options.Limit) / float64(m.window)
+
+	if !state.lastRequest.IsZero() {
+		state.tokens = math.Min(float64(m.options.Burst), state.tokens+float64(now.Sub(state.lastRequest))*rate)
+	}
+
+	state.lastRequest = now
+
+	if state.tokens >= 1 {
+		state.tokens--
+		return true, 0
+	}
+
+	// Rounded up, so that a request sent after this wait is allowed.
+	return false, time.Duration(math.Ceil((1 - state.tokens) / rate))
+}
+
+// allowSlidingWindow approximates the count of the sliding window from the counts
+// of the current and of the previous fixed windows, which avoids storing each request.
+func (m *jsRateLimiter) allowSlidingWindow(state *rateLimitState, now time.Time) (bool, time.Duration) {
+	elapsedWindows := int64(now.Sub(state.windowStart) / m.window)
+
+	if elapsedWindows == 1 {
+		state.previousCount = state.currentCount
+	} else if elapsedWindows > 1 {
+		state.previousCount = 0
+	}
+
+	if elapsedWindows >= 1 {
+		state.currentCount = 0
+		state.windowStart = state.windowStart.Add(time.Duration(elapsedWindows) * m.window)
+	}
+
+	state.lastRequest = now
+
+	elapsed := now.Sub(state.windowStart)
+	previousWeight := 1 - float64(elapsed)/float64(m.window)
+	limit := float64(m.options.Limit)
+
+	if state.previousCount*previousWeight+state.currentCount+1 <= limit {
+		state.currentCount++
+		return true, 0
+	}
+
+	// Wait until the part of the previous window still counted is small enough.
+	if state.currentCount+1 <= limit {
+		wait := float64(m.window)*(1-(limit-state.currentCount-1)/state.previousCount) - float64(elapsed)
+		return false, time.Duration(wait)
+	}
+
+	// The current window is full, it will be the previous one of the next window.
+	wait := float64(m.window-elapsed) + float64(m.window)*(1-(limit-1)/state.currentCount)
+	return false, time.Duration(wait)
+}
+
+// getRetryAfterSeconds returns the value of the Retry-After header, rounded up to the next second.
+func getRetryAfterSeconds(retryAfter time.Duration) int {
+	return max(int(math.Ceil(retryAfter.Seconds())), 1)
+}
+
+// returnTooManyRequests sends a 429 response, telling when to retry.
+func returnTooManyRequests(call httpServer.HttpRequest, retryAfter time.Duration) {
+	call.SetHeader("Retry-After", strconv.Itoa(getRetryAfterSeconds(retryAfter)))
+	call.SetContentType("text/plain")
+	call.ReturnString(429, "Too Many Requests")
+}
+
+// checkRateLimits applies the limiters matching the path of the request.
+// Returns false if a limit is reached, in which case a 429 response has been sent.
+func (m *jsHostSettings) checkRateLimits(call httpServer.HttpRequest) bool {
+	m.rateLimitersMutex.RLock()
+	limiters := m.rateLimiters
+	m.rateLimitersMutex.RUnlock()
+
+	path := call.Path()
+
+	for _, limiter := range limiters {
+		if !limiter.isMatchingPath(path) {
+			continue
+		}
+
+		key := limiter.getKey(call)
+		if key == "" {
+			continue
+		}
+
+		if ok, retryAfter := limiter.allow(key); !ok {
+			returnTooManyRequests(call, retryAfter)
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m *jsHostSettings) addRateLimiter(limiter *jsRateLimiter) {
+	m.rateLimitersMutex.Lock()
+	defer m.rateLimitersMutex.Unlock()
+
+	// A copy allows reading the list without keeping the lock.
+	m.rateLimiters = append(append([]*jsRateLimiter{}, m.rateLimiters...), limiter)
+}
+
+func (m *jsHostSettings) removeRateLimiter(limiter *jsRateLimiter) {
+	m.rateLimitersMutex.Lock()
+	defer m.rateLimitersMutex.Unlock()
+
+	var limiters []*jsRateLimiter
+
+	for _, l := range m.rateLimiters {
+		if l != limiter {
+			limiters = append(limiters, l)
+		}
+	}
+
+	m.rateLimiters = limiters
+}
+
+// acquireIpSlot counts a request being processed for this ip. Returns false if the max is reached.
+// isCounted is true if releaseIpSlot must be called once the request is processed,
+// which isn't the case when there is no max.
+func (m *jsServerSettings) acquireIpSlot(ip string) (ok bool, isCounted bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.maxRequestsPerIp <= 0 {
+		return true, false
+	}
+
+	if m.activeRequestsByIp[ip] >= m.maxRequestsPerIp {
+		return false, false
+	}
+
+	m.activeRequestsByIp[ip]++
+	return true, true
+}
+
+func (m *jsServerSettings) releaseIpSlot(ip string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if count := m.activeRequestsByIp[ip]; count > 1 {
+		m.activeRequestsByIp[ip] = count - 1
+	} else {
+		delete(m.activeRequestsByIp, ip)
+	}
+}
+
+// withRateLimits returns a handler applying the per-ip cap of the server
+// and the rate limiters of the host before calling the handler.
+func withRateLimits(settings *jsHostSettings, handler httpServer.HttpMiddleware) httpServer.HttpMiddleware {
+	return func(call httpServer.HttpRequest) error {
+		ip := call.RemoteIP()
+
+		ok, isCounted := settings.server.acquireIpSlot(ip)
+
+		if !ok {
+			returnTooManyRequests(call, time.Second)
+			return nil
+		}
+
+		if isCounted {
+			defer settings.server.releaseIpSlot(ip)
+		}
+
+		if !settings.checkRateLimits(call) {
+			return nil
+		}
+
+		return handler(call)
+	}
+}
+
+func addRateLimiter(resHost *progpAPI.SharedResource, limiter *jsRateLimiter) (*progpAPI.SharedResource, error) {
+	host, ok := resHost.Value.(*httpServer.HttpHost)
+	if !ok {
+		return nil, errors.New("invalid resource")
+	}
+
+	settings := getHostSettings(host)
+	settings.addRateLimiter(limiter)
+
+	return resHost.GetContainer().NewSharedResource(limiter, func(_ any) {
+		settings.removeRateLimiter(limiter)
+
+		// The javascript key function is kept alive, the limiter must not reference it anymore.
+		limiter.releaseKeyFunction()
+	}), nil
+}
+
+// JsHostAddRateLimit limits the number of requests of each client, for the paths of this host starting with pathPrefix.
+// When the limit is reached, a 429 response is returned. Disposing the returned resource removes the limiter.
+func JsHostAddRateLimit(resHost *progpAPI.SharedResource, pathPrefix string, options JsRateLimitOptions) (*progpAPI.SharedResource, error) {
+	if options.KeyBy == RateLimitKeyFunction {
+		return nil, errors.New("a key function is required")
+	}
+
+	limiter, err := newRateLimiter(pathPrefix, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return addRateLimiter(resHost, limiter)
+}
+
+// JsHostAddRateLimitWithKey is like JsHostAddRateLimit, but the clients are identified by
+// the key returned by a javascript function. An empty key means that the request isn't limited.
+func JsHostAddRateLimitWithKey(rc *progpAPI.SharedResourceContainer, resHost *progpAPI.SharedResource, pathPrefix string, options JsRateLimitOptions, keyFunction progpAPI.JsFunction) (*progpAPI.SharedResource, error) {
+	options.KeyBy = RateLimitKeyFunction
+
+	limiter, err := newRateLimiter(pathPrefix, options)
+	if err != nil {
+		return nil, err
+	}
+
+	keyFunction.KeepAlive()
+
+	limiter.keyFunction = func(call httpServer.HttpRequest) (string, error) {
+		info := make(map[string]any)
+
+		info["method"] = call.GetMethodName()
+		info["uri"] = call.FullURI()
+		info["path"] = call.Path()
+		info["ip"] = call.RemoteIP()
+		info["hostname"] = call.GetHost().GetHostName()
+		info["headers"] = call.GetHeaders()
+
+		b, err := json.Marshal(info)
+		if err != nil {
+			return "", err
+		}
+
+		return callJsFunctionAndWait(rc, keyFunction, b, RateLimitKeyFunctionTimeout)
+	}
+
+	return addRateLimiter(resHost, limiter)
+}
@@ -0,0 +1,188 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"github.com/progpjs/httpServer/v2"
+	"testing"
+	"time"
+)
+
+func mustRateLimiter(t *testing.T, options JsRateLimitOptions) *jsRateLimiter {
+	t.Helper()
+
+	limiter, err := newRateLimiter("", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return limiter
+}
+
+type rateLimitStep struct {
+	at        time.Duration
+	allowed   bool
+	retryWait time.Duration
+}
+
+func runRateLimitSteps(t *testing.T, limiter *jsRateLimiter, steps []rateLimitStep) {
+	t.Helper()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	state := &rateLimitState{tokens: float64(limiter.options.Burst), windowStart: start}
+
+	for i, step := range steps {
+		var allowed bool
+		var wait time.Duration
+
+		if limiter.options.Algorithm == RateLimitSlidingWindow {
+			allowed, wait = limiter.allowSlidingWindow(state, start.Add(step.at))
+		} else {
+			allowed, wait = limiter.allowTokenBucket(state, start.Add(step.at))
+		}
+
+		if (allowed != step.allowed) || (wait != step.retryWait) {
+			t.Errorf("step %d at %v: got (%v, %v), expected (%v, %v)", i, step.at, allowed, wait, step.allowed, step.retryWait)
+		}
+	}
+}
+
+func TestRateLimitTokenBucket(t *testing.T) {
+	tests := []struct {
+		name    string
+		options JsRateLimitOptions
+		steps   []rateLimitStep
+	}{
+		{
+			name:    "burst then refill",
+			options: JsRateLimitOptions{Limit: 2, Window: 1000},
+			steps: []rateLimitStep{
+				{0, true, 0},
+				{0, true, 0},
+				{0, false, 500 * time.Millisecond},
+				{250 * time.Millisecond, false, 250 * time.Millisecond},
+				{500 * time.Millisecond, true, 0},
+				{500 * time.Millisecond, false, 500 * time.Millisecond},
+			},
+		},
+		{
+			name:    "tokens capped to the burst",
+			options: JsRateLimitOptions{Limit: 10, Window: 1000, Burst: 2},
+			steps: []rateLimitStep{
+				{0, true, 0},
+				{10 * time.Second, true, 0},
+				{10 * time.Second, true, 0},
+				{10 * time.Second, false, 100 * time.Millisecond},
+			},
+		},
+		{
+			name:    "burst larger than the limit",
+			options: JsRateLimitOptions{Limit: 1, Window: 1000, Burst: 3},
+			steps: []rateLimitStep{
+				{0, true, 0},
+				{0, true, 0},
+				{0, true, 0},
+				{0, false, time.Second},
+				{time.Second, true, 0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runRateLimitSteps(t, mustRateLimiter(t, tt.options), tt.steps)
+		})
+	}
+}
+
+func TestRateLimitSlidingWindow(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []rateLimitStep
+	}{
+		{
+			name: "current window full",
+			steps: []rateLimitStep{
+				{0, true, 0},
+				{100 * time.Millisecond, true, 0},
+				// Waits for the next window, where the previous one still counts for half.
+				{200 * time.Millisecond, false, 1300 * time.Millisecond},
+				{1499 * time.Millisecond, false, time.Millisecond},
+				{1500 * time.Millisecond, true, 0},
+			},
+		},
+		{
+			name: "previous window weight decreases",
+			steps: []rateLimitStep{
+				{0, true, 0},
+				{0, true, 0},
+				{1000 * time.Millisecond, false, 500 * time.Millisecond},
+				{1250 * time.Millisecond, false, 250 * time.Millisecond},
+				{1500 * time.Millisecond, true, 0},
+			},
+		},
+		{
+			name: "previous window forgotten after an idle window",
+			steps: []rateLimitStep{
+				{0, true, 0},
+				{0, true, 0},
+				{2000 * time.Millisecond, true, 0},
+				{2000 * time.Millisecond, true, 0},
+				{2000 * time.Millisecond, false, 1500 * time.Millisecond},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := mustRateLimiter(t, JsRateLimitOptions{Algorithm: RateLimitSlidingWindow, Limit: 2, Window: 1000})
+			runRateLimitSteps(t, limiter, tt.steps)
+		})
+	}
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	tests := []struct {
+		retryAfter time.Duration
+		expected   int
+	}{
+		{0, 1},
+		{time.Millisecond, 1},
+		{time.Second, 1},
+		{1300 * time.Millisecond, 2},
+		{2 * time.Second, 2},
+		{2001 * time.Millisecond, 3},
+		{time.Minute, 60},
+	}
+
+	for _, tt := range tests {
+		if got := getRetryAfterSeconds(tt.retryAfter); got != tt.expected {
+			t.Errorf("%v: got %d, expected %d", tt.retryAfter, got, tt.expected)
+		}
+	}
+}
+
+func TestRateLimitReleaseKeyFunction(t *testing.T) {
+	limiter := mustRateLimiter(t, JsRateLimitOptions{Limit: 1, KeyBy: RateLimitKeyFunction})
+	limiter.keyFunction = func(call httpServer.HttpRequest) (string, error) { return "key", nil }
+
+	limiter.releaseKeyFunction()
+
+	if limiter.keyFunction != nil {
+		t.Error("the key function must be released")
+	}
+}
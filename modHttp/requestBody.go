@@ -0,0 +1,100 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"bytes"
+	"errors"
+	"github.com/progpjs/httpServer/v2"
+	"github.com/progpjs/progpAPI/v2"
+)
+
+var RequestBodyTooLargeError = errors.New("request body too large")
+
+// isRequestBodyTooLarge returns true if the size exceeds the limit set for this host.
+func isRequestBodyTooLarge(call httpServer.HttpRequest, size int) bool {
+	maxSize := getHostSettings(call.GetHost()).maxRequestBodySize.Load()
+	return (maxSize > 0) && (int64(size) > maxSize)
+}
+
+func returnRequestBodyTooLarge(call httpServer.HttpRequest) {
+	if !call.IsBodySend() {
+		call.SetContentType("text/plain")
+		call.ReturnString(413, "Request Entity Too Large")
+	}
+}
+
+// readRequestBody returns the raw body of the request.
+// If the body exceeds the max size, then a 413 response is sent.
+func readRequestBody(req *jsHttpRequest) ([]byte, error) {
+	ctx, err := getFastHttpCtx(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// The content length is checked before calling the handler, but
+	// it's unknown when the body is sent with chunked transfer encoding.
+	body := ctx.PostBody()
+
+	if isRequestBodyTooLarge(req, len(body)) {
+		returnRequestBodyTooLarge(req)
+		return nil, RequestBodyTooLargeError
+	}
+
+	return body, nil
+}
+
+func JsRequestBodyAsString(resHttpRequest *progpAPI.SharedResource) (error, string) {
+	req, err := getJsHttpRequest(resHttpRequest)
+	if err != nil {
+		return err, ""
+	}
+
+	body, err := readRequestBody(req)
+	if err != nil {
+		return err, ""
+	}
+
+	return nil, string(body)
+}
+
+func JsRequestBodyAsArrayBuffer(resHttpRequest *progpAPI.SharedResource) (error, []byte) {
+	req, err := getJsHttpRequest(resHttpRequest)
+	if err != nil {
+		return err, nil
+	}
+
+	body, err := readRequestBody(req)
+	if err != nil {
+		return err, nil
+	}
+
+	// The body memory is reused by fasthttp once the request ends.
+	return nil, bytes.Clone(body)
+}
+
+// JsHostSetMaxRequestBodySize set the max body size, in bytes, accepted by the handlers of this host.
+// A value less or equal to zero means no limit.
+func JsHostSetMaxRequestBodySize(resHost *progpAPI.SharedResource, maxSize int) error {
+	host, ok := resHost.Value.(*httpServer.HttpHost)
+	if !ok {
+		return errors.New("invalid resource")
+	}
+
+	getHostSettings(host).maxRequestBodySize.Store(int64(maxSize))
+	return nil
+}
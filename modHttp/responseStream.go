@@ -0,0 +1,294 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"bufio"
+	"errors"
+	"github.com/progpjs/progpAPI/v2"
+	"sync"
+)
+
+var StreamClosedError = errors.New("stream closed")
+var StreamFullError = errors.New("stream buffer full")
+
+// jsResponseStream sends a response body chunk by chunk.
+// Since no content length is known, fasthttp uses chunked transfer encoding.
+type jsResponseStream struct {
+	chunks chan []byte
+
+	// closed is closed by close, the chunks channel itself is never closed
+	// which allows writing without holding a lock.
+	closed    chan bool
+	closeOnce sync.Once
+
+	// writerDone is closed once the writer exit, which occurs
+	// when all the chunks are sent or when the client is gone.
+	writerDone chan bool
+
+	// drained is closed, then replaced, each time the writer takes a chunk from the buffer.
+	// It allows waiting for the buffer to have space again.
+	drained      chan bool
+	drainedMutex sync.Mutex
+}
+
+// write adds a chunk to the buffer of the stream, without waiting.
+// If the client is too slow and the buffer is full, StreamFullError is returned,
+// since blocking here would block the javascript thread.
+func (m *jsResponseStream) write(chunk []byte) error {
+	select {
+	case <-m.closed:
+		return StreamClosedError
+	case <-m.writerDone:
+		return StreamClosedError
+	default:
+	}
+
+	select {
+	case m.chunks <- chunk:
+		return nil
+	default:
+		return StreamFullError
+	}
+}
+
+// getDrained returns the channel closed once the writer takes the next chunk.
+func (m *jsResponseStream) getDrained() chan bool {
+	m.drainedMutex.Lock()
+	defer m.drainedMutex.Unlock()
+
+	if m.drained == nil {
+		m.drained = make(chan bool)
+	}
+
+	return m.drained
+}
+
+// notifyDrained wakes up the goroutines waiting for space in the buffer.
+func (m *jsResponseStream) notifyDrained() {
+	m.drainedMutex.Lock()
+	defer m.drainedMutex.Unlock()
+
+	if m.drained != nil {
+		close(m.drained)
+		m.drained = nil
+	}
+}
+
+// waitDrain blocks until the buffer has space for a chunk.
+// Returns StreamClosedError if the stream ends before.
+func (m *jsResponseStream) waitDrain() error {
+	for {
+		// Taken before checking the size, so that no notification is missed.
+		drained := m.getDrained()
+
+		if len(m.chunks) < cap(m.chunks) {
+			return nil
+		}
+
+		select {
+		case <-drained:
+		case <-m.closed:
+			return StreamClosedError
+		case <-m.writerDone:
+			return StreamClosedError
+		}
+	}
+}
+
+// writeFromJs writes a chunk for javascript, which receives false if the buffer is full.
+func (m *jsResponseStream) writeFromJs(chunk []byte) (error, bool) {
+	err := m.write(chunk)
+
+	if err == StreamFullError {
+		return nil, false
+	}
+
+	return err, err == nil
+}
+
+func (m *jsResponseStream) close() {
+	m.closeOnce.Do(func() { close(m.closed) })
+}
+
+// streamWriter is executed by fasthttp once the handler has returned.
+func (m *jsResponseStream) streamWriter(req *jsHttpRequest) func(w *bufio.Writer) {
+	return func(w *bufio.Writer) {
+		defer req.release()
+		defer close(m.writerDone)
+
+		for {
+			select {
+			case chunk := <-m.chunks:
+				m.notifyDrained()
+
+				if !writeChunk(w, chunk) {
+					return
+				}
+			case <-m.closed:
+				// Sends the chunks written before closing.
+				for {
+					select {
+					case chunk := <-m.chunks:
+						if !writeChunk(w, chunk) {
+							return
+						}
+					default:
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// writeChunk writes a chunk to the client and returns false if the client is gone.
+func writeChunk(w *bufio.Writer, chunk []byte) bool {
+	if _, err := w.Write(chunk); err != nil {
+		return false
+	}
+
+	// Flushing after each chunk allows the client to receive it immediately.
+	return w.Flush() == nil
+}
+
+// beginResponseStream sends the status code and the headers, then allows sending the body chunk by chunk.
+func beginResponseStream(req *jsHttpRequest, responseCode int, contentType string) (*jsResponseStream, error) {
+	if req.IsBodySend() {
+		return nil, ResponseAlreadySentError
+	}
+
+	ctx, err := getFastHttpCtx(req)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := &jsResponseStream{
+		chunks:     make(chan []byte, 16),
+		closed:     make(chan bool),
+		writerDone: make(chan bool),
+	}
+
+	// Once sent, the handler is unlocked and fasthttp calls the stream writer.
+	err = req.sendResponse(func() error {
+		ctx.SetStatusCode(responseCode)
+		ctx.SetContentType(contentType)
+
+		// The resource must stay alive until the stream ends.
+		req.acquire()
+		req.stream = stream
+
+		ctx.SetBodyStreamWriter(stream.streamWriter(req))
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return stream, nil
+}
+
+// writeResponseStream writes a chunk into the response stream of the request, for javascript.
+// Like the other writes, it fails once an error response has been sent in place of the handler.
+func writeResponseStream(resHttpRequest *progpAPI.SharedResource, chunk []byte) (error, bool) {
+	stream, err := getResponseStream(resHttpRequest)
+	if err != nil {
+		return err, false
+	}
+
+	req := resHttpRequest.Value.(*jsHttpRequest)
+	isWritten := false
+
+	err = req.modifyResponse(func() error {
+		var err error
+		err, isWritten = stream.writeFromJs(chunk)
+		return err
+	})
+
+	return err, isWritten
+}
+
+func getResponseStream(resHttpRequest *progpAPI.SharedResource) (*jsResponseStream, error) {
+	req, err := getJsHttpRequest(resHttpRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.stream == nil {
+		return nil, errors.New("response stream not started")
+	}
+
+	return req.stream, nil
+}
+
+// JsResponseStreamBegin starts a streamed response.
+// The headers must be set before calling this function.
+func JsResponseStreamBegin(resHttpRequest *progpAPI.SharedResource, responseCode int, contentType string) error {
+	req, err := getJsHttpRequest(resHttpRequest)
+	if err != nil {
+		return err
+	}
+
+	_, err = beginResponseStream(req, responseCode, contentType)
+	return err
+}
+
+// JsResponseStreamWriteString sends a text chunk.
+// Returns false if the client is too slow to consume the previous chunks, in which case the chunk isn't sent.
+func JsResponseStreamWriteString(resHttpRequest *progpAPI.SharedResource, chunk string) (error, bool) {
+	return writeResponseStream(resHttpRequest, []byte(chunk))
+}
+
+// JsResponseStreamWriteBytes sends a binary chunk.
+func JsResponseStreamWriteBytes(resHttpRequest *progpAPI.SharedResource, chunk []byte) (error, bool) {
+	// The buffer memory is owned by javascript, it must be copied.
+	b := make([]byte, len(chunk))
+	copy(b, chunk)
+
+	return writeResponseStream(resHttpRequest, b)
+}
+
+// JsResponseStreamWaitDrainAsync calls the callback once the buffer of the stream has space,
+// which allows writing again after a write has returned false.
+func JsResponseStreamWaitDrainAsync(resHttpRequest *progpAPI.SharedResource, callback progpAPI.JsFunction) {
+	stream, err := getResponseStream(resHttpRequest)
+	if err != nil {
+		callback.CallWithError(err)
+		return
+	}
+
+	progpAPI.SafeGoRoutine(func() {
+		if err := stream.waitDrain(); err != nil {
+			callback.CallWithError(err)
+			return
+		}
+
+		callback.CallWithUndefined()
+	})
+}
+
+// JsResponseStreamEnd ends the streamed response.
+func JsResponseStreamEnd(resHttpRequest *progpAPI.SharedResource) error {
+	stream, err := getResponseStream(resHttpRequest)
+	if err != nil {
+		return err
+	}
+
+	stream.close()
+	return nil
+}
@@ -0,0 +1,96 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestResponseStream(t *testing.T) {
+	stream := &jsResponseStream{
+		chunks:     make(chan []byte, 2),
+		closed:     make(chan bool),
+		writerDone: make(chan bool),
+	}
+
+	_ = stream.write([]byte("a"))
+	_ = stream.write([]byte("b"))
+
+	if err := stream.write([]byte("c")); !errors.Is(err, StreamFullError) {
+		t.Fatalf("expected StreamFullError, got %v", err)
+	}
+
+	stream.close()
+	stream.close()
+
+	if err := stream.write([]byte("d")); !errors.Is(err, StreamClosedError) {
+		t.Fatalf("expected StreamClosedError, got %v", err)
+	}
+
+	// The chunks written before closing are still sent.
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+
+	stream.streamWriter(&jsHttpRequest{})(w)
+
+	if out.String() != "ab" {
+		t.Fatalf("got %q", out.String())
+	}
+}
+
+func TestResponseStreamWaitDrain(t *testing.T) {
+	stream := &jsResponseStream{
+		chunks:     make(chan []byte, 1),
+		closed:     make(chan bool),
+		writerDone: make(chan bool),
+	}
+
+	if err := stream.waitDrain(); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = stream.write([]byte("a"))
+
+	result := make(chan error)
+	go func() { result <- stream.waitDrain() }()
+
+	select {
+	case err := <-result:
+		t.Fatalf("returned while the buffer is full: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Like the writer does when taking a chunk.
+	<-stream.chunks
+	stream.notifyDrained()
+
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+
+	_ = stream.write([]byte("b"))
+	go func() { result <- stream.waitDrain() }()
+	stream.close()
+
+	if err := <-result; !errors.Is(err, StreamClosedError) {
+		t.Fatalf("expected StreamClosedError, got %v", err)
+	}
+}
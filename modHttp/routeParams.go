@@ -0,0 +1,195 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"errors"
+	"github.com/progpjs/progpAPI/v2"
+	"regexp"
+	"strings"
+)
+
+var gRouteParamConstraints = map[string]*regexp.Regexp{
+	"int":  regexp.MustCompile(`^-?[0-9]+$`),
+	"uuid": regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`),
+}
+
+type routeParam struct {
+	name          string
+	segmentOffset int
+	constraint    *regexp.Regexp
+}
+
+// routePattern is a route path containing named params, like "/users/:userId/posts/:postId".
+// A param can have a constraint, which is "int", "uuid" or a regular expression, like "/users/:userId(int)".
+type routePattern struct {
+	// resolverPath is the path given to the url resolver, where the params are replaced by wildcards.
+	resolverPath string
+	segmentCount int
+	params       []routeParam
+
+	// hasCatchAll is true if the path ends by "/*", which matches all the sub-paths.
+	hasCatchAll bool
+}
+
+// parseRoutePattern parse a route path. Returns nil if it doesn't contain named params.
+func parseRoutePattern(requestPath string) (*routePattern, error) {
+	if !strings.Contains(requestPath, "/:") {
+		return nil, nil
+	}
+
+	if err := checkRouteConstraints(requestPath); err != nil {
+		return nil, err
+	}
+
+	segments := splitPath(requestPath)
+	res := &routePattern{segmentCount: len(segments)}
+	res.hasCatchAll = (len(segments) != 0) && (segments[len(segments)-1] == "*")
+
+	for offset, segment := range segments {
+		if !strings.HasPrefix(segment, ":") {
+			continue
+		}
+
+		param := routeParam{name: segment[1:], segmentOffset: offset}
+
+		if idx := strings.Index(param.name, "("); idx != -1 {
+			if !strings.HasSuffix(param.name, ")") {
+				return nil, errors.New("invalid route param " + segment)
+			}
+
+			constraint := param.name[idx+1 : len(param.name)-1]
+			param.name = param.name[:idx]
+
+			param.constraint = gRouteParamConstraints[constraint]
+
+			if param.constraint == nil {
+				re, err := regexp.Compile("^(?:" + constraint + ")$")
+				if err != nil {
+					return nil, err
+				}
+
+				param.constraint = re
+			}
+		}
+
+		if param.name == "" {
+			return nil, errors.New("invalid route param " + segment)
+		}
+
+		segments[offset] = "*"
+		res.params = append(res.params, param)
+	}
+
+	res.resolverPath = "/" + strings.Join(segments, "/")
+	return res, nil
+}
+
+// checkRouteConstraints returns an error if a constraint contains a "/",
+// which isn't supported since a param is only one segment of the path.
+func checkRouteConstraints(requestPath string) error {
+	depth := 0
+
+	for i := 0; i < len(requestPath); i++ {
+		switch requestPath[i] {
+		case '\\':
+			// Skip the escaped char.
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '/':
+			if depth > 0 {
+				return errors.New("the constraints of the route params can't contain '/': " + requestPath)
+			}
+		}
+	}
+
+	return nil
+}
+
+// claimResolverPath returns an error if another route with params is bound to the same resolver path for this verb.
+// The url resolver can't distinguish them, so the last one would silently replace the previous one,
+// for example "/users/:id(int)" and "/users/:name" which are both "/users/*".
+func (m *jsHostSettings) claimResolverPath(verb string, pattern *routePattern, route string) error {
+	m.routesMutex.Lock()
+	defer m.routesMutex.Unlock()
+
+	routes := m.patternRoutes[pattern.resolverPath]
+
+	if routes == nil {
+		routes = make(map[string]string)
+		m.patternRoutes[pattern.resolverPath] = routes
+	}
+
+	for otherVerb, otherRoute := range routes {
+		if otherRoute == route {
+			continue
+		}
+
+		if (otherVerb == verb) || (otherVerb == AllVerbs) || (verb == AllVerbs) {
+			return errors.New("the route " + route + " conflicts with the route " + otherRoute)
+		}
+	}
+
+	routes[verb] = route
+	return nil
+}
+
+// extract returns the values of the params for this path.
+// Returns false if the path doesn't match the constraints.
+func (m *routePattern) extract(path string) (map[string]string, bool) {
+	segments := splitPath(path)
+
+	// A final wildcard matches all the sub-paths, while a param is only one segment.
+	if m.hasCatchAll {
+		if len(segments) < m.segmentCount-1 {
+			return nil, false
+		}
+	} else if len(segments) != m.segmentCount {
+		return nil, false
+	}
+
+	res := make(map[string]string, len(m.params))
+
+	for _, param := range m.params {
+		value := segments[param.segmentOffset]
+
+		if (value == "") || ((param.constraint != nil) && !param.constraint.MatchString(value)) {
+			return nil, false
+		}
+
+		res[param.name] = value
+	}
+
+	return res, true
+}
+
+// JsRequestParams returns the values of the named params of the route.
+func JsRequestParams(resHttpRequest *progpAPI.SharedResource) (error, map[string]string) {
+	req, err := getJsHttpRequest(resHttpRequest)
+	if err != nil {
+		return err, nil
+	}
+
+	if req.params == nil {
+		return nil, map[string]string{}
+	}
+
+	return nil, req.params
+}
@@ -0,0 +1,157 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRoutePattern(t *testing.T) {
+	tests := []struct {
+		path         string
+		resolverPath string
+		params       []string
+		hasCatchAll  bool
+	}{
+		{"/users/:id", "/users/*", []string{"id"}, false},
+		{"/users/:id(int)/posts/:postId(uuid)", "/users/*/posts/*", []string{"id", "postId"}, false},
+		{"/items/:code([a-z]{3})", "/items/*", []string{"code"}, false},
+		{"/files/:bucket/*", "/files/*/*", []string{"bucket"}, true},
+	}
+
+	for _, test := range tests {
+		pattern, err := parseRoutePattern(test.path)
+		if err != nil {
+			t.Fatalf("%s: %s", test.path, err)
+		}
+
+		var names []string
+
+		for _, param := range pattern.params {
+			names = append(names, param.name)
+		}
+
+		if (pattern.resolverPath != test.resolverPath) || !reflect.DeepEqual(names, test.params) || (pattern.hasCatchAll != test.hasCatchAll) {
+			t.Fatalf("%s: got %s %v %t", test.path, pattern.resolverPath, names, pattern.hasCatchAll)
+		}
+	}
+
+	// Without params, the route is handled by the url resolver alone.
+	if pattern, err := parseRoutePattern("/users/*"); (pattern != nil) || (err != nil) {
+		t.Fatal("a route without params must give no pattern")
+	}
+}
+
+func TestParseRoutePatternErrors(t *testing.T) {
+	for _, path := range []string{
+		"/users/:(int)",
+		"/users/:id(int",
+		"/users/:id([a-z/]+)",
+		"/users/:id((a|b)/c)",
+		"/users/:id([)",
+	} {
+		if _, err := parseRoutePattern(path); err == nil {
+			t.Fatalf("%s: expected an error", path)
+		}
+	}
+
+	// An escaped parenthesis doesn't open a constraint.
+	if err := checkRouteConstraints(`/users/:id(\(a)/b`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoutePatternExtract(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		path     string
+		expected map[string]string
+	}{
+		{"/users/:id(int)", "/users/42", map[string]string{"id": "42"}},
+		{"/users/:id(int)", "/users/-1", map[string]string{"id": "-1"}},
+		{"/users/:id(int)", "/users/abc", nil},
+		{"/users/:id(int)", "/users/42/posts", nil},
+		{"/users/:id", "/users/", nil},
+		{"/users/:id(uuid)", "/users/0f8fad5b-d9cb-469f-a165-70867728950e", map[string]string{"id": "0f8fad5b-d9cb-469f-a165-70867728950e"}},
+		{"/users/:id(uuid)", "/users/0f8fad5b", nil},
+
+		// The regular expressions must match the whole segment.
+		{"/items/:code([a-z]{3})", "/items/abc", map[string]string{"code": "abc"}},
+		{"/items/:code([a-z]{3})", "/items/abcd", nil},
+
+		// The final wildcard matches the sub-paths, and the path itself.
+		{"/files/:bucket/*", "/files/b1", map[string]string{"bucket": "b1"}},
+		{"/files/:bucket/*", "/files/b1/a/b/c", map[string]string{"bucket": "b1"}},
+		{"/files/:bucket/*", "/files", nil},
+		{"/files/:bucket(int)/*", "/files/b1/a", nil},
+	}
+
+	for _, test := range tests {
+		pattern, err := parseRoutePattern(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		params, ok := pattern.extract(test.path)
+
+		if ok != (test.expected != nil) {
+			t.Fatalf("%s %s: expected matching %t", test.pattern, test.path, test.expected != nil)
+		}
+
+		if ok && !reflect.DeepEqual(params, test.expected) {
+			t.Fatalf("%s %s: got %v", test.pattern, test.path, params)
+		}
+	}
+}
+
+func TestClaimResolverPath(t *testing.T) {
+	settings := &jsHostSettings{patternRoutes: make(map[string]map[string]string)}
+
+	tests := []struct {
+		verb       string
+		route      string
+		isConflict bool
+	}{
+		{"GET", "/users/:id(int)", false},
+
+		// Registering the same route again isn't a conflict.
+		{"GET", "/users/:id(int)", false},
+
+		// Both are "/users/*" for the url resolver.
+		{"GET", "/users/:name", true},
+		{"POST", "/users/:name", false},
+		{AllVerbs, "/users/:slug", true},
+
+		{AllVerbs, "/posts/:id", false},
+		{"DELETE", "/posts/:slug", true},
+		{"GET", "/users/:id/posts", false},
+	}
+
+	for _, test := range tests {
+		pattern, err := parseRoutePattern(test.route)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = settings.claimResolverPath(test.verb, pattern, test.route)
+
+		if (err != nil) != test.isConflict {
+			t.Fatalf("%s %s: expected conflict %t, got %v", test.verb, test.route, test.isConflict, err)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"github.com/progpjs/httpServer/v2"
+	"sort"
+	"strings"
+)
+
+// AllVerbs is the verb used for the routes which accept all the verbs.
+const AllVerbs = "*"
+
+// gStandardVerbs are the verbs for which an automatic response is sent when
+// a path is known but not the verb. OPTIONS returns the list of allowed verbs
+// while the others return a "405 Method Not Allowed" response.
+var gStandardVerbs = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// registerRoute bind a handler to a path, and register the automatic
+// handlers for the verbs which aren't bound to this path.
+func registerRoute(host *httpServer.HttpHost, verb string, requestPath string, handler httpServer.HttpMiddleware) {
+	settings := getHostSettings(host)
+	verb = strings.ToUpper(verb)
+
+	settings.routesMutex.Lock()
+	defer settings.routesMutex.Unlock()
+
+	verbs := settings.routes[requestPath]
+
+	if verbs == nil {
+		verbs = make(map[string]httpServer.HttpMiddleware)
+		settings.routes[requestPath] = verbs
+	}
+
+	verbs[verb] = handler
+
+	if strings.Contains(requestPath, "*") {
+		settings.addWildcardRoute(verb, requestPath, handler)
+	}
+
+	// The routes map keeps the handler without the host layers,
+	// since the automatic handlers call them from inside their own layers.
+	if verb == AllVerbs {
+		host.AllVerbs(requestPath, withHostLayers(settings, requestPath, handler))
+		return
+	}
+
+	host.VERB(verb, requestPath, withHostLayers(settings, requestPath, handler))
+
+	if verbs[AllVerbs] != nil {
+		return
+	}
+
+	// The url resolver replaces the previous handler, which allows
+	// the automatic handlers to be replaced by a real one later.
+	//
+	for _, other := range gStandardVerbs {
+		if verbs[other] == nil {
+			host.VERB(other, requestPath, withHostLayers(settings, requestPath, buildAutoVerbHandler(settings, other, requestPath)))
+		}
+	}
+}
+
+// withHostLayers adds to a handler what is applied by the host to all the routes:
+// the CORS policy, then the rate limits, then the error handling. Being after CORS, the 429 and error responses have the CORS headers.
+func withHostLayers(settings *jsHostSettings, requestPath string, handler httpServer.HttpMiddleware) httpServer.HttpMiddleware {
+	return withCors(settings, withRateLimits(settings, withErrorHandling(settings, requestPath, handler)))
+}
+
+// buildAutoVerbHandler returns the handler used when a path exists but not for this verb.
+func buildAutoVerbHandler(settings *jsHostSettings, verb string, requestPath string) httpServer.HttpMiddleware {
+	return func(call httpServer.HttpRequest) error {
+		// The automatic handler can hide a route with wildcards, which must have the priority.
+		if handler := settings.findWildcardRoute(verb, call.Path()); handler != nil {
+			return handler(call)
+		}
+
+		// HEAD is answered with the GET handler, fasthttp doesn't send the body.
+		if verb == "HEAD" {
+			if handler := settings.getRouteHandler("GET", requestPath); handler != nil {
+				return handler(call)
+			}
+		}
+
+		call.SetHeader("Allow", settings.getAllowedVerbs(requestPath))
+		call.SetContentType("text/plain")
+
+		if verb == "OPTIONS" {
+			call.ReturnString(204, "")
+		} else {
+			call.ReturnString(405, "Method Not Allowed")
+		}
+
+		return nil
+	}
+}
+
+func (m *jsHostSettings) getRouteHandler(verb string, requestPath string) httpServer.HttpMiddleware {
+	m.routesMutex.RLock()
+	defer m.routesMutex.RUnlock()
+
+	verbs := m.routes[requestPath]
+	if verbs == nil {
+		return nil
+	}
+
+	return verbs[verb]
+}
+
+// getAllowedVerbs returns the value of the "Allow" header for this path.
+func (m *jsHostSettings) getAllowedVerbs(requestPath string) string {
+	m.routesMutex.RLock()
+	defer m.routesMutex.RUnlock()
+
+	verbs := m.routes[requestPath]
+
+	if verbs[AllVerbs] != nil {
+		return strings.Join(gStandardVerbs, ", ")
+	}
+
+	allowed := map[string]bool{"OPTIONS": true}
+
+	for verb := range verbs {
+		allowed[verb] = true
+	}
+
+	if allowed["GET"] {
+		allowed["HEAD"] = true
+	}
+
+	var res []string
+
+	for verb := range allowed {
+		res = append(res, verb)
+	}
+
+	sort.Strings(res)
+	return strings.Join(res, ", ")
+}
+
+// addWildcardRoute adds a route containing wildcards to the resolvers used by findWildcardRoute.
+func (m *jsHostSettings) addWildcardRoute(verb string, requestPath string, handler httpServer.HttpMiddleware) {
+	verbs := []string{verb}
+
+	if verb == AllVerbs {
+		verbs = gStandardVerbs
+	}
+
+	for _, verb := range verbs {
+		resolver := m.wildcardRoutes[verb]
+
+		if resolver == nil {
+			resolver = httpServer.NewUrlResolver()
+			m.wildcardRoutes[verb] = resolver
+		}
+
+		resolver.Add(requestPath, handler, nil)
+	}
+}
+
+// findWildcardRoute search a route which contains wildcards and matches the path for this verb.
+// The url resolver contains only these routes, which allows selecting them with his own rules.
+func (m *jsHostSettings) findWildcardRoute(verb string, path string) httpServer.HttpMiddleware {
+	m.routesMutex.RLock()
+	defer m.routesMutex.RUnlock()
+
+	resolver := m.wildcardRoutes[verb]
+	if resolver == nil {
+		return nil
+	}
+
+	handler, _ := resolver.Find(path).Target.(httpServer.HttpMiddleware)
+	return handler
+}
+
+// splitPath returns the segments of a path, without the leading slash.
+func splitPath(path string) []string {
+	path = strings.TrimPrefix(path, "/")
+
+	if path == "" {
+		return []string{}
+	}
+
+	return strings.Split(path, "/")
+}
@@ -0,0 +1,351 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/progpjs/httpServer/v2"
+	"github.com/valyala/fasthttp"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxRangesPerRequest is the max number of ranges of a request.
+// Above, the Range header is ignored and the whole file is sent,
+// which protects against the requests asking for many tiny, or overlapping, ranges.
+const MaxRangesPerRequest = 16
+
+// makeFileEtag returns a strong ETag built from the modification date and the size of a file.
+func makeFileEtag(modTime time.Time, size int64) string {
+	return `"` + strconv.FormatInt(modTime.UnixNano(), 36) + "-" + strconv.FormatInt(size, 36) + `"`
+}
+
+// isRequestNotModified returns true if the client already has this version of the resource,
+// according to his If-None-Match and If-Modified-Since headers.
+// An empty etag, or a zero modTime, disables the corresponding check.
+func isRequestNotModified(ctx *fasthttp.RequestCtx, etag string, modTime time.Time) bool {
+	if etag != "" {
+		if ifNoneMatch := string(ctx.Request.Header.Peek("If-None-Match")); ifNoneMatch != "" {
+			for _, e := range strings.Split(ifNoneMatch, ",") {
+				e = strings.TrimPrefix(strings.TrimSpace(e), "W/")
+
+				if (e == "*") || (e == etag) {
+					return true
+				}
+			}
+
+			// If-Modified-Since must be ignored when If-None-Match is set.
+			return false
+		}
+	}
+
+	if !modTime.IsZero() {
+		if since, err := http.ParseTime(string(ctx.Request.Header.Peek("If-Modified-Since"))); err == nil {
+			return !modTime.Truncate(time.Second).After(since)
+		}
+	}
+
+	return false
+}
+
+type byteRange struct {
+	start, length int64
+}
+
+func (m byteRange) contentRange(size int64) string {
+	return "bytes " + strconv.FormatInt(m.start, 10) + "-" + strconv.FormatInt(m.start+m.length-1, 10) + "/" + strconv.FormatInt(size, 10)
+}
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// parseRangeHeader parses a Range header for a file of this size.
+// The ranges are sorted, and the overlapping or adjacent ones are merged, so that no byte is sent twice.
+// Returns errRangeNotSatisfiable if no range is inside the file,
+// and another error if the header is invalid, in which case it must be ignored.
+func parseRangeHeader(header string, size int64) ([]byteRange, error) {
+	specs, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, errors.New("invalid range unit")
+	}
+
+	var ranges []byteRange
+
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+
+		if spec == "" {
+			continue
+		}
+
+		startText, endText, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, errors.New("invalid range")
+		}
+
+		startText = strings.TrimSpace(startText)
+		endText = strings.TrimSpace(endText)
+
+		var r byteRange
+
+		if startText == "" {
+			// The last bytes of the file.
+			suffix, err := strconv.ParseInt(endText, 10, 64)
+			if (err != nil) || (suffix < 0) {
+				return nil, errors.New("invalid range")
+			}
+
+			if suffix == 0 {
+				continue
+			}
+
+			r.start = max(size-suffix, 0)
+			r.length = size - r.start
+		} else {
+			start, err := strconv.ParseInt(startText, 10, 64)
+			if (err != nil) || (start < 0) {
+				return nil, errors.New("invalid range")
+			}
+
+			end := size - 1
+
+			if endText != "" {
+				if end, err = strconv.ParseInt(endText, 10, 64); (err != nil) || (end < start) {
+					return nil, errors.New("invalid range")
+				}
+
+				end = min(end, size-1)
+			}
+
+			// Outside the file, but the other ranges can be satisfiable.
+			if start >= size {
+				continue
+			}
+
+			r.start = start
+			r.length = end - start + 1
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, errRangeNotSatisfiable
+	}
+
+	if len(ranges) > MaxRangesPerRequest {
+		return nil, errors.New("too many ranges")
+	}
+
+	return mergeRanges(ranges), nil
+}
+
+// mergeRanges sorts the ranges and merges those which overlap or are adjacent.
+func mergeRanges(ranges []byteRange) []byteRange {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start < ranges[j].start
+	})
+
+	merged := ranges[:1]
+
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		lastEnd := last.start + last.length
+
+		if r.start > lastEnd {
+			merged = append(merged, r)
+		} else if end := r.start + r.length; end > lastEnd {
+			last.length = end - last.start
+		}
+	}
+
+	return merged
+}
+
+// isIfRangeMatching returns true if the If-Range header, when set, matches the current version of the file.
+// Otherwise, the range must be ignored since the client has an old version.
+func isIfRangeMatching(ctx *fasthttp.RequestCtx, etag string, modTime time.Time) bool {
+	ifRange := strings.TrimSpace(string(ctx.Request.Header.Peek("If-Range")))
+
+	if ifRange == "" {
+		return true
+	}
+
+	// Only a strong comparison is allowed.
+	if strings.HasPrefix(ifRange, `"`) {
+		return ifRange == etag
+	}
+
+	if date, err := http.ParseTime(ifRange); err == nil {
+		return modTime.UTC().Truncate(time.Second).Equal(date)
+	}
+
+	return false
+}
+
+// fileReadCloser reads a part of a file, and closes the file with it.
+type fileReadCloser struct {
+	io.Reader
+	file *os.File
+}
+
+func (m *fileReadCloser) Close() error {
+	return m.file.Close()
+}
+
+func newMultipartBoundary() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// sendMultipartRanges sends the ranges as a multipart/byteranges body.
+func sendMultipartRanges(ctx *fasthttp.RequestCtx, file *os.File, size int64, ranges []byteRange, contentType string) {
+	boundary := newMultipartBoundary()
+
+	var readers []io.Reader
+	var length int64
+
+	for i, r := range ranges {
+		partHeader := "\r\n--" + boundary + "\r\nContent-Type: " + contentType + "\r\nContent-Range: " + r.contentRange(size) + "\r\n\r\n"
+
+		// No line break before the first boundary.
+		if i == 0 {
+			partHeader = partHeader[2:]
+		}
+
+		readers = append(readers, strings.NewReader(partHeader), io.NewSectionReader(file, r.start, r.length))
+		length += int64(len(partHeader)) + r.length
+	}
+
+	closing := "\r\n--" + boundary + "--\r\n"
+	readers = append(readers, strings.NewReader(closing))
+	length += int64(len(closing))
+
+	ctx.SetContentType("multipart/byteranges; boundary=" + boundary)
+	ctx.Response.SetBodyStream(&fileReadCloser{io.MultiReader(readers...), file}, int(length))
+}
+
+// detectFileContentType returns the content type of the file from his extension,
+// or from his first bytes if the extension is unknown.
+func detectFileContentType(file *os.File) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(file.Name())); contentType != "" {
+		return contentType
+	}
+
+	var head [512]byte
+	n, _ := io.ReadFull(file, head[:])
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "application/octet-stream"
+	}
+
+	return http.DetectContentType(head[:n])
+}
+
+// sendFile sends the file, like a static file server would do: the conditional requests
+// are answered with a 304, and the range requests with a 206.
+// If contentType is empty, then it's detected. If contentEncoding isn't empty, then the file
+// is considered as already encoded, and the ranges are ranges of the encoded content.
+func sendFile(call httpServer.HttpRequest, filePath string, contentType string, contentEncoding string) error {
+	ctx, err := getFastHttpCtx(call)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+
+	stat, err := file.Stat()
+
+	if (err == nil) && stat.IsDir() {
+		err = errors.New("is a directory: " + filePath)
+	}
+
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	size := stat.Size()
+	modTime := stat.ModTime()
+	etag := makeFileEtag(modTime, size)
+
+	if contentType == "" {
+		contentType = detectFileContentType(file)
+	}
+
+	ctx.Response.Header.Set("ETag", etag)
+	ctx.Response.Header.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	ctx.Response.Header.Set("Accept-Ranges", "bytes")
+
+	if contentEncoding != "" {
+		ctx.Response.Header.SetContentEncoding(contentEncoding)
+	}
+
+	if isRequestNotModified(ctx, etag, modTime) {
+		_ = file.Close()
+		ctx.SetStatusCode(304)
+		return nil
+	}
+
+	rangeHeader := string(ctx.Request.Header.Peek("Range"))
+	isGet := ctx.IsGet() || ctx.IsHead()
+
+	if (rangeHeader != "") && isGet && isIfRangeMatching(ctx, etag, modTime) {
+		ranges, err := parseRangeHeader(rangeHeader, size)
+
+		if errors.Is(err, errRangeNotSatisfiable) {
+			_ = file.Close()
+			ctx.Response.Header.Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+			ctx.SetStatusCode(416)
+			return nil
+		}
+
+		if err == nil {
+			ctx.SetStatusCode(206)
+
+			if len(ranges) == 1 {
+				r := ranges[0]
+				ctx.SetContentType(contentType)
+				ctx.Response.Header.Set("Content-Range", r.contentRange(size))
+				ctx.Response.SetBodyStream(&fileReadCloser{io.NewSectionReader(file, r.start, r.length), file}, int(r.length))
+			} else {
+				sendMultipartRanges(ctx, file, size, ranges, contentType)
+			}
+
+			return nil
+		}
+	}
+
+	ctx.SetStatusCode(200)
+	ctx.SetContentType(contentType)
+
+	// The file is closed by fasthttp once sent.
+	ctx.Response.SetBodyStream(file, int(size))
+	return nil
+}
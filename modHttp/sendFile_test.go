@@ -0,0 +1,147 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"errors"
+	"github.com/valyala/fasthttp"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRangeHeader(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected []byteRange
+	}{
+		{"bytes=0-9", []byteRange{{0, 10}}},
+		{"bytes=10-", []byteRange{{10, 90}}},
+		{"bytes=-10", []byteRange{{90, 10}}},
+		{"bytes=-200", []byteRange{{0, 100}}},
+		{"bytes=90-200", []byteRange{{90, 10}}},
+		{"bytes=0-9, 20-29", []byteRange{{0, 10}, {20, 10}}},
+
+		// Unordered, overlapping and adjacent ranges are sorted and merged.
+		{"bytes=20-29,0-9", []byteRange{{0, 10}, {20, 10}}},
+		{"bytes=0-49,10-19", []byteRange{{0, 50}}},
+		{"bytes=0-9,5-19", []byteRange{{0, 20}}},
+		{"bytes=0-9,10-19", []byteRange{{0, 20}}},
+		{"bytes=50-59,0-9,5-54", []byteRange{{0, 60}}},
+		{"bytes=0-9,-10,0-", []byteRange{{0, 100}}},
+
+		// A range outside the file is ignored if another one is satisfiable.
+		{"bytes=0-9,200-300", []byteRange{{0, 10}}},
+	}
+
+	for _, test := range tests {
+		ranges, err := parseRangeHeader(test.header, 100)
+
+		if err != nil {
+			t.Fatalf("%s: %s", test.header, err)
+		}
+
+		if !reflect.DeepEqual(ranges, test.expected) {
+			t.Fatalf("%s: got %v", test.header, ranges)
+		}
+	}
+}
+
+func TestParseRangeHeaderErrors(t *testing.T) {
+	for _, header := range []string{"bytes=200-300", "bytes=-0", "bytes=100-"} {
+		if _, err := parseRangeHeader(header, 100); !errors.Is(err, errRangeNotSatisfiable) {
+			t.Fatalf("%s: expected errRangeNotSatisfiable, got %v", header, err)
+		}
+	}
+
+	tooMany := "bytes=" + strings.Repeat("0-0,", MaxRangesPerRequest) + "0-0"
+
+	for _, header := range []string{"items=0-9", "bytes=abc", "bytes=9-0", "bytes=-x", tooMany} {
+		_, err := parseRangeHeader(header, 100)
+
+		if (err == nil) || errors.Is(err, errRangeNotSatisfiable) {
+			t.Fatalf("%s: expected an invalid header error, got %v", header, err)
+		}
+	}
+}
+
+func newTestRequestCtx(headers map[string]string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+
+	for key, value := range headers {
+		ctx.Request.Header.Set(key, value)
+	}
+
+	return ctx
+}
+
+func TestIsRequestNotModified(t *testing.T) {
+	modTime := time.Date(2024, 3, 1, 10, 0, 0, 500, time.UTC)
+	etag := makeFileEtag(modTime, 100)
+	lastModified := modTime.Format(http.TimeFormat)
+	before := modTime.Add(-time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		headers  map[string]string
+		expected bool
+	}{
+		{nil, false},
+		{map[string]string{"If-None-Match": etag}, true},
+		{map[string]string{"If-None-Match": `"other", W/` + etag}, true},
+		{map[string]string{"If-None-Match": "*"}, true},
+		{map[string]string{"If-None-Match": `"other"`}, false},
+		{map[string]string{"If-Modified-Since": lastModified}, true},
+		{map[string]string{"If-Modified-Since": before}, false},
+
+		// If-Modified-Since is ignored when If-None-Match is set.
+		{map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": lastModified}, false},
+	}
+
+	for _, test := range tests {
+		if isRequestNotModified(newTestRequestCtx(test.headers), etag, modTime) != test.expected {
+			t.Fatalf("%v: expected %t", test.headers, test.expected)
+		}
+	}
+}
+
+func TestIsIfRangeMatching(t *testing.T) {
+	modTime := time.Date(2024, 3, 1, 10, 0, 0, 500, time.UTC)
+	etag := makeFileEtag(modTime, 100)
+
+	tests := []struct {
+		ifRange  string
+		expected bool
+	}{
+		{"", true},
+		{etag, true},
+		{`"other"`, false},
+		{"W/" + etag, false},
+		{modTime.Format(http.TimeFormat), true},
+		{modTime.Add(-time.Hour).Format(http.TimeFormat), false},
+		{"invalid", false},
+	}
+
+	for _, test := range tests {
+		ctx := newTestRequestCtx(map[string]string{"If-Range": test.ifRange})
+
+		if isIfRangeMatching(ctx, etag, modTime) != test.expected {
+			t.Fatalf("%q: expected %t", test.ifRange, test.expected)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"errors"
+	"github.com/progpjs/progpAPI/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSseHeartbeatInterval is the time, in seconds, between two heartbeat comments.
+// It avoids the connection being closed by the proxies, and allows detecting when the client is gone.
+const DefaultSseHeartbeatInterval = 15
+
+type JsServerSentEvent struct {
+	Event string `json:"event"`
+	Id    string `json:"id"`
+	Data  string `json:"data"`
+
+	// Retry is the reconnection time, in milliseconds, the client must use.
+	Retry int `json:"retry"`
+}
+
+// formatServerSentEvent returns the text sent for this event.
+// The data can contain line breaks, each line is then sent as a "data" field.
+func formatServerSentEvent(event JsServerSentEvent) ([]byte, error) {
+	if strings.ContainsAny(event.Event, "\r\n") || strings.ContainsAny(event.Id, "\r\n") {
+		return nil, errors.New("event name and id can't contain line breaks")
+	}
+
+	var sb strings.Builder
+
+	if event.Event != "" {
+		sb.WriteString("event: " + event.Event + "\n")
+	}
+
+	if event.Id != "" {
+		sb.WriteString("id: " + event.Id + "\n")
+	}
+
+	if event.Retry > 0 {
+		sb.WriteString("retry: " + strconv.Itoa(event.Retry) + "\n")
+	}
+
+	// The protocol accepts "\r\n", "\r" and "\n" as line breaks.
+	data := strings.ReplaceAll(event.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+
+	for _, line := range strings.Split(data, "\n") {
+		sb.WriteString("data: " + line + "\n")
+	}
+
+	sb.WriteString("\n")
+	return []byte(sb.String()), nil
+}
+
+// startSseHeartbeat sends a comment line at regular intervals, until the stream ends.
+func startSseHeartbeat(stream *jsResponseStream, interval int) {
+	progpAPI.SafeGoRoutine(func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stream.writerDone:
+				return
+			case <-ticker.C:
+				// If the buffer is full, then the heartbeat is useless.
+				if stream.write([]byte(": heartbeat\n\n")) == StreamClosedError {
+					return
+				}
+			}
+		}
+	})
+}
+
+// JsResponseSseBegin starts an event stream.
+// The connection stays open until JsResponseStreamEnd is called or the client is gone.
+// A heartbeat interval of zero uses the default, while a negative value disables it.
+func JsResponseSseBegin(resHttpRequest *progpAPI.SharedResource, heartbeatInterval int) error {
+	req, err := getJsHttpRequest(resHttpRequest)
+	if err != nil {
+		return err
+	}
+
+	req.SetHeader("Cache-Control", "no-cache")
+
+	// Avoid nginx buffering the events.
+	req.SetHeader("X-Accel-Buffering", "no")
+
+	stream, err := beginResponseStream(req, 200, "text/event-stream; charset=utf-8")
+	if err != nil {
+		return err
+	}
+
+	if heartbeatInterval == 0 {
+		heartbeatInterval = DefaultSseHeartbeatInterval
+	}
+
+	if heartbeatInterval > 0 {
+		startSseHeartbeat(stream, heartbeatInterval)
+	}
+
+	return nil
+}
+
+// JsResponseSseSend sends an event.
+// Returns false if the client is too slow to consume the previous events, in which case the event isn't sent.
+func JsResponseSseSend(resHttpRequest *progpAPI.SharedResource, event JsServerSentEvent) (error, bool) {
+	b, err := formatServerSentEvent(event)
+	if err != nil {
+		return err, false
+	}
+
+	return writeResponseStream(resHttpRequest, b)
+}
+
+// JsRequestLastEventId returns the id of the last event received by the client before reconnecting.
+func JsRequestLastEventId(resHttpRequest *progpAPI.SharedResource) (error, string) {
+	req, err := getJsHttpRequest(resHttpRequest)
+	if err != nil {
+		return err, ""
+	}
+
+	ctx, err := getFastHttpCtx(req)
+	if err != nil {
+		return err, ""
+	}
+
+	return nil, string(ctx.Request.Header.Peek("Last-Event-ID"))
+}
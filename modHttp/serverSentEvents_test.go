@@ -0,0 +1,36 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import "testing"
+
+func TestFormatServerSentEvent(t *testing.T) {
+	b, err := formatServerSentEvent(JsServerSentEvent{Event: "update", Id: "7", Data: "a\r\nb\rc\nd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "event: update\nid: 7\ndata: a\ndata: b\ndata: c\ndata: d\n\n"
+
+	if string(b) != expected {
+		t.Fatalf("got %q", b)
+	}
+
+	if _, err = formatServerSentEvent(JsServerSentEvent{Event: "a\rb"}); err == nil {
+		t.Fatal("a line break in the event name must be rejected")
+	}
+}
@@ -0,0 +1,752 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/progpjs/httpServer/v2"
+	"github.com/progpjs/progpAPI/v2"
+	"github.com/valyala/fasthttp"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultWebSocketPingInterval is the time, in seconds, between two pings sent to the client.
+const DefaultWebSocketPingInterval = 30
+
+// DefaultWebSocketMaxMessageSize is the max size, in bytes, of a message sent by the client.
+const DefaultWebSocketMaxMessageSize = 4 * 1024 * 1024
+
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC65B11"
+const webSocketWriteTimeout = 10 * time.Second
+
+// webSocketCloseTimeout is the time to wait for the client answer once a close frame is sent.
+const webSocketCloseTimeout = 5 * time.Second
+
+// webSocketSendQueueSize is the number of messages sent by javascript waiting to be written.
+const webSocketSendQueueSize = 64
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+const (
+	WebSocketCloseNormal          = 1000
+	WebSocketCloseGoingAway       = 1001
+	WebSocketCloseProtocolError   = 1002
+	WebSocketCloseNoStatus        = 1005
+	WebSocketCloseAbnormal        = 1006
+	WebSocketCloseInvalidData     = 1007
+	WebSocketCloseMessageTooLarge = 1009
+)
+
+var WebSocketClosedError = errors.New("websocket closed")
+var WebSocketSendQueueFullError = errors.New("websocket send queue full")
+
+// webSocketError is a protocol violation, which closes the connection with this code.
+type webSocketError struct {
+	code   int
+	reason string
+}
+
+func (m *webSocketError) Error() string {
+	return m.reason
+}
+
+type JsWebSocketOptions struct {
+	// PingInterval is the time, in seconds, between two pings.
+	// The connection is closed if nothing is received during two intervals.
+	// A negative value disables the pings.
+	PingInterval int `json:"pingInterval"`
+
+	// MaxMessageSize is the max size, in bytes, of a message.
+	MaxMessageSize int `json:"maxMessageSize"`
+
+	// Protocols are the sub-protocols supported, the first one also asked by the client is selected.
+	Protocols []string `json:"protocols"`
+}
+
+// jsWebSocket is the value of the SharedResource given to javascript for each connection.
+type jsWebSocket struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	options JsWebSocketOptions
+
+	// rc contains the resource of the connection, it's disposed on disconnect.
+	rc  *progpAPI.SharedResourceContainer
+	res *progpAPI.SharedResource
+
+	// attached are the resources bound to this connection by javascript, they are disposed on disconnect.
+	attached      []*progpAPI.SharedResource
+	attachedMutex sync.Mutex
+
+	// outgoing contains the frames sent by javascript, which are written by another goroutine
+	// in order to not block the javascript thread while the client is slow.
+	outgoing    chan wsOutgoingFrame
+	closeQueued atomic.Bool
+
+	writeMutex sync.Mutex
+	closeSent  atomic.Bool
+	done       chan bool
+}
+
+type wsOutgoingFrame struct {
+	opcode  byte
+	payload []byte
+}
+
+func JsWebSocketWithFunction(rc *progpAPI.SharedResourceContainer, resHost *progpAPI.SharedResource, requestPath string, options JsWebSocketOptions, onOpen progpAPI.JsFunction, onTextMessage progpAPI.JsFunction, onBinaryMessage progpAPI.JsFunction, onClose progpAPI.JsFunction) error {
+	host, ok := resHost.Value.(*httpServer.HttpHost)
+	if !ok {
+		return errors.New("invalid resource")
+	}
+
+	pattern, err := parseRoutePattern(requestPath)
+	if err != nil {
+		return err
+	}
+
+	if pattern != nil {
+		if err = getHostSettings(host).claimResolverPath("GET", pattern, requestPath); err != nil {
+			return err
+		}
+
+		requestPath = pattern.resolverPath
+	}
+
+	if options.PingInterval == 0 {
+		options.PingInterval = DefaultWebSocketPingInterval
+	}
+
+	if options.MaxMessageSize <= 0 {
+		options.MaxMessageSize = DefaultWebSocketMaxMessageSize
+	}
+
+	onOpen.KeepAlive()
+	onTextMessage.KeepAlive()
+	onBinaryMessage.KeepAlive()
+	onClose.KeepAlive()
+
+	registerRoute(host, "GET", requestPath, func(call httpServer.HttpRequest) error {
+		var params map[string]string
+
+		if pattern != nil {
+			if params, ok = pattern.extract(call.Path()); !ok {
+				call.GetHost().OnNotFound(call)
+				return nil
+			}
+		}
+
+		ctx, err := getFastHttpCtx(call)
+		if err != nil {
+			return err
+		}
+
+		protocol, ok := acceptWebSocketUpgrade(ctx, options)
+		if !ok {
+			return nil
+		}
+
+		info := make(map[string]any)
+		info["uri"] = call.FullURI()
+		info["path"] = call.Path()
+		info["ip"] = call.RemoteIP()
+		info["hostname"] = call.GetHost().GetHostName()
+		info["headers"] = call.GetHeaders()
+		info["params"] = params
+		info["protocol"] = protocol
+
+		jsonInfo, err := json.Marshal(info)
+		if err != nil {
+			return err
+		}
+
+		// fasthttp sends the 101 response, then calls this function.
+		// The connection is closed once it returns.
+		ctx.Hijack(func(conn net.Conn) {
+			ws := newJsWebSocket(rc, conn, options)
+			ws.startWriteLoop()
+			gCallJsFunctionWith_SharedResource_StringBuffer.Call(onOpen, ws.res, jsonInfo)
+
+			code, reason := ws.readLoop(onTextMessage, onBinaryMessage)
+			ws.onDisconnect(onClose, code, reason)
+		})
+
+		return nil
+	})
+
+	return nil
+}
+
+// acceptWebSocketUpgrade checks the handshake and set the 101 response.
+// If the request isn't a valid upgrade request, an error response is set and false is returned.
+func acceptWebSocketUpgrade(ctx *fasthttp.RequestCtx, options JsWebSocketOptions) (string, bool) {
+	header := &ctx.Request.Header
+
+	if !headerHasToken(string(header.Peek("Upgrade")), "websocket") || !headerHasToken(string(header.Peek("Connection")), "upgrade") {
+		ctx.Response.Header.Set("Upgrade", "websocket")
+		ctx.Response.Header.Set("Connection", "Upgrade")
+		ctx.Error("Upgrade Required", fasthttp.StatusUpgradeRequired)
+		return "", false
+	}
+
+	if string(header.Peek("Sec-WebSocket-Version")) != "13" {
+		ctx.Response.Header.Set("Sec-WebSocket-Version", "13")
+		ctx.Error("Upgrade Required", fasthttp.StatusUpgradeRequired)
+		return "", false
+	}
+
+	key := strings.TrimSpace(string(header.Peek("Sec-WebSocket-Key")))
+
+	if key == "" {
+		ctx.Error("Bad Request", fasthttp.StatusBadRequest)
+		return "", false
+	}
+
+	protocol := ""
+
+	if len(options.Protocols) != 0 {
+		for _, asked := range strings.Split(string(header.Peek("Sec-WebSocket-Protocol")), ",") {
+			asked = strings.TrimSpace(asked)
+
+			for _, supported := range options.Protocols {
+				if asked == supported {
+					protocol = asked
+					break
+				}
+			}
+
+			if protocol != "" {
+				break
+			}
+		}
+	}
+
+	hash := sha1.Sum([]byte(key + webSocketGUID))
+
+	ctx.SetStatusCode(fasthttp.StatusSwitchingProtocols)
+	ctx.Response.Header.Set("Upgrade", "websocket")
+	ctx.Response.Header.Set("Connection", "Upgrade")
+	ctx.Response.Header.Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(hash[:]))
+
+	if protocol != "" {
+		ctx.Response.Header.Set("Sec-WebSocket-Protocol", protocol)
+	}
+
+	return protocol, true
+}
+
+// headerHasToken returns true if this comma separated header value contains the token.
+func headerHasToken(value string, token string) bool {
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newJsWebSocket(rc *progpAPI.SharedResourceContainer, conn net.Conn, options JsWebSocketOptions) *jsWebSocket {
+	m := &jsWebSocket{
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+		options:  options,
+		rc:       progpAPI.NewSharedResourceContainer(rc, nil),
+		outgoing: make(chan wsOutgoingFrame, webSocketSendQueueSize),
+		done:     make(chan bool),
+	}
+
+	// If javascript disposes the resource, then the connection is closed.
+	m.res = m.rc.NewSharedResource(m, func(value any) {
+		_ = value.(*jsWebSocket).conn.Close()
+	})
+
+	return m
+}
+
+func getJsWebSocket(resWebSocket *progpAPI.SharedResource) (*jsWebSocket, error) {
+	ws, ok := resWebSocket.Value.(*jsWebSocket)
+	if !ok {
+		return nil, errors.New("invalid resource")
+	}
+
+	return ws, nil
+}
+
+// readLoop reads the messages until the connection is closed.
+// Returns the close code and reason.
+func (m *jsWebSocket) readLoop(onTextMessage progpAPI.JsFunction, onBinaryMessage progpAPI.JsFunction) (int, string) {
+	if m.options.PingInterval > 0 {
+		m.startPingLoop()
+	}
+
+	var message []byte
+	var messageType byte
+
+	for {
+		m.extendReadDeadline()
+
+		fin, opcode, payload, err := m.readFrame()
+
+		if err != nil {
+			var wsErr *webSocketError
+
+			if errors.As(err, &wsErr) {
+				_ = m.sendClose(wsErr.code, wsErr.reason)
+				return wsErr.code, wsErr.reason
+			}
+
+			return WebSocketCloseAbnormal, ""
+		}
+
+		switch opcode {
+		case wsOpPing:
+			_ = m.writeFrame(wsOpPong, payload)
+			continue
+
+		case wsOpPong:
+			// Receiving something is enough to extend the read deadline.
+			continue
+
+		case wsOpClose:
+			code, reason := parseClosePayload(payload)
+
+			if code == WebSocketCloseNoStatus {
+				_ = m.sendClose(0, "")
+			} else {
+				_ = m.sendClose(code, "")
+			}
+
+			return code, reason
+
+		case wsOpText, wsOpBinary:
+			if messageType != 0 {
+				_ = m.sendClose(WebSocketCloseProtocolError, "")
+				return WebSocketCloseProtocolError, "unexpected data frame"
+			}
+
+			messageType = opcode
+			message = payload
+
+		case wsOpContinuation:
+			if messageType == 0 {
+				_ = m.sendClose(WebSocketCloseProtocolError, "")
+				return WebSocketCloseProtocolError, "unexpected continuation frame"
+			}
+
+			message = append(message, payload...)
+
+		default:
+			_ = m.sendClose(WebSocketCloseProtocolError, "")
+			return WebSocketCloseProtocolError, "unknown opcode"
+		}
+
+		if len(message) > m.options.MaxMessageSize {
+			_ = m.sendClose(WebSocketCloseMessageTooLarge, "")
+			return WebSocketCloseMessageTooLarge, "message too large"
+		}
+
+		if !fin {
+			continue
+		}
+
+		if messageType == wsOpText {
+			if !utf8.Valid(message) {
+				_ = m.sendClose(WebSocketCloseInvalidData, "")
+				return WebSocketCloseInvalidData, "invalid utf8"
+			}
+
+			gCallJsFunctionWith_SharedResource_String.Call(onTextMessage, m.res, string(message))
+		} else {
+			gCallJsFunctionWith_SharedResource_ArrayBuffer.Call(onBinaryMessage, m.res, message)
+		}
+
+		message = nil
+		messageType = 0
+	}
+}
+
+func (m *jsWebSocket) extendReadDeadline() {
+	if m.closeSent.Load() {
+		return
+	}
+
+	if m.options.PingInterval > 0 {
+		_ = m.conn.SetReadDeadline(time.Now().Add(2 * time.Duration(m.options.PingInterval) * time.Second))
+	}
+}
+
+func (m *jsWebSocket) startPingLoop() {
+	progpAPI.SafeGoRoutine(func() {
+		ticker := time.NewTicker(time.Duration(m.options.PingInterval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.done:
+				return
+			case <-ticker.C:
+				if m.writeFrame(wsOpPing, nil) != nil {
+					return
+				}
+			}
+		}
+	})
+}
+
+// startWriteLoop writes the frames sent by javascript, until the connection ends.
+func (m *jsWebSocket) startWriteLoop() {
+	progpAPI.SafeGoRoutine(func() {
+		for {
+			select {
+			case <-m.done:
+				return
+			case frame := <-m.outgoing:
+				err := m.writeFrame(frame.opcode, frame.payload)
+
+				if frame.opcode == wsOpClose {
+					if err == nil {
+						_ = m.conn.SetReadDeadline(time.Now().Add(webSocketCloseTimeout))
+					}
+				} else if (err != nil) && !errors.Is(err, WebSocketClosedError) {
+					// The client is gone or too slow, which ends the read loop.
+					_ = m.conn.Close()
+					return
+				}
+			}
+		}
+	})
+}
+
+// queueFrame adds a frame to the frames to write, without waiting.
+// If the client is too slow and the queue is full, WebSocketSendQueueFullError is returned.
+func (m *jsWebSocket) queueFrame(opcode byte, payload []byte) error {
+	if m.closeQueued.Load() {
+		return WebSocketClosedError
+	}
+
+	frame := wsOutgoingFrame{opcode: opcode, payload: payload}
+
+	select {
+	case <-m.done:
+		return WebSocketClosedError
+	default:
+	}
+
+	select {
+	case m.outgoing <- frame:
+		return nil
+	default:
+		return WebSocketSendQueueFullError
+	}
+}
+
+// queueClose adds a close frame after the frames already queued.
+// Once done, nothing else can be sent.
+func (m *jsWebSocket) queueClose(code int, reason string) error {
+	if !m.closeQueued.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	frame := wsOutgoingFrame{opcode: wsOpClose, payload: buildClosePayload(code, reason)}
+
+	select {
+	case m.outgoing <- frame:
+	default:
+		// The close must be sent even if the queue is full.
+		progpAPI.SafeGoRoutine(func() {
+			select {
+			case m.outgoing <- frame:
+			case <-m.done:
+			}
+		})
+	}
+
+	return nil
+}
+
+// attach binds a resource to this connection, which is disposed on disconnect.
+func (m *jsWebSocket) attach(res *progpAPI.SharedResource) {
+	m.attachedMutex.Lock()
+	defer m.attachedMutex.Unlock()
+
+	m.attached = append(m.attached, res)
+}
+
+// readFrame reads a frame sent by the client.
+func (m *jsWebSocket) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+
+	if _, err := io.ReadFull(m.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0F
+	isMasked := header[1]&0x80 != 0
+	size := uint64(header[1] & 0x7F)
+
+	// No extension is negotiated, so the reserved bits must be zero.
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, &webSocketError{WebSocketCloseProtocolError, "reserved bits set"}
+	}
+
+	// The client must always mask his frames.
+	if !isMasked {
+		return false, 0, nil, &webSocketError{WebSocketCloseProtocolError, "frame not masked"}
+	}
+
+	if opcode >= wsOpClose && (!fin || size > 125) {
+		return false, 0, nil, &webSocketError{WebSocketCloseProtocolError, "invalid control frame"}
+	}
+
+	switch size {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(m.reader, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(b[:]))
+
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(m.reader, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(b[:])
+	}
+
+	if size > uint64(m.options.MaxMessageSize) {
+		return false, 0, nil, &webSocketError{WebSocketCloseMessageTooLarge, "message too large"}
+	}
+
+	var mask [4]byte
+
+	if _, err := io.ReadFull(m.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload := make([]byte, size)
+
+	if _, err := io.ReadFull(m.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// writeFrame sends a frame to the client, the server frames are never masked.
+func (m *jsWebSocket) writeFrame(opcode byte, payload []byte) error {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	// Once a close frame is sent, nothing else can be sent.
+	if m.closeSent.Load() {
+		return WebSocketClosedError
+	}
+
+	if opcode == wsOpClose {
+		m.closeSent.Store(true)
+	}
+
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+
+	size := len(payload)
+
+	switch {
+	case size <= 125:
+		header[1] = byte(size)
+	case size <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(size))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(size))
+	}
+
+	_ = m.conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+
+	// Allows sending the header and the payload with only one system call.
+	buffers := net.Buffers{header, payload}
+
+	_, err := buffers.WriteTo(m.conn)
+	return err
+}
+
+// sendClose sends a close frame, then gives some time to the client to answer.
+// A code of zero sends a close frame without status.
+func (m *jsWebSocket) sendClose(code int, reason string) error {
+	err := m.writeFrame(wsOpClose, buildClosePayload(code, reason))
+
+	if err == nil {
+		_ = m.conn.SetReadDeadline(time.Now().Add(webSocketCloseTimeout))
+	}
+
+	return err
+}
+
+// buildClosePayload returns the payload of a close frame. A code of zero means no status.
+func buildClosePayload(code int, reason string) []byte {
+	if code == 0 {
+		return nil
+	}
+
+	// Control frames are limited to 125 bytes, and the reason must stay valid utf8.
+	if len(reason) > 123 {
+		n := 123
+
+		for (n > 0) && !utf8.RuneStart(reason[n]) {
+			n--
+		}
+
+		reason = reason[:n]
+	}
+
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return append(payload, reason...)
+}
+
+// isValidCloseCode returns true if the server can send this close code.
+// These are the codes registered by RFC 6455 and the IANA, except the ones which
+// can't be sent (1004 to 1006 and 1015), and the codes reserved for applications.
+func isValidCloseCode(code int) bool {
+	switch {
+	case (code >= 1000) && (code <= 1003):
+		return true
+	case (code >= 1007) && (code <= 1014):
+		return true
+	case (code >= 3000) && (code <= 4999):
+		return true
+	}
+
+	return false
+}
+
+func parseClosePayload(payload []byte) (int, string) {
+	if len(payload) < 2 {
+		return WebSocketCloseNoStatus, ""
+	}
+
+	return int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+}
+
+// onDisconnect calls the javascript close handler then disposes the resources of the connection.
+func (m *jsWebSocket) onDisconnect(onClose progpAPI.JsFunction, code int, reason string) {
+	wasClean := code != WebSocketCloseAbnormal
+
+	close(m.done)
+	_ = m.conn.Close()
+
+	b, _ := json.Marshal(map[string]any{"code": code, "reason": reason, "wasClean": wasClean})
+	gCallJsFunctionWith_SharedResource_StringBuffer.Call(onClose, m.res, b)
+
+	m.attachedMutex.Lock()
+	attached := m.attached
+	m.attached = nil
+	m.attachedMutex.Unlock()
+
+	for _, res := range attached {
+		res.Dispose()
+	}
+
+	m.rc.Dispose()
+}
+
+// JsWebSocketSendString sends a text message, without waiting for it to be written.
+// Returns false if the client is too slow to receive the previous messages, in which case the message isn't sent.
+func JsWebSocketSendString(resWebSocket *progpAPI.SharedResource, message string) (error, bool) {
+	ws, err := getJsWebSocket(resWebSocket)
+	if err != nil {
+		return err, false
+	}
+
+	return queueResultForJs(ws.queueFrame(wsOpText, []byte(message)))
+}
+
+// JsWebSocketSendBytes sends a binary message, like JsWebSocketSendString.
+func JsWebSocketSendBytes(resWebSocket *progpAPI.SharedResource, message []byte) (error, bool) {
+	ws, err := getJsWebSocket(resWebSocket)
+	if err != nil {
+		return err, false
+	}
+
+	// The buffer memory is owned by javascript, and the message is written after returning.
+	b := make([]byte, len(message))
+	copy(b, message)
+
+	return queueResultForJs(ws.queueFrame(wsOpBinary, b))
+}
+
+func queueResultForJs(err error) (error, bool) {
+	if err == WebSocketSendQueueFullError {
+		return nil, false
+	}
+
+	return err, err == nil
+}
+
+// JsWebSocketAttach binds a resource to the connection, which is disposed when the connection ends.
+func JsWebSocketAttach(resWebSocket *progpAPI.SharedResource, res *progpAPI.SharedResource) error {
+	ws, err := getJsWebSocket(resWebSocket)
+	if err != nil {
+		return err
+	}
+
+	ws.attach(res)
+	return nil
+}
+
+// JsWebSocketClose starts the closing handshake.
+// The close handler is called once the client has answered or the timeout is reached.
+func JsWebSocketClose(resWebSocket *progpAPI.SharedResource, code int, reason string) error {
+	ws, err := getJsWebSocket(resWebSocket)
+	if err != nil {
+		return err
+	}
+
+	if code == 0 {
+		code = WebSocketCloseNormal
+	}
+
+	if !isValidCloseCode(code) {
+		return errors.New("invalid close code")
+	}
+
+	// The close frame is sent after the messages already queued.
+	return ws.queueClose(code, reason)
+}
@@ -0,0 +1,239 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modHttp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// buildClientFrame returns a frame as sent by a client, which always masks his frames.
+func buildClientFrame(fin bool, opcode byte, payload []byte) []byte {
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+
+	frame := []byte{first}
+	size := len(payload)
+
+	switch {
+	case size <= 125:
+		frame = append(frame, 0x80|byte(size))
+	case size <= 0xFFFF:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(size))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(size))
+	}
+
+	frame = append(frame, mask[:]...)
+
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	return frame
+}
+
+func newTestWebSocket(input []byte, maxMessageSize int) *jsWebSocket {
+	return &jsWebSocket{
+		reader:   bufio.NewReader(bytes.NewReader(input)),
+		options:  JsWebSocketOptions{MaxMessageSize: maxMessageSize},
+		outgoing: make(chan wsOutgoingFrame, webSocketSendQueueSize),
+		done:     make(chan bool),
+	}
+}
+
+func TestWebSocketReadFrame(t *testing.T) {
+	for _, size := range []int{0, 5, 125, 126, 300, 0xFFFF, 70000} {
+		payload := bytes.Repeat([]byte("a"), size)
+		ws := newTestWebSocket(buildClientFrame(true, wsOpBinary, payload), 100000)
+
+		fin, opcode, res, err := ws.readFrame()
+
+		if err != nil {
+			t.Fatalf("size %d: %s", size, err)
+		}
+
+		if !fin || (opcode != wsOpBinary) || !bytes.Equal(res, payload) {
+			t.Fatalf("size %d: invalid frame", size)
+		}
+	}
+}
+
+func TestWebSocketReadFrameErrors(t *testing.T) {
+	unmasked := []byte{0x81, 0x02, 'h', 'i'}
+
+	reservedBits := buildClientFrame(true, wsOpText, []byte("hi"))
+	reservedBits[0] |= 0x40
+
+	tests := []struct {
+		name  string
+		frame []byte
+		code  int
+	}{
+		{"unmasked", unmasked, WebSocketCloseProtocolError},
+		{"reserved bits", reservedBits, WebSocketCloseProtocolError},
+		{"fragmented control frame", buildClientFrame(false, wsOpPing, nil), WebSocketCloseProtocolError},
+		{"large control frame", buildClientFrame(true, wsOpPing, make([]byte, 126)), WebSocketCloseProtocolError},
+		{"too large", buildClientFrame(true, wsOpText, make([]byte, 200)), WebSocketCloseMessageTooLarge},
+	}
+
+	for _, test := range tests {
+		ws := newTestWebSocket(test.frame, 100)
+		_, _, _, err := ws.readFrame()
+
+		var wsErr *webSocketError
+
+		if !errors.As(err, &wsErr) || (wsErr.code != test.code) {
+			t.Fatalf("%s: expected code %d, got %v", test.name, test.code, err)
+		}
+	}
+
+	// A truncated frame is an io error, not a protocol error.
+	frame := buildClientFrame(true, wsOpText, []byte("hello"))
+	ws := newTestWebSocket(frame[:len(frame)-2], 100)
+
+	if _, _, _, err := ws.readFrame(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("truncated frame: %v", err)
+	}
+}
+
+func TestWebSocketWriteFrame(t *testing.T) {
+	for _, size := range []int{5, 125, 126, 300, 70000} {
+		server, client := net.Pipe()
+		ws := &jsWebSocket{conn: server}
+		payload := bytes.Repeat([]byte("b"), size)
+
+		go func() {
+			_ = ws.writeFrame(wsOpBinary, payload)
+			_ = server.Close()
+		}()
+
+		frame, err := io.ReadAll(client)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if frame[0] != 0x80|wsOpBinary {
+			t.Fatalf("size %d: invalid first byte %x", size, frame[0])
+		}
+
+		// The server frames are never masked.
+		if frame[1]&0x80 != 0 {
+			t.Fatalf("size %d: frame masked", size)
+		}
+
+		headerSize := 2
+
+		switch frame[1] {
+		case 126:
+			headerSize = 4
+		case 127:
+			headerSize = 10
+		}
+
+		if !bytes.Equal(frame[headerSize:], payload) {
+			t.Fatalf("size %d: invalid payload", size)
+		}
+	}
+}
+
+func TestWebSocketWriteAfterClose(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	ws := &jsWebSocket{conn: server}
+
+	go func() { _, _ = io.Copy(io.Discard, client) }()
+
+	if err := ws.writeFrame(wsOpClose, buildClosePayload(WebSocketCloseNormal, "")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ws.writeFrame(wsOpText, []byte("hi")); !errors.Is(err, WebSocketClosedError) {
+		t.Fatalf("expected WebSocketClosedError, got %v", err)
+	}
+}
+
+func TestWebSocketClosePayload(t *testing.T) {
+	code, reason := parseClosePayload(buildClosePayload(WebSocketCloseGoingAway, "bye"))
+
+	if (code != WebSocketCloseGoingAway) || (reason != "bye") {
+		t.Fatalf("got %d %q", code, reason)
+	}
+
+	if code, _ = parseClosePayload(buildClosePayload(0, "")); code != WebSocketCloseNoStatus {
+		t.Fatalf("got %d", code)
+	}
+
+	// "é" is two bytes, which would be cut in half at 123 bytes.
+	payload := buildClosePayload(WebSocketCloseNormal, strings.Repeat("a", 122)+"éé")
+
+	if (len(payload) > 125) || !utf8.Valid(payload[2:]) {
+		t.Fatalf("invalid truncated reason, size %d", len(payload))
+	}
+}
+
+func TestWebSocketCloseCodes(t *testing.T) {
+	for _, code := range []int{1000, 1001, 1008, 1011, 1014, 3000, 4999} {
+		if !isValidCloseCode(code) {
+			t.Fatalf("%d must be valid", code)
+		}
+	}
+
+	for _, code := range []int{0, 999, 1004, 1005, 1006, 1015, 2000, 5000} {
+		if isValidCloseCode(code) {
+			t.Fatalf("%d must be invalid", code)
+		}
+	}
+}
+
+func TestWebSocketSendQueue(t *testing.T) {
+	ws := newTestWebSocket(nil, 100)
+
+	for i := 0; i < webSocketSendQueueSize; i++ {
+		if err := ws.queueFrame(wsOpText, []byte("hi")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ws.queueFrame(wsOpText, []byte("hi")); !errors.Is(err, WebSocketSendQueueFullError) {
+		t.Fatalf("expected WebSocketSendQueueFullError, got %v", err)
+	}
+
+	// The close frame is queued even if the queue is full.
+	_ = ws.queueClose(WebSocketCloseNormal, "")
+	<-ws.outgoing
+
+	if err := ws.queueFrame(wsOpText, []byte("hi")); !errors.Is(err, WebSocketClosedError) {
+		t.Fatalf("expected WebSocketClosedError, got %v", err)
+	}
+
+	close(ws.done)
+}
@@ -0,0 +1,392 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// https://nodejs.org/api/zlib.html
+
+import {SharedResource} from "@progp/core"
+import {from as bufferFrom} from "node:buffer";
+
+interface ModZlib {
+    compressSync(format: string, data: ArrayBuffer, level: number): ArrayBuffer
+    decompressSync(format: string, data: ArrayBuffer, maxOutputLength: number): ArrayBuffer
+    compressAsync(format: string, data: ArrayBuffer, level: number, callback: Function): void
+    decompressAsync(format: string, data: ArrayBuffer, maxOutputLength: number, callback: Function): void
+
+    streamCreate(format: string, compress: boolean, level: number, maxOutputLength: number): SharedResource
+    streamWrite(resId: SharedResource, data: ArrayBuffer, callback: Function): void
+    streamFlush(resId: SharedResource, callback: Function): void
+    streamEnd(resId: SharedResource, callback: Function): void
+}
+
+const modZlib = progpGetModule<ModZlib>("nodejsModZlib")!;
+
+//region Const & Interfaces
+
+export const constants = {
+    Z_NO_FLUSH: 0,
+    Z_PARTIAL_FLUSH: 1,
+    Z_SYNC_FLUSH: 2,
+    Z_FULL_FLUSH: 3,
+    Z_FINISH: 4,
+    Z_NO_COMPRESSION: 0,
+    Z_BEST_SPEED: 1,
+    Z_BEST_COMPRESSION: 9,
+    Z_DEFAULT_COMPRESSION: -1,
+    BROTLI_PARAM_QUALITY: 1,
+    BROTLI_MIN_QUALITY: 0,
+    BROTLI_MAX_QUALITY: 11,
+    BROTLI_DEFAULT_QUALITY: 11,
+}
+
+interface ZlibOptions {
+    /**
+     * Compression level, from 0 to 9. Default is -1, which is 6.
+     */
+    level?: number
+
+    /**
+     * Fails if the result exceeds this size. Default is no limit.
+     * Unlike Node.js, it also applies to the streams.
+     */
+    maxOutputLength?: number
+}
+
+interface BrotliOptions {
+    /**
+     * Allows setting the quality with params[constants.BROTLI_PARAM_QUALITY]. Default is 11.
+     */
+    params?: {[key:number]: number}
+
+    maxOutputLength?: number
+}
+
+type InputType = string|ArrayBuffer|ArrayBufferView;
+type CompressCallback = (err: any, result?: Uint8Array) => void;
+
+//endregion
+
+//region Tools
+
+function toArrayBuffer(v: InputType): ArrayBuffer {
+    if (typeof(v)==="string") return progpStringToBuffer(v);
+    if (v instanceof ArrayBuffer) return v;
+
+    if (ArrayBuffer.isView(v)) {
+        if ((v.byteOffset===0) && (v.byteLength===v.buffer.byteLength)) return v.buffer;
+        return v.buffer.slice(v.byteOffset, v.byteOffset + v.byteLength);
+    }
+
+    throw new TypeError("the data must be a string, a Buffer, a TypedArray or an ArrayBuffer");
+}
+
+function getLevel(format: string, options?: ZlibOptions|BrotliOptions): number {
+    if (!options) return -1;
+
+    if (format==="brotli") {
+        let params = (options as BrotliOptions).params;
+        if (params && (params[constants.BROTLI_PARAM_QUALITY]!==undefined)) return params[constants.BROTLI_PARAM_QUALITY];
+        return -1;
+    }
+
+    let level = (options as ZlibOptions).level;
+    return level===undefined ? -1 : level;
+}
+
+function getMaxOutputLength(options?: ZlibOptions|BrotliOptions): number {
+    return (options && options.maxOutputLength) || 0;
+}
+
+function compressSync(format: string, data: InputType, options?: ZlibOptions|BrotliOptions) {
+    return bufferFrom(modZlib.compressSync(format, toArrayBuffer(data), getLevel(format, options)));
+}
+
+function decompressSync(format: string, data: InputType, options?: ZlibOptions|BrotliOptions) {
+    let maxOutputLength = getMaxOutputLength(options);
+    return bufferFrom(modZlib.decompressSync(format, toArrayBuffer(data), maxOutputLength));
+}
+
+function compressAsync(format: string, data: InputType, options: ZlibOptions|BrotliOptions|CompressCallback|undefined, callback?: CompressCallback) {
+    if (options instanceof Function) {
+        callback = options;
+        options = undefined;
+    }
+
+    modZlib.compressAsync(format, toArrayBuffer(data), getLevel(format, options), (err: any, res: ArrayBuffer) => {
+        if (err) callback!(err);
+        else callback!(null, bufferFrom(res));
+    });
+}
+
+function decompressAsync(format: string, data: InputType, options: ZlibOptions|BrotliOptions|CompressCallback|undefined, callback?: CompressCallback) {
+    if (options instanceof Function) {
+        callback = options;
+        options = undefined;
+    }
+
+    let maxOutputLength = getMaxOutputLength(options);
+
+    modZlib.decompressAsync(format, toArrayBuffer(data), maxOutputLength, (err: any, res: ArrayBuffer) => {
+        if (err) callback!(err);
+        else callback!(null, bufferFrom(res));
+    });
+}
+
+//endregion
+
+//region Sync API
+
+export function gzipSync(data: InputType, options?: ZlibOptions) { return compressSync("gzip", data, options) }
+export function gunzipSync(data: InputType, options?: ZlibOptions) { return decompressSync("gzip", data, options) }
+export function deflateSync(data: InputType, options?: ZlibOptions) { return compressSync("deflate", data, options) }
+export function inflateSync(data: InputType, options?: ZlibOptions) { return decompressSync("deflate", data, options) }
+export function deflateRawSync(data: InputType, options?: ZlibOptions) { return compressSync("deflateRaw", data, options) }
+export function inflateRawSync(data: InputType, options?: ZlibOptions) { return decompressSync("deflateRaw", data, options) }
+export function unzipSync(data: InputType, options?: ZlibOptions) { return decompressSync("unzip", data, options) }
+export function brotliCompressSync(data: InputType, options?: BrotliOptions) { return compressSync("brotli", data, options) }
+export function brotliDecompressSync(data: InputType, options?: BrotliOptions) { return decompressSync("brotli", data, options) }
+
+//endregion
+
+//region Async API
+
+export function gzip(data: InputType, options?: ZlibOptions|CompressCallback, callback?: CompressCallback) { compressAsync("gzip", data, options, callback) }
+export function gunzip(data: InputType, options?: ZlibOptions|CompressCallback, callback?: CompressCallback) { decompressAsync("gzip", data, options, callback) }
+export function deflate(data: InputType, options?: ZlibOptions|CompressCallback, callback?: CompressCallback) { compressAsync("deflate", data, options, callback) }
+export function inflate(data: InputType, options?: ZlibOptions|CompressCallback, callback?: CompressCallback) { decompressAsync("deflate", data, options, callback) }
+export function deflateRaw(data: InputType, options?: ZlibOptions|CompressCallback, callback?: CompressCallback) { compressAsync("deflateRaw", data, options, callback) }
+export function inflateRaw(data: InputType, options?: ZlibOptions|CompressCallback, callback?: CompressCallback) { decompressAsync("deflateRaw", data, options, callback) }
+export function unzip(data: InputType, options?: ZlibOptions|CompressCallback, callback?: CompressCallback) { decompressAsync("unzip", data, options, callback) }
+export function brotliCompress(data: InputType, options?: BrotliOptions|CompressCallback, callback?: CompressCallback) { compressAsync("brotli", data, options, callback) }
+export function brotliDecompress(data: InputType, options?: BrotliOptions|CompressCallback, callback?: CompressCallback) { decompressAsync("brotli", data, options, callback) }
+
+//endregion
+
+//region Streams
+
+/**
+ * A stream compressing, or decompressing, the data written into it.
+ * The result is emitted with the "data" event, then "end" is emitted once the stream is ended.
+ */
+export class ZlibStream {
+    private readonly resId: SharedResource
+    private readonly listeners: {[event: string]: Function[]} = {};
+
+    // Each operation waits for the previous one, which keeps the chunks ordered.
+    private pending: Promise<void> = Promise.resolve();
+    private isEnded = false;
+    private isClosed = false;
+
+    constructor(format: string, compress: boolean, level: number, maxOutputLength: number = 0) {
+        this.resId = modZlib.streamCreate(format, compress, level, maxOutputLength);
+    }
+
+    on(event: "data"|"end"|"error"|"finish"|"close", listener: Function): this {
+        if (!this.listeners[event]) this.listeners[event] = [];
+        this.listeners[event].push(listener);
+        return this;
+    }
+
+    once(event: "data"|"end"|"error"|"finish"|"close", listener: Function): this {
+        let wrapper = (...args: any[]) => {
+            this.off(event, wrapper);
+            listener(...args);
+        };
+
+        return this.on(event, wrapper);
+    }
+
+    off(event: string, listener: Function): this {
+        let list = this.listeners[event];
+        if (list) this.listeners[event] = list.filter(l => l!==listener);
+        return this;
+    }
+
+    write(chunk: InputType, encoding?: string|Function, callback?: Function): boolean {
+        if (encoding instanceof Function) callback = encoding;
+
+        if (this.isEnded) {
+            this.fail(new Error("write after end"), callback);
+            return false;
+        }
+
+        let data = toArrayBuffer(chunk);
+        this.enqueue((cb) => modZlib.streamWrite(this.resId, data, cb), callback);
+        return true;
+    }
+
+    /**
+     * Emits all the data compressed until now, without ending the stream.
+     */
+    flush(callback?: Function) {
+        this.enqueue((cb) => modZlib.streamFlush(this.resId, cb), callback);
+    }
+
+    end(chunk?: InputType|Function, encoding?: string|Function, callback?: Function): this {
+        if (chunk instanceof Function) callback = chunk;
+        else if (encoding instanceof Function) callback = encoding;
+
+        if ((chunk!==undefined) && !(chunk instanceof Function)) this.write(chunk);
+        if (this.isEnded) return this;
+        this.isEnded = true;
+
+        this.enqueue((cb) => modZlib.streamEnd(this.resId, cb), (err?: any) => {
+            if (err) {
+                if (callback) callback(err);
+                return;
+            }
+
+            this.emit("finish");
+            this.emit("end");
+            this.close();
+            if (callback) callback();
+        });
+
+        return this;
+    }
+
+    /**
+     * Writes the result into the target, and ends it once this stream ends.
+     */
+    pipe<T extends {write: Function, end: Function}>(target: T): T {
+        this.on("data", (chunk: Uint8Array) => target.write(chunk));
+        this.on("end", () => target.end());
+        return target;
+    }
+
+    destroy(error?: any): this {
+        if (error) this.emit("error", error);
+        this.isEnded = true;
+        this.close();
+        return this;
+    }
+
+    async* [Symbol.asyncIterator](): AsyncGenerator<Uint8Array> {
+        let chunks: Uint8Array[] = [];
+        let isDone = false;
+        let error: any;
+        let wakeUp: Function|undefined;
+
+        let notify = () => {
+            if (wakeUp) {
+                let f = wakeUp;
+                wakeUp = undefined;
+                f();
+            }
+        };
+
+        this.on("data", (chunk: Uint8Array) => { chunks.push(chunk); notify() });
+        this.on("end", () => { isDone = true; notify() });
+        this.on("error", (e: any) => { error = e; notify() });
+
+        while (true) {
+            if (chunks.length) yield chunks.shift()!;
+            else if (error) throw error;
+            else if (isDone) return;
+            else await new Promise(resolve => wakeUp = resolve);
+        }
+    }
+
+    private enqueue(op: (cb: Function) => void, callback?: Function) {
+        this.pending = this.pending.then(() => new Promise<void>((resolve) => {
+            // After an error, the remaining operations are ignored.
+            if (this.isClosed) {
+                resolve();
+                return;
+            }
+
+            op((err: any, res: ArrayBuffer) => {
+                if (err) {
+                    this.fail(err, callback);
+                } else {
+                    if (res.byteLength) this.emit("data", bufferFrom(res));
+                    if (callback) callback();
+                }
+
+                resolve();
+            });
+        }));
+    }
+
+    private fail(err: any, callback?: Function) {
+        this.isEnded = true;
+        if (callback) callback(err);
+        this.emit("error", err);
+        this.close();
+    }
+
+    private close() {
+        if (this.isClosed) return;
+        this.isClosed = true;
+
+        progpDispose(this.resId);
+        this.emit("close");
+    }
+
+    private emit(event: string, ...args: any[]) {
+        let list = this.listeners[event];
+        if (list) for (let l of [...list]) l(...args);
+    }
+}
+
+export function createGzip(options?: ZlibOptions) { return new ZlibStream("gzip", true, getLevel("gzip", options), getMaxOutputLength(options)) }
+export function createGunzip(options?: ZlibOptions) { return new ZlibStream("gzip", false, 0, getMaxOutputLength(options)) }
+export function createDeflate(options?: ZlibOptions) { return new ZlibStream("deflate", true, getLevel("deflate", options), getMaxOutputLength(options)) }
+export function createInflate(options?: ZlibOptions) { return new ZlibStream("deflate", false, 0, getMaxOutputLength(options)) }
+export function createDeflateRaw(options?: ZlibOptions) { return new ZlibStream("deflateRaw", true, getLevel("deflateRaw", options), getMaxOutputLength(options)) }
+export function createInflateRaw(options?: ZlibOptions) { return new ZlibStream("deflateRaw", false, 0, getMaxOutputLength(options)) }
+export function createUnzip(options?: ZlibOptions) { return new ZlibStream("unzip", false, 0, getMaxOutputLength(options)) }
+export function createBrotliCompress(options?: BrotliOptions) { return new ZlibStream("brotli", true, getLevel("brotli", options), getMaxOutputLength(options)) }
+export function createBrotliDecompress(options?: BrotliOptions) { return new ZlibStream("brotli", false, 0, getMaxOutputLength(options)) }
+
+//endregion
+
+export default {
+    constants: constants,
+
+    //region Sync API
+
+    gzipSync: gzipSync,
+    gunzipSync: gunzipSync,
+    deflateSync: deflateSync,
+    inflateSync: inflateSync,
+    deflateRawSync: deflateRawSync,
+    inflateRawSync: inflateRawSync,
+    unzipSync: unzipSync,
+    brotliCompressSync: brotliCompressSync,
+    brotliDecompressSync: brotliDecompressSync,
+
+    //endregion
+
+    gzip: gzip,
+    gunzip: gunzip,
+    deflate: deflate,
+    inflate: inflate,
+    deflateRaw: deflateRaw,
+    inflateRaw: inflateRaw,
+    unzip: unzip,
+    brotliCompress: brotliCompress,
+    brotliDecompress: brotliDecompress,
+
+    createGzip: createGzip,
+    createGunzip: createGunzip,
+    createDeflate: createDeflate,
+    createInflate: createInflate,
+    createDeflateRaw: createDeflateRaw,
+    createInflateRaw: createInflateRaw,
+    createUnzip: createUnzip,
+    createBrotliCompress: createBrotliCompress,
+    createBrotliDecompress: createBrotliDecompress,
+}
@@ -0,0 +1,54 @@
+const test = require('node:test');
+const assert = require("node:assert");
+const zlib = require("node:zlib");
+
+function toText(buffer) {
+    return String.fromCharCode(...buffer);
+}
+
+test("NodeJS 'zlib.sync'", () => {
+    let text = "hello world ".repeat(100);
+
+    assert.strictEqual(toText(zlib.gunzipSync(zlib.gzipSync(text))), text);
+    assert.strictEqual(toText(zlib.inflateSync(zlib.deflateSync(text, {level: 9}))), text);
+    assert.strictEqual(toText(zlib.inflateRawSync(zlib.deflateRawSync(text))), text);
+    assert.strictEqual(toText(zlib.brotliDecompressSync(zlib.brotliCompressSync(text))), text);
+
+    assert.strictEqual(toText(zlib.unzipSync(zlib.gzipSync(text))), text);
+    assert.strictEqual(toText(zlib.unzipSync(zlib.deflateSync(text))), text);
+
+    assert.throws(() => zlib.gunzipSync(zlib.gzipSync(text), {maxOutputLength: 10}));
+});
+
+// Writes the chunks into the stream and returns his whole output.
+function streamAll(stream, chunks) {
+    return new Promise((resolve, reject) => {
+        let output = [];
+
+        stream.on("data", chunk => output.push(...chunk));
+        stream.on("error", reject);
+        stream.on("end", () => resolve(new Uint8Array(output)));
+
+        for (let chunk of chunks) stream.write(chunk);
+        stream.end();
+    });
+}
+
+test("NodeJS 'zlib.stream'", async () => {
+    let text = "hello world ".repeat(100);
+    let chunks = [text.substring(0, 500), text.substring(500)];
+
+    let gzipped = await streamAll(zlib.createGzip(), chunks);
+    assert.strictEqual(toText(await streamAll(zlib.createGunzip(), [gzipped])), text);
+
+    let compressed = await streamAll(zlib.createBrotliCompress(), chunks);
+    assert.strictEqual(toText(await streamAll(zlib.createBrotliDecompress(), [compressed])), text);
+
+    // The sync and stream formats are the same.
+    assert.strictEqual(toText(zlib.gunzipSync(gzipped)), text);
+
+    // Unlike Node.js, the streams also apply maxOutputLength.
+    let hasError = false;
+    try { await streamAll(zlib.createGunzip({maxOutputLength: 10}), [gzipped]) } catch (e) { hasError = true; }
+    assert.strictEqual(hasError, true, "maxOutputLength must apply to streams");
+});
@@ -115,6 +115,21 @@ func registerExportedFunctions() {
 	modFS.AddFunction("realpath", "JsFsRealpathSync", JsFsRealpathSync)
 
 	//endregion
+
+	//region node:zlib
+
+	modZlib := myMod.UseCustomGroup("nodejsModZlib")
+	modZlib.AddFunction("compressSync", "JsZlibCompressSync", JsZlibCompressSync)
+	modZlib.AddFunction("decompressSync", "JsZlibDecompressSync", JsZlibDecompressSync)
+	modZlib.AddAsyncFunction("compressAsync", "JsZlibCompressAsync", JsZlibCompressAsync)
+	modZlib.AddAsyncFunction("decompressAsync", "JsZlibDecompressAsync", JsZlibDecompressAsync)
+
+	modZlib.AddFunction("streamCreate", "JsZlibStreamCreate", JsZlibStreamCreate)
+	modZlib.AddAsyncFunction("streamWrite", "JsZlibStreamWriteAsync", JsZlibStreamWriteAsync)
+	modZlib.AddAsyncFunction("streamFlush", "JsZlibStreamFlushAsync", JsZlibStreamFlushAsync)
+	modZlib.AddAsyncFunction("streamEnd", "JsZlibStreamEndAsync", JsZlibStreamEndAsync)
+
+	//endregion
 }
 
 //region node:process	(nodejsModProcess)
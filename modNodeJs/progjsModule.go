@@ -51,4 +51,5 @@ func InstallProgpJsModule() {
 	registerEmbeddedModule("jsMods/@progp/nodejs/buffer.ts", "buffer", "node:buffer")
 	registerEmbeddedModule("jsMods/@progp/nodejs/timers.ts", "timers", "node:timers")
 	registerEmbeddedModule("jsMods/@progp/nodejs/url.ts", "url", "node:url")
+	registerEmbeddedModule("jsMods/@progp/nodejs/zlib.ts", "zlib", "node:zlib")
 }
@@ -0,0 +1,449 @@
+/*
+ * (C) Copyright 2024 Johan Michel PIQUET, France (https://johanpiquet.fr/).
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package modNodeJs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"github.com/andybalholm/brotli"
+	"github.com/progpjs/progpAPI/v2"
+	"github.com/valyala/fasthttp"
+	"io"
+	"sync"
+)
+
+//region node:zlib	(nodejsModZlib)
+
+// The formats are the names used by the javascript side.
+const (
+	ZlibFormatGzip       = "gzip"
+	ZlibFormatDeflate    = "deflate"
+	ZlibFormatDeflateRaw = "deflateRaw"
+	ZlibFormatBrotli     = "brotli"
+
+	// ZlibFormatUnzip detects if the data is gzip or deflate. Can only be used for decompressing.
+	ZlibFormatUnzip = "unzip"
+)
+
+var ZlibOutputTooLargeError = errors.New("cannot create a buffer larger than maxOutputLength")
+var ZlibStreamEndedError = errors.New("write after end")
+
+// zlibWriter is implemented by all the compressors.
+type zlibWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// normalizeZlibLevel converts the level given by javascript, where -1 is the default.
+func normalizeZlibLevel(format string, level int) int {
+	if format == ZlibFormatBrotli {
+		if (level < brotli.BestSpeed) || (level > brotli.BestCompression) {
+			return brotli.BestCompression
+		}
+	} else if (level < flate.HuffmanOnly) || (level > flate.BestCompression) {
+		return flate.DefaultCompression
+	}
+
+	return level
+}
+
+func newZlibWriter(format string, w io.Writer, level int) (zlibWriter, error) {
+	level = normalizeZlibLevel(format, level)
+
+	switch format {
+	case ZlibFormatGzip:
+		return gzip.NewWriterLevel(w, level)
+	case ZlibFormatDeflate:
+		return zlib.NewWriterLevel(w, level)
+	case ZlibFormatDeflateRaw:
+		return flate.NewWriter(w, level)
+	case ZlibFormatBrotli:
+		return brotli.NewWriterLevel(w, level), nil
+	}
+
+	return nil, errors.New("unknown compression format " + format)
+}
+
+func newZlibReader(format string, r io.Reader) (io.Reader, error) {
+	if format == ZlibFormatUnzip {
+		br := bufio.NewReader(r)
+		r = br
+
+		// The gzip format starts with the magic number 0x1f 0x8b.
+		if header, _ := br.Peek(2); bytes.Equal(header, []byte{0x1f, 0x8b}) {
+			format = ZlibFormatGzip
+		} else {
+			format = ZlibFormatDeflate
+		}
+	}
+
+	switch format {
+	case ZlibFormatGzip:
+		return gzip.NewReader(r)
+	case ZlibFormatDeflate:
+		return zlib.NewReader(r)
+	case ZlibFormatDeflateRaw:
+		return flate.NewReader(r), nil
+	case ZlibFormatBrotli:
+		return brotli.NewReader(r), nil
+	}
+
+	return nil, errors.New("unknown compression format " + format)
+}
+
+func zlibCompress(format string, data []byte, level int) ([]byte, error) {
+	level = normalizeZlibLevel(format, level)
+
+	// The fasthttp compressors are the ones used by the http server, and reuse their internal state.
+	switch format {
+	case ZlibFormatGzip:
+		return fasthttp.AppendGzipBytesLevel(nil, data, level), nil
+	case ZlibFormatDeflate:
+		return fasthttp.AppendDeflateBytesLevel(nil, data, level), nil
+	case ZlibFormatBrotli:
+		return fasthttp.AppendBrotliBytesLevel(nil, data, level), nil
+	}
+
+	var buffer bytes.Buffer
+
+	w, err := newZlibWriter(format, &buffer, level)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// zlibDecompress uncompress the data. If maxOutputLength is more than zero,
+// then the decompression stops with an error once this size is exceeded.
+func zlibDecompress(format string, data []byte, maxOutputLength int) ([]byte, error) {
+	r, err := newZlibReader(format, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if maxOutputLength > 0 {
+		r = io.LimitReader(r, int64(maxOutputLength)+1)
+	}
+
+	res, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if (maxOutputLength > 0) && (len(res) > maxOutputLength) {
+		return nil, ZlibOutputTooLargeError
+	}
+
+	return res, nil
+}
+
+func JsZlibCompressSync(format string, data []byte, level int) ([]byte, error) {
+	return zlibCompress(format, data, level)
+}
+
+func JsZlibDecompressSync(format string, data []byte, maxOutputLength int) ([]byte, error) {
+	return zlibDecompress(format, data, maxOutputLength)
+}
+
+func JsZlibCompressAsync(format string, data []byte, level int, callback progpAPI.JsFunction) {
+	// The buffer memory is owned by javascript, it must be copied.
+	data = bytes.Clone(data)
+
+	progpAPI.SafeGoRoutine(func() {
+		res, err := zlibCompress(format, data, level)
+
+		if err == nil {
+			callback.CallWithArrayBuffer2(res)
+		} else {
+			callback.CallWithError(err)
+		}
+	})
+}
+
+func JsZlibDecompressAsync(format string, data []byte, maxOutputLength int, callback progpAPI.JsFunction) {
+	// The buffer memory is owned by javascript, it must be copied.
+	data = bytes.Clone(data)
+
+	progpAPI.SafeGoRoutine(func() {
+		res, err := zlibDecompress(format, data, maxOutputLength)
+
+		if err == nil {
+			callback.CallWithArrayBuffer2(res)
+		} else {
+			callback.CallWithError(err)
+		}
+	})
+}
+
+// zlibOutput is a buffer written and drained from different goroutines.
+type zlibOutput struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+
+	// maxOutputLength, if more than zero, is the maximum total size written.
+	maxOutputLength int
+	totalWritten    int
+}
+
+func (m *zlibOutput) Write(p []byte) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if (m.maxOutputLength > 0) && (m.totalWritten+len(p) > m.maxOutputLength) {
+		return 0, ZlibOutputTooLargeError
+	}
+
+	m.totalWritten += len(p)
+	return m.buffer.Write(p)
+}
+
+// drain returns the content of the buffer and empties it.
+func (m *zlibOutput) drain() []byte {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	res := bytes.Clone(m.buffer.Bytes())
+	m.buffer.Reset()
+	return res
+}
+
+// zlibStream compresses or decompresses the data while it's written.
+// The compressors write directly into the output, while the decompressors
+// read their input from a pipe, inside a goroutine.
+type zlibStream struct {
+	// mutex avoids concurrent writes, and writing while ending.
+	mutex   sync.Mutex
+	isEnded bool
+	output  zlibOutput
+
+	// compressor is set when compressing.
+	compressor zlibWriter
+
+	// input is set when decompressing.
+	input        *io.PipeWriter
+	decoderDone  chan bool
+	decoderError error
+}
+
+// newZlibStream creates a stream. If maxOutputLength is more than zero,
+// then the stream fails once the total size of his output exceeds this size.
+func newZlibStream(format string, compress bool, level int, maxOutputLength int) (*zlibStream, error) {
+	m := &zlibStream{}
+	m.output.maxOutputLength = maxOutputLength
+
+	if compress {
+		var err error
+
+		m.compressor, err = newZlibWriter(format, &m.output, level)
+		if err != nil {
+			return nil, err
+		}
+
+		return m, nil
+	}
+
+	switch format {
+	case ZlibFormatGzip, ZlibFormatDeflate, ZlibFormatDeflateRaw, ZlibFormatBrotli, ZlibFormatUnzip:
+	default:
+		return nil, errors.New("unknown compression format " + format)
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	m.input = pipeWriter
+	m.decoderDone = make(chan bool)
+
+	progpAPI.SafeGoRoutine(func() {
+		r, err := newZlibReader(format, pipeReader)
+
+		if err == nil {
+			_, err = io.Copy(&m.output, r)
+		}
+
+		m.decoderError = err
+
+		// Unblock the writer if the decoder stops before the end of the input.
+		if err == nil {
+			_ = pipeReader.CloseWithError(errors.New("unexpected data after the end of the compressed stream"))
+		} else {
+			_ = pipeReader.CloseWithError(err)
+		}
+
+		close(m.decoderDone)
+	})
+
+	return m, nil
+}
+
+// write adds data to the stream and returns the output available.
+func (m *zlibStream) write(data []byte) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.isEnded {
+		return nil, ZlibStreamEndedError
+	}
+
+	var err error
+
+	if m.compressor != nil {
+		_, err = m.compressor.Write(data)
+	} else {
+		_, err = m.input.Write(data)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return m.output.drain(), nil
+}
+
+// flush forces the compressor to output all the data written until now.
+func (m *zlibStream) flush() ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.isEnded {
+		return nil, ZlibStreamEndedError
+	}
+
+	if m.compressor != nil {
+		if err := m.compressor.Flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	return m.output.drain(), nil
+}
+
+// end closes the stream and returns the remaining output.
+func (m *zlibStream) end() ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.isEnded {
+		return nil, ZlibStreamEndedError
+	}
+
+	m.isEnded = true
+
+	if m.compressor != nil {
+		if err := m.compressor.Close(); err != nil {
+			return nil, err
+		}
+	} else {
+		_ = m.input.Close()
+		<-m.decoderDone
+
+		if m.decoderError != nil {
+			return nil, m.decoderError
+		}
+	}
+
+	return m.output.drain(), nil
+}
+
+func (m *zlibStream) dispose() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.isEnded {
+		m.isEnded = true
+
+		// Stops the decoder goroutine.
+		if m.input != nil {
+			_ = m.input.CloseWithError(ZlibStreamEndedError)
+		}
+	}
+}
+
+func getZlibStream(resStream *progpAPI.SharedResource) (*zlibStream, error) {
+	stream, ok := resStream.Value.(*zlibStream)
+	if !ok {
+		return nil, errors.New("invalid resource")
+	}
+
+	return stream, nil
+}
+
+// JsZlibStreamCreate creates a stream compressing, or decompressing, the data written into it.
+func JsZlibStreamCreate(rc *progpAPI.SharedResourceContainer, format string, compress bool, level int, maxOutputLength int) (*progpAPI.SharedResource, error) {
+	stream, err := newZlibStream(format, compress, level, maxOutputLength)
+	if err != nil {
+		return nil, err
+	}
+
+	return rc.NewSharedResource(stream, func(value any) {
+		value.(*zlibStream).dispose()
+	}), nil
+}
+
+// callWithZlibStreamResult calls the function in a goroutine, since a decompressor can block while waiting for his input.
+func callWithZlibStreamResult(resStream *progpAPI.SharedResource, callback progpAPI.JsFunction, f func(stream *zlibStream) ([]byte, error)) {
+	stream, err := getZlibStream(resStream)
+	if err != nil {
+		callback.CallWithError(err)
+		return
+	}
+
+	progpAPI.SafeGoRoutine(func() {
+		res, err := f(stream)
+
+		if err == nil {
+			callback.CallWithArrayBuffer2(res)
+		} else {
+			callback.CallWithError(err)
+		}
+	})
+}
+
+// JsZlibStreamWriteAsync writes data into the stream and returns the output available, which can be empty.
+func JsZlibStreamWriteAsync(resStream *progpAPI.SharedResource, data []byte, callback progpAPI.JsFunction) {
+	// The buffer memory is owned by javascript, it must be copied.
+	data = bytes.Clone(data)
+
+	callWithZlibStreamResult(resStream, callback, func(stream *zlibStream) ([]byte, error) {
+		return stream.write(data)
+	})
+}
+
+func JsZlibStreamFlushAsync(resStream *progpAPI.SharedResource, callback progpAPI.JsFunction) {
+	callWithZlibStreamResult(resStream, callback, func(stream *zlibStream) ([]byte, error) {
+		return stream.flush()
+	})
+}
+
+func JsZlibStreamEndAsync(resStream *progpAPI.SharedResource, callback progpAPI.JsFunction) {
+	callWithZlibStreamResult(resStream, callback, func(stream *zlibStream) ([]byte, error) {
+		return stream.end()
+	})
+}
+
+//endregion